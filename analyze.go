@@ -0,0 +1,173 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+
+package webp
+
+import (
+	"image"
+)
+
+// Mode is the choice of the compression format by Encode.
+type Mode int
+
+const (
+	ModeManual Mode = iota // Options.Lossless chooses the format
+	ModeAuto               // chosen from an analysis of the image
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeManual:
+		return "manual"
+	case ModeAuto:
+		return "auto"
+	}
+	return "unknown"
+}
+
+const (
+	analyzeMaxColors = 256     // palette size of the lossless encoder
+	analyzeMaxPixels = 1 << 20 // pixels sampled by analyzeImage
+	analyzeEdgeStep  = 48      // luma step of an edge, in 8-bit units
+	analyzeSmallSize = 128 * 128
+
+	// the strength of the near-lossless preprocessing of the synthetic
+	// images with too many colors for a palette (near_lossless 60, as
+	// cwebp -near_lossless 60)
+	analyzeNearLossless = 40
+)
+
+// imageAnalysis are the content statistics of an image, the fully
+// transparent pixels are not counted.
+type imageAnalysis struct {
+	Colors      int     // distinct colors, up to analyzeMaxColors+1
+	Alpha       bool    // some pixels are not opaque
+	BinaryAlpha bool    // the alpha is only 0 or 255
+	EdgeDensity float64 // neighbours with a luma step of analyzeEdgeStep
+	FlatDensity float64 // neighbours with the same color
+	Small       bool
+}
+
+// analyzeImage computes the statistics of m, on a subset of its rows for
+// the large images.
+func analyzeImage(m image.Image) (a imageAnalysis) {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	a.Small = w*h <= analyzeSmallSize
+	a.BinaryAlpha = true
+	if w <= 0 || h <= 0 {
+		return
+	}
+
+	step := 1
+	for w*((h+step-1)/step) > analyzeMaxPixels {
+		step++
+	}
+
+	read, _ := newRowReader(m)
+	row := make([]uint32, 4*w)
+	next := make([]uint32, 4*w)
+	colors := make(map[[4]uint8]struct{}, analyzeMaxColors+1)
+	var pairs, edges, flats int
+
+	pixel := func(row []uint32, x int) (c [4]uint8) {
+		s := row[4*x:][:4]
+		return [4]uint8{uint8(s[0] >> 8), uint8(s[1] >> 8), uint8(s[2] >> 8), uint8(s[3] >> 8)}
+	}
+	luma := func(c [4]uint8) int {
+		return (299*int(c[0]) + 587*int(c[1]) + 114*int(c[2])) / 1000
+	}
+	compare := func(c0, c1 [4]uint8) {
+		if c1[3] == 0 {
+			return
+		}
+		pairs++
+		if c0 == c1 {
+			flats++
+		} else if d := luma(c0) - luma(c1); d >= analyzeEdgeStep || d <= -analyzeEdgeStep {
+			edges++
+		}
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		read(y, row)
+		hasNext := y+1 < b.Max.Y
+		if hasNext {
+			read(y+1, next)
+		}
+		for x := 0; x < w; x++ {
+			c := pixel(row, x)
+			if c[3] == 0 {
+				a.Alpha = true
+				continue
+			}
+			if c[3] != 0xff {
+				a.Alpha, a.BinaryAlpha = true, false
+			}
+			if len(colors) <= analyzeMaxColors {
+				colors[c] = struct{}{}
+			}
+			if x+1 < w {
+				compare(c, pixel(row, x+1))
+			}
+			if hasNext {
+				compare(c, pixel(next, x))
+			}
+		}
+	}
+
+	a.Colors = len(colors)
+	if pairs > 0 {
+		a.EdgeDensity = float64(edges) / float64(pairs)
+		a.FlatDensity = float64(flats) / float64(pairs)
+	}
+	return
+}
+
+// Photo reports whether the image looks like a natural image: too many
+// colors for a palette, and few flat areas.
+func (a imageAnalysis) Photo() bool {
+	return a.Colors > analyzeMaxColors && a.FlatDensity < 0.5
+}
+
+// autoEncoding is the format and preset chosen by ModeAuto.
+type autoEncoding struct {
+	Lossless     bool
+	NearLossless int // strength, see encoderOptions
+	Preset       WebPPreset
+}
+
+// choose returns the encoding of the analysed image:
+//
+//   - the images fitting in a palette are lossless, as icons, text or
+//     drawings;
+//   - the other synthetic images (screenshots, charts, ...) are
+//     near-lossless, but lossless with a partial alpha;
+//   - the natural images are lossy, as photos or, for the small ones, as
+//     pictures.
+func (a imageAnalysis) choose() autoEncoding {
+	preset := WEBP_PRESET_DRAWING
+	switch {
+	case a.Small && a.Colors > 16:
+		preset = WEBP_PRESET_ICON
+	case a.Colors <= 16 && a.EdgeDensity > 0.05:
+		preset = WEBP_PRESET_TEXT
+	}
+
+	switch {
+	case a.Colors <= analyzeMaxColors:
+		return autoEncoding{Lossless: true, Preset: preset}
+	case !a.Photo() && a.Alpha && !a.BinaryAlpha:
+		// the anti-aliased edges over transparency show the preprocessing
+		return autoEncoding{Lossless: true, Preset: preset}
+	case !a.Photo():
+		return autoEncoding{Lossless: true, NearLossless: analyzeNearLossless, Preset: preset}
+	case a.Small:
+		return autoEncoding{Preset: WEBP_PRESET_PICTURE}
+	default:
+		return autoEncoding{Preset: WEBP_PRESET_PHOTO}
+	}
+}
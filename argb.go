@@ -0,0 +1,147 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+)
+
+var (
+	_ image.Image = (*ARGBImage)(nil)
+	_ MemP        = (*ARGBImage)(nil)
+)
+
+// ARGBImage is an in-memory image whose pixels are stored in A, R, G, B order,
+// as decoded with MODE_ARGB (or MODE_Argb if XPremultiplied).
+//
+// Note the MemP interface has no channel order, use At to read the colors.
+type ARGBImage struct {
+	XPix           []uint8
+	XStride        int
+	XRect          image.Rectangle
+	XPremultiplied bool // alpha-premultiplied
+}
+
+func (p *ARGBImage) MemPMagic() string {
+	return MemPMagic
+}
+
+func (p *ARGBImage) Bounds() image.Rectangle {
+	return p.XRect
+}
+
+func (p *ARGBImage) Channels() int {
+	return 4
+}
+
+func (p *ARGBImage) DataType() reflect.Kind {
+	return reflect.Uint8
+}
+
+func (p *ARGBImage) Pix() []byte {
+	return p.XPix
+}
+
+func (p *ARGBImage) Stride() int {
+	return p.XStride
+}
+
+func (p *ARGBImage) ColorModel() color.Model {
+	if p.XPremultiplied {
+		return color.RGBAModel
+	}
+	return color.NRGBAModel
+}
+
+func (p *ARGBImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		if p.XPremultiplied {
+			return color.RGBA{}
+		}
+		return color.NRGBA{}
+	}
+	i := p.PixOffset(x, y)
+	r, g, b, a := p.XPix[i+1], p.XPix[i+2], p.XPix[i+3], p.XPix[i+0]
+	if p.XPremultiplied {
+		return color.RGBA{R: r, G: g, B: b, A: a}
+	}
+	return color.NRGBA{R: r, G: g, B: b, A: a}
+}
+
+// PixOffset returns the index of the first element of XPix that corresponds to
+// the pixel at (x, y).
+func (p *ARGBImage) PixOffset(x, y int) int {
+	return (y-p.XRect.Min.Y)*p.XStride + (x-p.XRect.Min.X)*4
+}
+
+func (p *ARGBImage) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		return
+	}
+	var r, g, b, a uint8
+	if p.XPremultiplied {
+		c1 := color.RGBAModel.Convert(c).(color.RGBA)
+		r, g, b, a = c1.R, c1.G, c1.B, c1.A
+	} else {
+		c1 := color.NRGBAModel.Convert(c).(color.NRGBA)
+		r, g, b, a = c1.R, c1.G, c1.B, c1.A
+	}
+	i := p.PixOffset(x, y)
+	p.XPix[i+0] = a
+	p.XPix[i+1] = r
+	p.XPix[i+2] = g
+	p.XPix[i+3] = b
+	return
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *ARGBImage) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.XRect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the XPix[i:] expression below can panic.
+	if r.Empty() {
+		return &ARGBImage{XPremultiplied: p.XPremultiplied}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &ARGBImage{
+		XPix:           p.XPix[i:],
+		XStride:        p.XStride,
+		XRect:          r,
+		XPremultiplied: p.XPremultiplied,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *ARGBImage) Opaque() bool {
+	if p.XRect.Empty() {
+		return true
+	}
+	i0, i1 := 0, p.XRect.Dx()*4
+	for y := p.XRect.Min.Y; y < p.XRect.Max.Y; y++ {
+		for i := i0; i < i1; i += 4 {
+			if p.XPix[i] != 0xff {
+				return false
+			}
+		}
+		i0 += p.XStride
+		i1 += p.XStride
+	}
+	return true
+}
+
+// NewARGBImage returns a new ARGBImage with the given bounds.
+func NewARGBImage(r image.Rectangle) *ARGBImage {
+	w, h := r.Dx(), r.Dy()
+	pix := make([]uint8, 4*w*h)
+	return &ARGBImage{
+		XPix:    pix,
+		XStride: 4 * w,
+		XRect:   r,
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"math"
+)
+
+// AutoTarget is the distortion reached by EncodeAuto.
+type AutoTarget struct {
+	Metric Metric  // PSNR, SSIM or LSIM
+	Value  float32 // minimum of the all channels result of Distortion, in dB
+}
+
+// QualityBounds is the range of the quality searched by EncodeAuto. The
+// zero value searches the whole 0 ~ 100 range.
+type QualityBounds struct {
+	Min, Max int
+}
+
+// EncodeAuto encodes m in the lossy format with the lowest quality of
+// bounds which reaches the target distortion, and returns the smallest
+// output meeting it with its quality.
+//
+// The quality is binary-searched with a trial encode and a Distortion
+// measure per step, the trials are cached, so a search costs at most
+// log2(Max - Min + 1) + 1 encodes. If the target is not reached at the
+// Max quality, the Max quality output is returned.
+func EncodeAuto(m image.Image, target AutoTarget, bounds QualityBounds) (data []byte, quality float32, err error) {
+	if target.Metric < PSNR || target.Metric > LSIM {
+		err = errors.New("webp: EncodeAuto, unknown metric")
+		return
+	}
+	if bounds == (QualityBounds{}) {
+		bounds.Max = 100
+	}
+	if bounds.Min < 0 || bounds.Max > 100 || bounds.Min > bounds.Max {
+		err = errors.New("webp: EncodeAuto, invalid quality bounds")
+		return
+	}
+	b := m.Bounds()
+	if b.Empty() {
+		err = errors.New("webp: EncodeAuto, empty image")
+		return
+	}
+
+	src := adjustLossyImage(m, DitherTruncate)
+	ref := toRGBAImage(m)
+
+	type trial struct {
+		Data  []byte
+		Score float32
+	}
+	trials := make(map[int]*trial)
+	encodeTrial := func(q int) (*trial, error) {
+		if t, ok := trials[q]; ok {
+			return t, nil
+		}
+		buf := new(bytes.Buffer)
+		if err := encodeImage(buf, src, &encoderOptions{Quality: float32(q)}); err != nil {
+			return nil, err
+		}
+		data := buf.Bytes()
+		dist, err := DecodeRGBA(data)
+		if err != nil {
+			return nil, err
+		}
+		score, err := webpPictureDistortion(ref.Pix, ref.Stride, dist.Pix, dist.Stride, b.Dx(), b.Dy(), int(target.Metric))
+		if err != nil {
+			return nil, err
+		}
+		t := &trial{Data: data, Score: score[4]}
+		trials[q] = t
+		return t, nil
+	}
+
+	// the lowest quality reaching the target, assuming the distortion
+	// decreases with the quality
+	lo, hi := bounds.Min, bounds.Max
+	t, err := encodeTrial(hi)
+	if err != nil {
+		return
+	}
+	if t.Score < target.Value {
+		return t.Data, float32(hi), nil
+	}
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if t, err = encodeTrial(mid); err != nil {
+			return
+		}
+		if t.Score >= target.Value {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	// the size is not strictly monotonic, keep the smallest passing trial
+	size := math.MaxInt
+	for q, t := range trials {
+		if t.Score >= target.Value && (len(t.Data) < size || len(t.Data) == size && float32(q) < quality) {
+			data, quality, size = t.Data, float32(q), len(t.Data)
+		}
+	}
+	return
+}
@@ -0,0 +1,380 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+
+package webp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrSkipped is the error of the jobs which are not converted by Batch after
+// a failure in the FailFast mode.
+var ErrSkipped = errors.New("webp: Batch, job skipped after a failure")
+
+// batchBytesPerPixel is the memory estimate of a job per pixel: the decoded
+// image, its 8-bit RGBA conversion, the libwebp picture and the working
+// memory of the encoder.
+const batchBytesPerPixel = 16
+
+// BatchJob is a conversion of Batch, from Input or InputPath to Output or
+// OutputPath. The input is decoded by image.Decode, in the formats
+// registered with the image package (import image/png, image/jpeg, ...).
+type BatchJob struct {
+	Input      io.Reader
+	InputPath  string
+	Output     io.Writer
+	OutputPath string
+	Options    *Options // BatchOptions.Options if nil
+}
+
+func (job *BatchJob) name() string {
+	if job.InputPath != "" {
+		return job.InputPath
+	}
+	return "reader"
+}
+
+// BatchOptions are the parameters of Batch.
+type BatchOptions struct {
+	// Workers is the count of concurrent conversions, GOMAXPROCS if 0. The
+	// cgo calls of libwebp block their OS threads.
+	Workers int
+
+	// MemoryLimit bounds the estimated memory of the running conversions,
+	// in bytes: the size of the input, and 16 bytes per pixel from the
+	// header of the image. A job above the limit runs alone. 0 is no limit.
+	MemoryLimit int64
+
+	// FailFast stops at the first failure, the jobs not converted yet have
+	// the ErrSkipped error. Otherwise all the jobs are converted, and the
+	// errors are collected.
+	FailFast bool
+
+	// Options are the encoding parameters of the jobs without Options.
+	Options *Options
+}
+
+// BatchResult is the result of a job of Batch.
+type BatchResult struct {
+	Index      int // of the job, in the slice or in the order of the channel
+	Job        BatchJob
+	Width      int
+	Height     int
+	InputSize  int64
+	OutputSize int64
+	Duration   time.Duration
+	Skipped    bool // not converted, after a failure or a cancellation
+	Err        error
+}
+
+// BatchStats are the aggregate statistics of Batch.
+type BatchStats struct {
+	Jobs      int // Succeeded + Failed + Skipped
+	Succeeded int
+	Failed    int
+	Skipped   int
+
+	// the sizes and the pixels of the succeeded jobs
+	InputBytes  int64
+	OutputBytes int64
+	Pixels      int64
+
+	Elapsed time.Duration // wall time of Batch
+	JobTime time.Duration // sum of the job durations
+}
+
+// Ratio returns OutputBytes / InputBytes.
+func (s *BatchStats) Ratio() float64 {
+	if s.InputBytes == 0 {
+		return 0
+	}
+	return float64(s.OutputBytes) / float64(s.InputBytes)
+}
+
+func (s *BatchStats) add(r *BatchResult) {
+	s.Jobs++
+	s.JobTime += r.Duration
+	switch {
+	case r.Skipped:
+		s.Skipped++
+	case r.Err != nil:
+		s.Failed++
+	default:
+		s.Succeeded++
+		s.InputBytes += r.InputSize
+		s.OutputBytes += r.OutputSize
+		s.Pixels += int64(r.Width) * int64(r.Height)
+	}
+}
+
+// Batch converts the jobs to WebP on a pool of workers, and returns their
+// results in the order of jobs, with their statistics.
+//
+// The returned error is the first failure in the FailFast mode, the join
+// of the failures otherwise, and includes the error of ctx if it is done.
+// The canceled jobs are Skipped. The running conversions are not
+// interrupted in the decoding of the input or in the encoding, libwebp
+// writes the output only after the whole image is encoded: a canceled
+// conversion is skipped before its encoding, or aborted at its write, so
+// the cancellation waits for the decodes and encodes in progress.
+func Batch(ctx context.Context, jobs []BatchJob, opt *BatchOptions) ([]BatchResult, BatchStats, error) {
+	in := make(chan BatchJob)
+	go func() {
+		defer close(in)
+		for _, job := range jobs {
+			in <- job
+		}
+	}()
+
+	out := make(chan BatchResult)
+	results := make([]BatchResult, len(jobs))
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for r := range out {
+			results[r.Index] = r
+		}
+	}()
+
+	stats, err := BatchChan(ctx, in, out, opt)
+	<-collected
+	return results, stats, err
+}
+
+// BatchChan is Batch with the jobs received from jobs until it is closed.
+// The results are sent to results, if not nil, as the jobs complete, and
+// results is closed when BatchChan returns. The jobs received after a
+// failure in the FailFast mode, or after the cancellation of ctx, are
+// Skipped.
+func BatchChan(ctx context.Context, jobs <-chan BatchJob, results chan<- BatchResult, opt *BatchOptions) (stats BatchStats, err error) {
+	if results != nil {
+		defer close(results)
+	}
+	var o BatchOptions
+	if opt != nil {
+		o = *opt
+	}
+	workers := o.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var mem *batchMemory
+	if o.MemoryLimit > 0 {
+		mem = newBatchMemory(runCtx, o.MemoryLimit)
+		defer mem.Stop()
+	}
+
+	type task struct {
+		Index int
+		Job   BatchJob
+	}
+	tasks := make(chan task)
+	go func() {
+		defer close(tasks)
+		var i int
+		for job := range jobs {
+			tasks <- task{i, job}
+			i++
+		}
+	}()
+
+	done := make(chan BatchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				done <- runBatchJob(runCtx, t.Index, t.Job, &o, mem)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	start := time.Now()
+	var errs []error
+	for r := range done {
+		stats.add(&r)
+		if r.Err != nil && !r.Skipped {
+			errs = append(errs, fmt.Errorf("webp: Batch, job %d (%s): %w", r.Index, r.Job.name(), r.Err))
+			if o.FailFast {
+				cancel(ErrSkipped)
+			}
+		}
+		if results != nil {
+			results <- r
+		}
+	}
+	stats.Elapsed = time.Since(start)
+
+	if err := ctx.Err(); err != nil {
+		errs = append([]error{err}, errs...)
+	}
+	if o.FailFast && len(errs) > 0 {
+		return stats, errs[0]
+	}
+	return stats, errors.Join(errs...)
+}
+
+// runBatchJob converts a job, unless ctx is done.
+func runBatchJob(ctx context.Context, index int, job BatchJob, opt *BatchOptions, mem *batchMemory) (r BatchResult) {
+	r = BatchResult{Index: index, Job: job}
+	if ctx.Err() != nil {
+		r.Err, r.Skipped = context.Cause(ctx), true
+		return
+	}
+	start := time.Now()
+	r.Err = r.convert(ctx, opt, mem)
+	r.Duration = time.Since(start)
+	r.Skipped = r.Err != nil && ctx.Err() != nil && errors.Is(r.Err, context.Cause(ctx))
+	return
+}
+
+func (r *BatchResult) convert(ctx context.Context, opt *BatchOptions, mem *batchMemory) (err error) {
+	job := &r.Job
+	var data []byte
+	switch {
+	case (job.Input == nil) == (job.InputPath == ""):
+		return errors.New("webp: Batch, job needs one of Input and InputPath")
+	case (job.Output == nil) == (job.OutputPath == ""):
+		return errors.New("webp: Batch, job needs one of Output and OutputPath")
+	case job.Input != nil:
+		data, err = io.ReadAll(job.Input)
+	default:
+		data, err = os.ReadFile(job.InputPath)
+	}
+	if err != nil {
+		return
+	}
+	r.InputSize = int64(len(data))
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	r.Width, r.Height = config.Width, config.Height
+	if mem != nil {
+		n, err := mem.Acquire(ctx, r.InputSize+int64(config.Width)*int64(config.Height)*batchBytesPerPixel)
+		if err != nil {
+			return err
+		}
+		defer mem.Release(n)
+	}
+
+	m, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	data = nil
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	options := job.Options
+	if options == nil {
+		options = opt.Options
+	}
+	w := job.Output
+	if job.OutputPath != "" {
+		f, cerr := os.Create(job.OutputPath)
+		if cerr != nil {
+			return cerr
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(job.OutputPath)
+			}
+		}()
+		w = f
+	}
+
+	bw := bufio.NewWriter(&batchWriter{Ctx: ctx, W: w, N: &r.OutputSize})
+	if err = Encode(bw, m, options); err != nil {
+		return
+	}
+	return bw.Flush()
+}
+
+// batchWriter aborts the encoding when its context is done.
+type batchWriter struct {
+	Ctx context.Context
+	W   io.Writer
+	N   *int64
+}
+
+func (w *batchWriter) Write(p []byte) (int, error) {
+	if w.Ctx.Err() != nil {
+		return 0, context.Cause(w.Ctx)
+	}
+	n, err := w.W.Write(p)
+	*w.N += int64(n)
+	return n, err
+}
+
+// batchMemory is a weighted semaphore of the memory of the running jobs.
+type batchMemory struct {
+	Stop func() bool // of the wake up on the cancellation of the context
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	limit, used int64
+}
+
+func newBatchMemory(ctx context.Context, limit int64) *batchMemory {
+	m := &batchMemory{limit: limit}
+	m.cond = sync.NewCond(&m.mu)
+	m.Stop = context.AfterFunc(ctx, func() {
+		m.mu.Lock()
+		m.cond.Broadcast()
+		m.mu.Unlock()
+	})
+	return m
+}
+
+// Acquire waits until n bytes are available, or ctx is done, and returns
+// the acquired size, which is the limit for a larger n.
+func (m *batchMemory) Acquire(ctx context.Context, n int64) (int64, error) {
+	n = min(n, m.limit)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.used+n > m.limit {
+		if ctx.Err() != nil {
+			return 0, context.Cause(ctx)
+		}
+		m.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return 0, context.Cause(ctx)
+	}
+	m.used += n
+	return n, nil
+}
+
+func (m *batchMemory) Release(n int64) {
+	m.mu.Lock()
+	m.used -= n
+	m.mu.Unlock()
+	m.cond.Broadcast()
+}
@@ -0,0 +1,184 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	png, err := os.ReadFile(testdataDir + "video-001.png")
+	tAssertNil(t, err)
+
+	var outputs [2]bytes.Buffer
+	jobs := []BatchJob{
+		{InputPath: testdataDir + "tux.png", OutputPath: filepath.Join(dir, "tux.webp")},
+		{Input: bytes.NewReader(png), Output: &outputs[0]},
+		{InputPath: testdataDir + "yellow_rose.lossy.webp", OutputPath: filepath.Join(dir, "rose.webp"), Options: &Options{Lossless: true}},
+		{Input: bytes.NewReader(png), Output: &outputs[1], Options: &Options{Quality: 10}},
+	}
+	results, stats, err := Batch(context.Background(), jobs, &BatchOptions{Workers: 3, Options: &Options{Quality: 75}})
+	tAssertNil(t, err)
+	tAssertEQ(t, len(jobs), len(results))
+	for i, r := range results {
+		tAssertEQ(t, i, r.Index)
+		tAssertNil(t, r.Err, i)
+		tAssert(t, !r.Skipped && r.Width > 0 && r.Height > 0 && r.InputSize > 0 && r.OutputSize > 0, i, r)
+	}
+	tAssertEQ(t, BatchStats{Jobs: 4, Succeeded: 4}, BatchStats{Jobs: stats.Jobs, Succeeded: stats.Succeeded})
+	tAssert(t, stats.Ratio() > 0 && stats.Pixels > 0 && stats.Elapsed > 0, stats)
+
+	// the outputs are the encodes of the options of the jobs
+	for i, filename := range []string{"tux.webp", "rose.webp"} {
+		data, err := os.ReadFile(filepath.Join(dir, filename))
+		tAssertNil(t, err, filename)
+		tAssertEQ(t, results[2*i].OutputSize, len(data), filename)
+		_, err = DecodeRGBA(data)
+		tAssertNil(t, err, filename)
+	}
+	lossy, err := Features(outputs[0].Bytes())
+	tAssertNil(t, err)
+	tAssertEQ(t, FormatLossy, lossy.Format)
+	tAssert(t, outputs[1].Len() < outputs[0].Len(), outputs[1].Len(), outputs[0].Len())
+	rose, err := os.ReadFile(filepath.Join(dir, "rose.webp"))
+	tAssertNil(t, err)
+	features, err := Features(rose)
+	tAssertNil(t, err)
+	tAssertEQ(t, FormatLossless, features.Format)
+}
+
+func TestBatch_errors(t *testing.T) {
+	dir := t.TempDir()
+	jobs := []BatchJob{
+		{InputPath: testdataDir + "tux.png", OutputPath: filepath.Join(dir, "0.webp")},
+		{Input: strings.NewReader("not an image"), OutputPath: filepath.Join(dir, "1.webp")},
+		{InputPath: testdataDir + "tux.png", OutputPath: filepath.Join(dir, "2.webp")},
+		{InputPath: testdataDir + "tux.png"},
+	}
+
+	// the errors are collected
+	results, stats, err := Batch(context.Background(), jobs, &BatchOptions{Workers: 2})
+	tAssert(t, err != nil)
+	tAssert(t, strings.Contains(err.Error(), "job 1") && strings.Contains(err.Error(), "job 3"), err)
+	tAssertEQ(t, 2, stats.Succeeded)
+	tAssertEQ(t, 2, stats.Failed)
+	tAssert(t, results[1].Err != nil && results[3].Err != nil)
+	_, err = os.Stat(filepath.Join(dir, "1.webp"))
+	tAssert(t, os.IsNotExist(err), err)
+
+	// the first failure stops the batch
+	results, stats, err = Batch(context.Background(), jobs[1:], &BatchOptions{Workers: 1, FailFast: true})
+	tAssert(t, err != nil && strings.Contains(err.Error(), "job 0"), err)
+	tAssertEQ(t, 1, stats.Failed)
+	tAssertEQ(t, 2, stats.Skipped)
+	tAssert(t, results[1].Skipped && errors.Is(results[1].Err, ErrSkipped), results[1])
+}
+
+func TestBatch_cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	jobs := []BatchJob{
+		{InputPath: testdataDir + "tux.png", Output: new(bytes.Buffer)},
+		{InputPath: testdataDir + "tux.png", Output: new(bytes.Buffer)},
+	}
+	results, stats, err := Batch(ctx, jobs, nil)
+	tAssert(t, errors.Is(err, context.Canceled), err)
+	tAssertEQ(t, 2, stats.Skipped)
+	tAssert(t, results[0].Skipped && results[1].Skipped)
+
+	// canceled while the job runs, before the encoding
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	png, err := os.ReadFile(testdataDir + "tux.png")
+	tAssertNil(t, err)
+	output := filepath.Join(t.TempDir(), "tux.webp")
+	jobs = []BatchJob{{Input: &tCancelReader{bytes.NewReader(png), cancel}, OutputPath: output}}
+	results, _, err = Batch(ctx, jobs, nil)
+	tAssert(t, errors.Is(err, context.Canceled), err)
+	tAssert(t, results[0].Skipped && results[0].Width > 0 && results[0].OutputSize == 0, results[0])
+	_, err = os.Stat(output)
+	tAssert(t, os.IsNotExist(err), err)
+}
+
+// tCancelReader calls Cancel at the end of R.
+type tCancelReader struct {
+	R      *bytes.Reader
+	Cancel context.CancelFunc
+}
+
+func (r *tCancelReader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	if err == io.EOF {
+		r.Cancel()
+	}
+	return n, err
+}
+
+func TestBatchChan(t *testing.T) {
+	jobs := make(chan BatchJob)
+	results := make(chan BatchResult)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < 6; i++ {
+			jobs <- BatchJob{InputPath: testdataDir + "video-001.png", Output: new(bytes.Buffer)}
+		}
+	}()
+
+	// the jobs larger than the memory limit run alone
+	done := make(chan BatchStats)
+	go func() {
+		stats, _ := BatchChan(context.Background(), jobs, results, &BatchOptions{Workers: 4, MemoryLimit: 1})
+		done <- stats
+	}()
+	var seen [6]bool
+	for r := range results {
+		tAssertNil(t, r.Err, r.Index)
+		seen[r.Index] = true
+	}
+	tAssertEQ(t, [6]bool{true, true, true, true, true, true}, seen)
+	tAssertEQ(t, 6, (<-done).Succeeded)
+}
+
+func TestBatchMemory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := newBatchMemory(ctx, 100)
+	defer m.Stop()
+
+	n, err := m.Acquire(ctx, 60)
+	tAssertNil(t, err)
+	tAssertEQ(t, 60, n)
+
+	acquired := make(chan int64)
+	go func() {
+		n, _ := m.Acquire(ctx, 1000) // the limit, after the release
+		acquired <- n
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired above the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+	m.Release(n)
+	tAssertEQ(t, 100, <-acquired)
+
+	// waiting is canceled with the context
+	errc := make(chan error)
+	go func() {
+		_, err := m.Acquire(ctx, 1)
+		errc <- err
+	}()
+	cancel()
+	err = <-errc
+	tAssert(t, errors.Is(err, context.Canceled), err)
+}
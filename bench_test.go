@@ -6,6 +6,8 @@ package webp
 
 import (
 	"bytes"
+	"image"
+	"io"
 	"io/ioutil"
 	"testing"
 )
@@ -59,6 +61,7 @@ func BenchmarkDecodeRGBA(b *testing.B) {
 	if err != nil {
 		b.Fatal(err)
 	}
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		m, err := DecodeRGBA(data)
@@ -70,6 +73,54 @@ func BenchmarkDecodeRGBA(b *testing.B) {
 	b.StopTimer()
 }
 
+func BenchmarkDecodeNRGBA(b *testing.B) {
+	data, err := ioutil.ReadFile("./testdata/1_webp_ll.webp")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m, err := DecodeNRGBA(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = m
+	}
+	b.StopTimer()
+}
+
+func BenchmarkDecodeRGBAInto(b *testing.B) {
+	data, err := ioutil.ReadFile("./testdata/1_webp_ll.webp")
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := image.NewRGBA(image.Rect(0, 0, 400, 301))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := DecodeRGBAInto(data, m.Pix, m.Stride); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+}
+
+func BenchmarkDecodeYCbCr(b *testing.B) {
+	data, err := ioutil.ReadFile("./testdata/video-001.lossy.webp")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m, err := DecodeYCbCr(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = m
+	}
+	b.StopTimer()
+}
+
 func BenchmarkDecodeGrayToSize(b *testing.B) {
 	data, err := ioutil.ReadFile("./testdata/1_webp_ll.webp")
 	if err != nil {
@@ -138,3 +189,92 @@ func BenchmarkEncodeAndDecode(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkDecodeRGBA_decoder is BenchmarkDecodeRGBA with a Decoder, whose
+// released images are reused.
+func BenchmarkDecodeRGBA_decoder(b *testing.B) {
+	data, err := ioutil.ReadFile("./testdata/1_webp_ll.webp")
+	if err != nil {
+		b.Fatal(err)
+	}
+	d, err := NewDecoder(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m, err := d.Decode(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		d.Release(m)
+	}
+	b.StopTimer()
+}
+
+// tBenchmarkRGBA returns a *image.RGBA with transparent pixels, which Encode
+// converts to NRGBA.
+func tBenchmarkRGBA(b *testing.B) *image.RGBA {
+	img, err := loadImage("tux.png")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return toRGBAImage(img)
+}
+
+func BenchmarkEncodeRGBA(b *testing.B) {
+	m := tBenchmarkRGBA(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Encode(io.Discard, m, &Options{Lossless: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+}
+
+// BenchmarkEncodeRGBA_encoder is BenchmarkEncodeRGBA with an Encoder, which
+// reuses its pictures and conversion buffers.
+func BenchmarkEncodeRGBA_encoder(b *testing.B) {
+	m := tBenchmarkRGBA(b)
+	enc, err := NewEncoder(&Options{Lossless: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(io.Discard, m); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+}
+
+func BenchmarkConvert(b *testing.B) {
+	for _, v := range tConvertTesterList() {
+		m := v.Image
+		b.Run(v.Name+"/Gray", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = convertToGray(m)
+			}
+		})
+		b.Run(v.Name+"/RGB", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = convertToRGB(m)
+			}
+		})
+		b.Run(v.Name+"/RGBA", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = convertToRGBA(m)
+			}
+		})
+		b.Run(v.Name+"/NRGBA", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = convertToNRGBA(m)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+)
+
+var (
+	_ image.Image = (*BGRImage)(nil)
+	_ MemP        = (*BGRImage)(nil)
+)
+
+// BGRImage is an in-memory image whose pixels are stored in B, G, R order,
+// as decoded with MODE_BGR.
+//
+// Note the MemP interface has no channel order, use At to read the colors.
+type BGRImage struct {
+	XPix    []uint8
+	XStride int
+	XRect   image.Rectangle
+}
+
+func (p *BGRImage) MemPMagic() string {
+	return MemPMagic
+}
+
+func (p *BGRImage) Bounds() image.Rectangle {
+	return p.XRect
+}
+
+func (p *BGRImage) Channels() int {
+	return 3
+}
+
+func (p *BGRImage) DataType() reflect.Kind {
+	return reflect.Uint8
+}
+
+func (p *BGRImage) Pix() []byte {
+	return p.XPix
+}
+
+func (p *BGRImage) Stride() int {
+	return p.XStride
+}
+
+func (p *BGRImage) ColorModel() color.Model { return color.RGBAModel }
+
+func (p *BGRImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		return color.RGBA{}
+	}
+	i := p.PixOffset(x, y)
+	return color.RGBA{
+		R: p.XPix[i+2],
+		G: p.XPix[i+1],
+		B: p.XPix[i+0],
+		A: 0xff,
+	}
+}
+
+func (p *BGRImage) BGRAt(x, y int) [3]uint8 {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		return [3]uint8{}
+	}
+	i := p.PixOffset(x, y)
+	return [3]uint8{
+		p.XPix[i+0],
+		p.XPix[i+1],
+		p.XPix[i+2],
+	}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *BGRImage) PixOffset(x, y int) int {
+	return (y-p.XRect.Min.Y)*p.XStride + (x-p.XRect.Min.X)*3
+}
+
+func (p *BGRImage) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	c1 := color.RGBAModel.Convert(c).(color.RGBA)
+	p.XPix[i+0] = c1.B
+	p.XPix[i+1] = c1.G
+	p.XPix[i+2] = c1.R
+	return
+}
+
+func (p *BGRImage) SetBGR(x, y int, c [3]uint8) {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	p.XPix[i+0] = c[0]
+	p.XPix[i+1] = c[1]
+	p.XPix[i+2] = c[2]
+	return
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *BGRImage) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.XRect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:] expression below can panic.
+	if r.Empty() {
+		return &BGRImage{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &BGRImage{
+		XPix:    p.XPix[i:],
+		XStride: p.XStride,
+		XRect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *BGRImage) Opaque() bool {
+	return true
+}
+
+// NewBGRImage returns a new BGRImage with the given bounds.
+func NewBGRImage(r image.Rectangle) *BGRImage {
+	w, h := r.Dx(), r.Dy()
+	pix := make([]uint8, 3*w*h)
+	return &BGRImage{
+		XPix:    pix,
+		XStride: 3 * w,
+		XRect:   r,
+	}
+}
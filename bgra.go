@@ -0,0 +1,147 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+)
+
+var (
+	_ image.Image = (*BGRAImage)(nil)
+	_ MemP        = (*BGRAImage)(nil)
+)
+
+// BGRAImage is an in-memory image whose pixels are stored in B, G, R, A order,
+// as decoded with MODE_BGRA (or MODE_bgrA if XPremultiplied).
+//
+// Note the MemP interface has no channel order, use At to read the colors.
+type BGRAImage struct {
+	XPix           []uint8
+	XStride        int
+	XRect          image.Rectangle
+	XPremultiplied bool // alpha-premultiplied
+}
+
+func (p *BGRAImage) MemPMagic() string {
+	return MemPMagic
+}
+
+func (p *BGRAImage) Bounds() image.Rectangle {
+	return p.XRect
+}
+
+func (p *BGRAImage) Channels() int {
+	return 4
+}
+
+func (p *BGRAImage) DataType() reflect.Kind {
+	return reflect.Uint8
+}
+
+func (p *BGRAImage) Pix() []byte {
+	return p.XPix
+}
+
+func (p *BGRAImage) Stride() int {
+	return p.XStride
+}
+
+func (p *BGRAImage) ColorModel() color.Model {
+	if p.XPremultiplied {
+		return color.RGBAModel
+	}
+	return color.NRGBAModel
+}
+
+func (p *BGRAImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		if p.XPremultiplied {
+			return color.RGBA{}
+		}
+		return color.NRGBA{}
+	}
+	i := p.PixOffset(x, y)
+	r, g, b, a := p.XPix[i+2], p.XPix[i+1], p.XPix[i+0], p.XPix[i+3]
+	if p.XPremultiplied {
+		return color.RGBA{R: r, G: g, B: b, A: a}
+	}
+	return color.NRGBA{R: r, G: g, B: b, A: a}
+}
+
+// PixOffset returns the index of the first element of XPix that corresponds to
+// the pixel at (x, y).
+func (p *BGRAImage) PixOffset(x, y int) int {
+	return (y-p.XRect.Min.Y)*p.XStride + (x-p.XRect.Min.X)*4
+}
+
+func (p *BGRAImage) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		return
+	}
+	var r, g, b, a uint8
+	if p.XPremultiplied {
+		c1 := color.RGBAModel.Convert(c).(color.RGBA)
+		r, g, b, a = c1.R, c1.G, c1.B, c1.A
+	} else {
+		c1 := color.NRGBAModel.Convert(c).(color.NRGBA)
+		r, g, b, a = c1.R, c1.G, c1.B, c1.A
+	}
+	i := p.PixOffset(x, y)
+	p.XPix[i+0] = b
+	p.XPix[i+1] = g
+	p.XPix[i+2] = r
+	p.XPix[i+3] = a
+	return
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *BGRAImage) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.XRect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the XPix[i:] expression below can panic.
+	if r.Empty() {
+		return &BGRAImage{XPremultiplied: p.XPremultiplied}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &BGRAImage{
+		XPix:           p.XPix[i:],
+		XStride:        p.XStride,
+		XRect:          r,
+		XPremultiplied: p.XPremultiplied,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *BGRAImage) Opaque() bool {
+	if p.XRect.Empty() {
+		return true
+	}
+	i0, i1 := 3, p.XRect.Dx()*4
+	for y := p.XRect.Min.Y; y < p.XRect.Max.Y; y++ {
+		for i := i0; i < i1; i += 4 {
+			if p.XPix[i] != 0xff {
+				return false
+			}
+		}
+		i0 += p.XStride
+		i1 += p.XStride
+	}
+	return true
+}
+
+// NewBGRAImage returns a new BGRAImage with the given bounds.
+func NewBGRAImage(r image.Rectangle) *BGRAImage {
+	w, h := r.Dx(), r.Dy()
+	pix := make([]uint8, 4*w*h)
+	return &BGRAImage{
+		XPix:    pix,
+		XStride: 4 * w,
+		XRect:   r,
+	}
+}
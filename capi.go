@@ -78,7 +78,7 @@ uint8_t* webpDecodeAnimFirstFrame(const uint8_t* data, size_t data_size,
     }
     
     // 解码第一帧
-    uint8_t* rgba = WebPDecodeRGBA(iter.fragment.bytes, iter.fragment.size, width, height);
+    uint8_t* rgba = webpDecodePremulRGBA(iter.fragment.bytes, iter.fragment.size, width, height);
     
     WebPDemuxReleaseIterator(&iter);
     WebPDemuxDelete(demux);
@@ -86,78 +86,80 @@ uint8_t* webpDecodeAnimFirstFrame(const uint8_t* data, size_t data_size,
     return rgba;
 }
 
+// 释放帧数据
+void webpFreeFrames(uint8_t** frames, int* timestamps, int* durations, int frame_count) {
+    if (frames) {
+        for (int i = 0; i < frame_count; i++) {
+            if (frames[i]) free(frames[i]);
+        }
+        free(frames);
+    }
+    if (timestamps) free(timestamps);
+    if (durations) free(durations);
+}
+
 // 解码动画WebP的所有帧
+// The frames are composited on the canvas by the animation decoder, in
+// premultiplied RGBA. The timestamps are the start of the frames, in ms.
 int webpDecodeAnimFrames(const uint8_t* data, size_t data_size,
                         uint8_t*** frames, int** timestamps, int** durations,
                         int* frame_count, int* width, int* height) {
     WebPData webp_data = {data, data_size};
-    WebPDemuxer* demux = WebPDemux(&webp_data);
-    if (!demux) return 0;
-    
-    *frame_count = WebPDemuxGetI(demux, WEBP_FF_FRAME_COUNT);
-    *width = WebPDemuxGetI(demux, WEBP_FF_CANVAS_WIDTH);
-    *height = WebPDemuxGetI(demux, WEBP_FF_CANVAS_HEIGHT);
-    
-    if (*frame_count <= 0) {
-        WebPDemuxDelete(demux);
+    WebPAnimDecoderOptions options;
+    if (!WebPAnimDecoderOptionsInit(&options)) return 0;
+    options.color_mode = MODE_rgbA;
+
+    WebPAnimDecoder* dec = WebPAnimDecoderNew(&webp_data, &options);
+    if (!dec) return 0;
+
+    WebPAnimInfo info;
+    if (!WebPAnimDecoderGetInfo(dec, &info) || info.frame_count == 0) {
+        WebPAnimDecoderDelete(dec);
         return 0;
     }
-    
-    // 分配内存
-    *frames = (uint8_t**)malloc(*frame_count * sizeof(uint8_t*));
-    *timestamps = (int*)malloc(*frame_count * sizeof(int));
-    *durations = (int*)malloc(*frame_count * sizeof(int));
-    
+    *width = info.canvas_width;
+    *height = info.canvas_height;
+    *frame_count = 0;
+
+    const size_t size = (size_t)info.canvas_width * 4 * info.canvas_height;
+    *frames = (uint8_t**)calloc(info.frame_count, sizeof(uint8_t*));
+    *timestamps = (int*)malloc(info.frame_count * sizeof(int));
+    *durations = (int*)malloc(info.frame_count * sizeof(int));
     if (!*frames || !*timestamps || !*durations) {
-        if (*frames) free(*frames);
-        if (*timestamps) free(*timestamps);
-        if (*durations) free(*durations);
-        WebPDemuxDelete(demux);
+        webpFreeFrames(*frames, *timestamps, *durations, 0);
+        WebPAnimDecoderDelete(dec);
         return 0;
     }
-    
-    WebPIterator iter;
-    int frame_idx = 0;
-    
-    if (WebPDemuxGetFrame(demux, 1, &iter)) {
-        do {
-            if (frame_idx >= *frame_count) break;
-            
-            int frame_width, frame_height;
-            uint8_t* rgba = WebPDecodeRGBA(iter.fragment.bytes, iter.fragment.size, 
-                                          &frame_width, &frame_height);
-            
-            if (rgba) {
-                (*frames)[frame_idx] = rgba;
-                (*timestamps)[frame_idx] = frame_idx * 100; // 简单的时间戳计算
-                (*durations)[frame_idx] = iter.duration;
-                frame_idx++;
-            }
-        } while (WebPDemuxNextFrame(&iter));
-        
-        WebPDemuxReleaseIterator(&iter);
-    }
-    
-    WebPDemuxDelete(demux);
-    return frame_idx; // 返回实际解码的帧数
-}
 
-// 释放帧数据
-void webpFreeFrames(uint8_t** frames, int* timestamps, int* durations, int frame_count) {
-    if (frames) {
-        for (int i = 0; i < frame_count; i++) {
-            if (frames[i]) free(frames[i]);
+    int start = 0;
+    while (*frame_count < (int)info.frame_count && WebPAnimDecoderHasMoreFrames(dec)) {
+        uint8_t* buf;
+        int end;
+        uint8_t* frame = NULL;
+        if (!WebPAnimDecoderGetNext(dec, &buf, &end) || !(frame = (uint8_t*)malloc(size))) {
+            webpFreeFrames(*frames, *timestamps, *durations, *frame_count);
+            WebPAnimDecoderDelete(dec);
+            return 0;
         }
-        free(frames);
+        memcpy(frame, buf, size);
+        (*frames)[*frame_count] = frame;
+        (*timestamps)[*frame_count] = start;
+        (*durations)[*frame_count] = end - start;
+        (*frame_count)++;
+        start = end;
     }
-    if (timestamps) free(timestamps);
-    if (durations) free(durations);
+    WebPAnimDecoderDelete(dec);
+    return *frame_count;
 }
+
 */
 import "C"
 import (
 	"errors"
 	"image"
+	"image/color"
+	"io"
+	"runtime/cgo"
 	"unsafe"
 )
 
@@ -180,6 +182,43 @@ func webpGetInfo(data []byte) (width, height int, hasAlpha bool, err error) {
 	return
 }
 
+func webpGetFeatures(data []byte) (features *ImageFeatures, err error) {
+	if len(data) == 0 {
+		err = errors.New("webpGetFeatures: bad arguments, data is empty")
+		return
+	}
+
+	var cf C.webpFeatures
+	if C.webpGetFeatures((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &cf) == 0 {
+		err = errors.New("webpGetFeatures: failed")
+		return
+	}
+
+	flags := uint32(cf.format_flags)
+	bgcolor := uint32(cf.background_color)
+	features = &ImageFeatures{
+		Width:        int(cf.width),
+		Height:       int(cf.height),
+		Format:       Format(cf.format),
+		HasAlpha:     cf.has_alpha != 0,
+		HasAnimation: cf.has_animation != 0,
+		HasICCP:      flags&C.ICCP_FLAG != 0,
+		HasEXIF:      flags&C.EXIF_FLAG != 0,
+		HasXMP:       flags&C.XMP_FLAG != 0,
+		CanvasWidth:  int(cf.canvas_width),
+		CanvasHeight: int(cf.canvas_height),
+		FrameCount:   int(cf.frame_count),
+		LoopCount:    int(cf.loop_count),
+		BackgroundColor: color.NRGBA{
+			B: uint8(bgcolor),
+			G: uint8(bgcolor >> 8),
+			R: uint8(bgcolor >> 16),
+			A: uint8(bgcolor >> 24),
+		},
+	}
+	return
+}
+
 func webpDecodeGray(data []byte) (pix []byte, width, height int, err error) {
 	if len(data) == 0 {
 		err = errors.New("webpDecodeGray: bad arguments")
@@ -240,6 +279,26 @@ func webpDecodeRGBA(data []byte) (pix []byte, width, height int, err error) {
 	return
 }
 
+func webpDecodePremulRGBA(data []byte) (pix []byte, width, height int, err error) {
+	if len(data) == 0 {
+		err = errors.New("webpDecodePremulRGBA: bad arguments")
+		return
+	}
+
+	var cw, ch C.int
+	var cptr = C.webpDecodePremulRGBA((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &cw, &ch)
+	if cptr == nil {
+		err = errors.New("webpDecodePremulRGBA: failed")
+		return
+	}
+	defer C.free(unsafe.Pointer(cptr))
+
+	pix = make([]byte, int(cw*ch*4))
+	copy(pix, ((*[1 << 30]byte)(unsafe.Pointer(cptr)))[0:len(pix):len(pix)])
+	width, height = int(cw), int(ch)
+	return
+}
+
 func webpDecodeGrayToSize(data []byte, width, height int) (pix []byte, err error) {
 	pix = make([]byte, int(width*height))
 	stride := C.int(width)
@@ -262,17 +321,131 @@ func webpDecodeRGBToSize(data []byte, width, height int) (pix []byte, err error)
 	return
 }
 
-func webpDecodeRGBAToSize(data []byte, width, height int) (pix []byte, err error) {
+func webpDecodePremulRGBAToSize(data []byte, width, height int) (pix []byte, err error) {
 	pix = make([]byte, int(4*width*height))
 	stride := C.int(4 * width)
-	res := C.webpDecodeRGBAToSize((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), C.int(width), C.int(height), stride, (*C.uint8_t)(unsafe.Pointer(&pix[0])))
+	res := C.webpDecodePremulRGBAToSize((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), C.int(width), C.int(height), stride, (*C.uint8_t)(unsafe.Pointer(&pix[0])))
 	if res != C.VP8_STATUS_OK {
 		pix = nil
-		err = errors.New("webpDecodeRGBAToSize: failed")
+		err = errors.New("webpDecodePremulRGBAToSize: failed")
+	}
+	return
+}
+
+func webpDecodeInto(data []byte, mode WEBP_CSP_MODE, pix []byte, stride int) (err error) {
+	if len(data) == 0 || len(pix) == 0 || stride <= 0 {
+		err = errors.New("webpDecodeInto: bad arguments")
+		return
+	}
+
+	res := C.webpDecodeInto(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)),
+		C.int(mode), (*C.uint8_t)(unsafe.Pointer(&pix[0])), C.int(stride), C.size_t(len(pix)),
+	)
+	if res != C.VP8_STATUS_OK {
+		err = errors.New("webpDecodeInto: failed")
 	}
 	return
 }
 
+func webpDecodeRGBInto(data, pix []byte, stride int) error {
+	return webpDecodeInto(data, MODE_RGB, pix, stride)
+}
+
+func webpDecodeRGBAInto(data, pix []byte, stride int) error {
+	return webpDecodeInto(data, MODE_RGBA, pix, stride)
+}
+
+func webpDecodePremulRGBAInto(data, pix []byte, stride int) error {
+	return webpDecodeInto(data, MODE_rgbA, pix, stride)
+}
+
+func webpDecodeRegion(data []byte, crop image.Rectangle, width, height, dithering, alphaDithering int, pix []byte, stride int) (err error) {
+	if len(data) == 0 || crop.Empty() || width <= 0 || height <= 0 || stride < 4*width || len(pix) < (height-1)*stride+4*width {
+		err = errors.New("webpDecodeRegion: bad arguments")
+		return
+	}
+
+	res := C.webpDecodeRegion(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)),
+		C.int(crop.Min.X), C.int(crop.Min.Y), C.int(crop.Dx()), C.int(crop.Dy()),
+		C.int(width), C.int(height),
+		C.int(dithering), C.int(alphaDithering),
+		(*C.uint8_t)(unsafe.Pointer(&pix[0])), C.int(stride), C.size_t(len(pix)),
+	)
+	if res != C.VP8_STATUS_OK {
+		err = errors.New("webpDecodeRegion: failed")
+	}
+	return
+}
+
+func webpDecodeYUVAInto(data []byte, y []byte, yStride int, u, v []byte, uvStride int, a []byte, aStride int) (err error) {
+	if len(data) == 0 || len(y) == 0 || len(u) == 0 || len(v) == 0 || len(u) != len(v) {
+		err = errors.New("webpDecodeYUVAInto: bad arguments")
+		return
+	}
+
+	var aptr *C.uint8_t
+	if len(a) != 0 {
+		aptr = (*C.uint8_t)(unsafe.Pointer(&a[0]))
+	}
+	res := C.webpDecodeYUVAInto(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)),
+		(*C.uint8_t)(unsafe.Pointer(&y[0])), C.int(yStride), C.size_t(len(y)),
+		(*C.uint8_t)(unsafe.Pointer(&u[0])), (*C.uint8_t)(unsafe.Pointer(&v[0])), C.int(uvStride), C.size_t(len(u)),
+		aptr, C.int(aStride), C.size_t(len(a)),
+	)
+	if res != C.VP8_STATUS_OK {
+		err = errors.New("webpDecodeYUVAInto: failed")
+	}
+	return
+}
+
+func webpINewRGBA() (idec *C.WebPIDecoder, err error) {
+	if idec = C.WebPINewRGB(C.MODE_RGBA, nil, 0, 0); idec == nil {
+		err = errors.New("webpINewRGBA: failed")
+		return
+	}
+	return
+}
+
+// webpIAppend appends data to the input of idec, and decodes it. It
+// returns done when the image is fully decoded.
+func webpIAppend(idec *C.WebPIDecoder, data []byte) (done bool, err error) {
+	if idec == nil || len(data) == 0 {
+		err = errors.New("webpIAppend: bad arguments")
+		return
+	}
+	switch C.WebPIAppend(idec, (*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data))) {
+	case C.VP8_STATUS_OK:
+		return true, nil
+	case C.VP8_STATUS_SUSPENDED:
+		return false, nil
+	}
+	err = errors.New("webpIAppend: failed")
+	return
+}
+
+// webpIDecGetRGBA returns the output of idec, and its count of decoded
+// rows. pix is in C memory, owned by idec, it is nil until the headers are
+// decoded.
+func webpIDecGetRGBA(idec *C.WebPIDecoder) (pix []byte, lastY, width, height, stride int) {
+	var cy, cw, ch, cs C.int
+	var cptr = C.WebPIDecGetRGB(idec, &cy, &cw, &ch, &cs)
+	if cptr == nil {
+		return
+	}
+	lastY, width, height, stride = int(cy), int(cw), int(ch), int(cs)
+	pix = unsafe.Slice((*byte)(unsafe.Pointer(cptr)), (height-1)*stride+4*width)
+	return
+}
+
+func webpIDelete(idec *C.WebPIDecoder) {
+	if idec != nil {
+		C.WebPIDelete(idec)
+	}
+}
+
 func webpEncodeGray(pix []byte, width, height, stride int, quality float32) (output []byte, err error) {
 	if len(pix) == 0 || width <= 0 || height <= 0 || stride <= 0 || quality < 0.0 {
 		err = errors.New("webpEncodeGray: bad arguments")
@@ -354,6 +527,196 @@ func webpEncodeRGBA(pix []byte, width, height, stride int, quality float32) (out
 	return
 }
 
+// encoderOptions are the parameters of webpEncodePix and webpEncodeYUVA.
+type encoderOptions struct {
+	Lossless     bool
+	Quality      float32
+	Exact        bool
+	Preset       WebPPreset
+	NearLossless int // preprocessing strength, 100 - the near_lossless of libwebp
+	UseSharpYUV  bool
+
+	LosslessLevel   int // 0 ~ 9, lossless only
+	UseDeltaPalette bool
+	ExactAlpha      bool
+
+	Crop         image.Rectangle // relative to the picture, empty for no cropping
+	ResizeWidth  int             // 0 for no rescaling, or to keep the aspect ratio
+	ResizeHeight int
+}
+
+func (p *encoderOptions) c() (opt C.webpEncoderOptions) {
+	if p.Lossless {
+		opt.lossless = 1
+	}
+	opt.quality = C.float(p.Quality)
+	if p.Exact {
+		opt.exact = 1
+	}
+	opt.preset = C.int(p.Preset)
+	opt.near_lossless = C.int(100 - p.NearLossless)
+	if p.UseSharpYUV {
+		opt.use_sharp_yuv = 1
+	}
+	opt.lossless_level = C.int(p.LosslessLevel)
+	if p.UseDeltaPalette {
+		opt.use_delta_palette = 1
+	}
+	if p.ExactAlpha {
+		opt.exact_alpha = 1
+	}
+	if !p.Crop.Empty() {
+		opt.crop_x = C.int(p.Crop.Min.X)
+		opt.crop_y = C.int(p.Crop.Min.Y)
+		opt.crop_width = C.int(p.Crop.Dx())
+		opt.crop_height = C.int(p.Crop.Dy())
+	}
+	opt.resize_width = C.int(p.ResizeWidth)
+	opt.resize_height = C.int(p.ResizeHeight)
+	return
+}
+
+func (p *encoderOptions) valid(width, height int) bool {
+	if p.Quality < 0 || p.Quality > 100 || p.ResizeWidth < 0 || p.ResizeHeight < 0 ||
+		p.Preset < WEBP_PRESET_DEFAULT || p.Preset > WEBP_PRESET_TEXT || p.NearLossless < 0 || p.NearLossless > 100 ||
+		p.LosslessLevel < 0 || p.LosslessLevel > 9 {
+		return false
+	}
+	return p.Crop.Empty() || p.Crop.In(image.Rect(0, 0, width, height))
+}
+
+func webpEncodePix(w io.Writer, pix []byte, channels, width, height, stride int, opt *encoderOptions) (err error) {
+	if len(pix) == 0 || width <= 0 || height <= 0 || stride <= 0 || !opt.valid(width, height) {
+		err = errors.New("webpEncodePix: bad arguments")
+		return
+	}
+	if (channels != 1 && channels != 3 && channels != 4) || stride < width*channels || len(pix) < (height-1)*stride+width*channels {
+		err = errors.New("webpEncodePix: bad arguments")
+		return
+	}
+
+	var copt = opt.c()
+	var writer = &goWriter{W: w}
+	var handle = cgo.NewHandle(writer)
+	defer handle.Delete()
+
+	var rv = C.webpEncodePix(
+		(*C.uint8_t)(unsafe.Pointer(&pix[0])), C.int(channels), C.int(width), C.int(height),
+		C.int(stride), &copt,
+		C.uintptr_t(handle),
+	)
+	if writer.Err != nil {
+		err = writer.Err
+		return
+	}
+	if rv == 0 {
+		err = errors.New("webpEncodePix: failed")
+		return
+	}
+	return
+}
+
+func webpEncodeYUVA(w io.Writer, y, u, v, a []byte, width, height, yStride, uvStride, aStride int, opt *encoderOptions) (err error) {
+	if len(y) == 0 || len(u) == 0 || len(v) == 0 || width <= 0 || height <= 0 || !opt.valid(width, height) {
+		err = errors.New("webpEncodeYUVA: bad arguments")
+		return
+	}
+	uvWidth, uvHeight := (width+1)/2, (height+1)/2
+	if yStride < width || len(y) < (height-1)*yStride+width ||
+		uvStride < uvWidth || len(u) < (uvHeight-1)*uvStride+uvWidth || len(v) < len(u) {
+		err = errors.New("webpEncodeYUVA: bad arguments")
+		return
+	}
+	var aptr *C.uint8_t
+	if len(a) != 0 {
+		if aStride < width || len(a) < (height-1)*aStride+width {
+			err = errors.New("webpEncodeYUVA: bad arguments")
+			return
+		}
+		aptr = (*C.uint8_t)(unsafe.Pointer(&a[0]))
+	}
+
+	var copt = opt.c()
+	var writer = &goWriter{W: w}
+	var handle = cgo.NewHandle(writer)
+	defer handle.Delete()
+
+	var rv = C.webpEncodeYUVA(
+		(*C.uint8_t)(unsafe.Pointer(&y[0])), (*C.uint8_t)(unsafe.Pointer(&u[0])), (*C.uint8_t)(unsafe.Pointer(&v[0])), aptr,
+		C.int(width), C.int(height), C.int(yStride), C.int(uvStride), C.int(aStride),
+		&copt,
+		C.uintptr_t(handle),
+	)
+	if writer.Err != nil {
+		err = writer.Err
+		return
+	}
+	if rv == 0 {
+		err = errors.New("webpEncodeYUVA: failed")
+		return
+	}
+	return
+}
+
+func webpNewStripPicture(width, height int, opt *encoderOptions) (pic *C.WebPPicture, useARGB bool, err error) {
+	if width <= 0 || height <= 0 || !opt.valid(width, height) {
+		err = errors.New("webpNewStripPicture: bad arguments")
+		return
+	}
+	var copt = opt.c()
+	if pic = C.webpNewStripPicture(C.int(width), C.int(height), &copt); pic == nil {
+		err = errors.New("webpNewStripPicture: failed")
+		return
+	}
+	useARGB = C.webpStripPictureUseARGB(pic) != 0
+	return
+}
+
+func webpStripPictureUseARGB(pic *C.WebPPicture) bool {
+	return C.webpStripPictureUseARGB(pic) != 0
+}
+
+func webpStripPictureImport(pic *C.WebPPicture, pix []byte, width, stride, y, rows int) (err error) {
+	if pic == nil || len(pix) == 0 || rows <= 0 || stride < width*4 || len(pix) < (rows-1)*stride+width*4 {
+		err = errors.New("webpStripPictureImport: bad arguments")
+		return
+	}
+	if C.webpStripPictureImport(pic, (*C.uint8_t)(unsafe.Pointer(&pix[0])), C.int(stride), C.int(y), C.int(rows)) == 0 {
+		err = errors.New("webpStripPictureImport: failed")
+		return
+	}
+	return
+}
+
+func webpStripPictureEncode(w io.Writer, pic *C.WebPPicture, opt *encoderOptions) (err error) {
+	if pic == nil {
+		err = errors.New("webpStripPictureEncode: bad arguments")
+		return
+	}
+
+	var copt = opt.c()
+	var writer = &goWriter{W: w}
+	var handle = cgo.NewHandle(writer)
+	defer handle.Delete()
+
+	var rv = C.webpStripPictureEncode(pic, &copt, C.uintptr_t(handle))
+	if writer.Err != nil {
+		err = writer.Err
+		return
+	}
+	if rv == 0 {
+		err = errors.New("webpStripPictureEncode: failed")
+		return
+	}
+	return
+}
+
+func webpFreeStripPicture(pic *C.WebPPicture) {
+	if pic != nil {
+		C.webpFreeStripPicture(pic)
+	}
+}
+
 func webpEncodeLosslessGray(pix []byte, width, height, stride int) (output []byte, err error) {
 	if len(pix) == 0 || width <= 0 || height <= 0 || stride <= 0 {
 		err = errors.New("webpEncodeLosslessGray: bad arguments")
@@ -435,6 +798,36 @@ func webpEncodeLosslessRGBA(exact int, pix []byte, width, height, stride int) (o
 	return
 }
 
+// webpPictureDistortion returns the distortion of the RGBA pixels dist
+// against ref, in the B/G/R/A/All order of libwebp.
+func webpPictureDistortion(ref []byte, refStride int, dist []byte, distStride int, width, height, metric int) (result [5]float32, err error) {
+	if len(ref) == 0 || len(dist) == 0 || width <= 0 || height <= 0 || metric < 0 || metric > 2 {
+		err = errors.New("webpPictureDistortion: bad arguments")
+		return
+	}
+	if refStride < width*4 || len(ref) < (height-1)*refStride+width*4 ||
+		distStride < width*4 || len(dist) < (height-1)*distStride+width*4 {
+		err = errors.New("webpPictureDistortion: bad arguments")
+		return
+	}
+
+	var cresult [5]C.float
+	rv := C.webpPictureDistortion(
+		(*C.uint8_t)(unsafe.Pointer(&ref[0])), C.int(refStride),
+		(*C.uint8_t)(unsafe.Pointer(&dist[0])), C.int(distStride),
+		C.int(width), C.int(height), C.int(metric),
+		&cresult[0],
+	)
+	if rv == 0 {
+		err = errors.New("webpPictureDistortion: failed")
+		return
+	}
+	for i, v := range cresult {
+		result[i] = float32(v)
+	}
+	return
+}
+
 func webpGetEXIF(data []byte) (metadata []byte, err error) {
 	if len(data) == 0 {
 		err = errors.New("webpGetEXIF: bad arguments")
@@ -666,6 +1059,128 @@ func webpDelXMP(data []byte) (newData []byte, err error) {
 	return
 }
 
+func webpDelMetadata(data []byte, format string) (newData []byte, err error) {
+	switch format {
+	case "EXIF":
+		return webpDelEXIF(data)
+	case "ICCP":
+		return webpDelICCP(data)
+	case "XMP":
+		return webpDelXMP(data)
+	default:
+		err = errors.New("webpDelMetadata: unknown format")
+		return
+	}
+}
+
+func webpSetLoopCount(data []byte, loopCount int) (newData []byte, err error) {
+	if len(data) == 0 || loopCount < 0 || loopCount > 0xffff {
+		err = errors.New("webpSetLoopCount: bad arguments")
+		return
+	}
+
+	var cptr_size C.size_t
+	var cptr = C.webpSetLoopCount(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)),
+		C.int(loopCount),
+		&cptr_size,
+	)
+	if cptr == nil || cptr_size == 0 {
+		err = errors.New("webpSetLoopCount: failed")
+		return
+	}
+	defer C.free(unsafe.Pointer(cptr))
+
+	newData = make([]byte, int(cptr_size))
+	copy(newData, ((*[1 << 30]byte)(unsafe.Pointer(cptr)))[0:len(newData):len(newData)])
+	return
+}
+
+func webpGetFrame(data []byte, index int) (frame *AnimFrame, err error) {
+	if len(data) == 0 || index < 0 {
+		err = errors.New("webpGetFrame: bad arguments")
+		return
+	}
+
+	var xOffset, yOffset, duration, dispose, blend C.int
+	var cptr_size C.size_t
+	var cptr = C.webpGetFrame(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)),
+		C.int(index+1),
+		&xOffset, &yOffset, &duration, &dispose, &blend,
+		&cptr_size,
+	)
+	if cptr == nil || cptr_size == 0 {
+		err = errors.New("webpGetFrame: failed")
+		return
+	}
+	defer C.free(unsafe.Pointer(cptr))
+
+	frame = &AnimFrame{
+		Data:                make([]byte, int(cptr_size)),
+		X:                   int(xOffset),
+		Y:                   int(yOffset),
+		Duration:            int(duration),
+		DisposeToBackground: dispose != 0,
+		NoBlend:             blend == 0,
+	}
+	copy(frame.Data, ((*[1 << 30]byte)(unsafe.Pointer(cptr)))[0:len(frame.Data):len(frame.Data)])
+	return
+}
+
+func webpMuxAnimation(frames []*AnimFrame, opt *AnimOptions) (data []byte, err error) {
+	if len(frames) == 0 || opt.LoopCount < 0 || opt.LoopCount > 0xffff {
+		err = errors.New("webpMuxAnimation: bad arguments")
+		return
+	}
+
+	mux := C.webpNewMux()
+	if mux == nil {
+		err = errors.New("webpMuxAnimation: failed")
+		return
+	}
+	defer C.webpMuxDelete(mux)
+
+	for _, f := range frames {
+		if len(f.Data) == 0 {
+			err = errors.New("webpMuxAnimation: bad arguments")
+			return
+		}
+		var dispose, blend C.int
+		if f.DisposeToBackground {
+			dispose = 1
+		}
+		if !f.NoBlend {
+			blend = 1
+		}
+		ok := C.webpMuxPushFrame(
+			mux, (*C.uint8_t)(unsafe.Pointer(&f.Data[0])), C.size_t(len(f.Data)),
+			C.int(f.X), C.int(f.Y), C.int(f.Duration), dispose, blend,
+		)
+		if ok == 0 {
+			err = errors.New("webpMuxAnimation: failed")
+			return
+		}
+	}
+
+	bg := opt.BackgroundColor
+	var cptr_size C.size_t
+	var cptr = C.webpMuxAssembleAnimation(
+		mux, C.int(opt.CanvasWidth), C.int(opt.CanvasHeight), C.int(opt.LoopCount),
+		C.uint32_t(uint32(bg.A)<<24|uint32(bg.R)<<16|uint32(bg.G)<<8|uint32(bg.B)),
+		&cptr_size,
+	)
+	if cptr == nil || cptr_size == 0 {
+		err = errors.New("webpMuxAnimation: failed")
+		return
+	}
+	defer C.free(unsafe.Pointer(cptr))
+
+	data = make([]byte, int(cptr_size))
+	copy(data, ((*[1 << 30]byte)(unsafe.Pointer(cptr)))[0:len(data):len(data)])
+	return
+}
+
 // 动画WebP相关函数
 
 func webpIsAnimated(data []byte) bool {
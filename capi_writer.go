@@ -0,0 +1,43 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+
+package webp
+
+// The exported functions can not be in a file whose preamble has C
+// definitions, as capi.go.
+
+//#include "webp.h"
+import "C"
+import (
+	"io"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// goWriter is the output of an encoding, passed to C as a cgo.Handle.
+type goWriter struct {
+	W   io.Writer
+	N   int64
+	Err error // the first error of W, which aborts the encoding
+}
+
+//export webpGoWrite
+func webpGoWrite(data *C.uint8_t, data_size C.size_t, writer C.uintptr_t) C.int {
+	w := cgo.Handle(writer).Value().(*goWriter)
+	if data_size == 0 {
+		return 1
+	}
+	n, err := w.W.Write(unsafe.Slice((*byte)(unsafe.Pointer(data)), int(data_size)))
+	w.N += int64(n)
+	if err == nil && n < int(data_size) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		w.Err = err
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,320 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jageros/webp"
+	"github.com/jageros/webp/transform"
+)
+
+// decodeOptions are the parameters of the decodes of decode.
+type decodeOptions struct {
+	Format        string
+	Quality       int             // of JPEG
+	Crop          image.Rectangle // empty for the whole image
+	Width, Height int             // of -scale, 0 keeps the aspect ratio
+	Flip          bool
+	Dithering     webp.DecoderOptions
+}
+
+// scaled reports whether the image is scaled.
+func (opt *decodeOptions) scaled() bool {
+	return opt.Width != 0 || opt.Height != 0
+}
+
+// size returns the size of the rectangle r scaled by -scale.
+func (opt *decodeOptions) size(r image.Rectangle) (width, height int) {
+	width, height = opt.Width, opt.Height
+	switch {
+	case width == 0 && height == 0:
+		return r.Dx(), r.Dy()
+	case width == 0:
+		width = max(1, int(math.Round(float64(r.Dx()*height)/float64(r.Dy()))))
+	case height == 0:
+		height = max(1, int(math.Round(float64(r.Dy()*width)/float64(r.Dx()))))
+	}
+	return
+}
+
+// runDecode is the decode command, the dwebp tool of libwebp: each WebP
+// input is decoded to a file next to it or in -outdir, or to -o. The
+// frames of the animations are written to numbered files, or to an
+// animated GIF.
+func runDecode(args []string, stdout io.Writer) error {
+	flags := newFlagSet("decode", "[flags] input.webp...")
+	var (
+		output       = flags.String("o", "", "output `file`, for a single input; the format is guessed from its extension")
+		outdir       = flags.String("outdir", "", "output `directory`, the tree of the -r directories is kept (default: next to the inputs)")
+		recursive    = flags.Bool("r", false, "decode the .webp files of the directories recursively")
+		quiet        = flags.Bool("quiet", false, "do not print the decoded files")
+		format       = flags.String("format", "", "output `format`: png, jpeg, tiff, bmp, gif, pam, ppm, pgm or yuv (default: from -o, or png)")
+		quality      = flags.Int("quality", 90, "`quality` of jpeg (1..100)")
+		crop         = flags.String("crop", "", "crop the image to the rectangle `x,y,w,h`")
+		scale        = flags.String("scale", "", "scale the (cropped) image to `w,h`, 0 keeps the aspect ratio")
+		flip         = flags.Bool("flip", false, "flip the image vertically")
+		dither       = flags.Int("dither", 0, "dithering `strength` (0..100) of the lossy still images")
+		alphaDither  = flags.Bool("alpha_dither", false, "dither the quantized alpha planes of the lossy still images")
+		framesFormat = flags.String("frames", "%s-%04d", "`pattern` of the numbered files of the animation frames, of the output name and the frame number")
+	)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return &usageError{}
+	}
+
+	opt := &decodeOptions{Quality: *quality, Flip: *flip}
+	switch opt.Format = formatOf(*format); {
+	case *format == "" && *output != "":
+		if opt.Format = formatOf(filepath.Ext(*output)); opt.Format == "" {
+			return usagef("unknown format of %s, use -format", *output)
+		}
+	case *format == "":
+		opt.Format = "png"
+	case opt.Format == "":
+		return usagef("invalid -format %q", *format)
+	}
+	if *quality < 1 || *quality > 100 {
+		return usagef("invalid -quality %d", *quality)
+	}
+	if *crop != "" {
+		v, err := parseInts("-crop", *crop, 4)
+		if err != nil {
+			return err
+		}
+		if opt.Crop = image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]); opt.Crop.Empty() {
+			return usagef("invalid -crop %q", *crop)
+		}
+	}
+	if *scale != "" {
+		v, err := parseInts("-scale", *scale, 2)
+		if err != nil {
+			return err
+		}
+		if v[0] == 0 && v[1] == 0 {
+			return usagef("invalid -scale %q", *scale)
+		}
+		opt.Width, opt.Height = v[0], v[1]
+	}
+	if *dither < 0 || *dither > 100 {
+		return usagef("invalid -dither %d", *dither)
+	}
+	opt.Dithering.DitheringStrength = *dither
+	if *alphaDither {
+		opt.Dithering.AlphaDitheringStrength = 100
+	}
+	if isYUVFormat(opt.Format) && (!opt.Crop.Empty() || opt.scaled()) {
+		return usagef("-crop and -scale are not supported with -format %s", opt.Format)
+	}
+	if strings.Count(*framesFormat, "%") != 2 {
+		return usagef("invalid -frames %q, want a string and a number verb", *framesFormat)
+	}
+
+	inputs, err := expandInputs(flags.Args(), *recursive, map[string]bool{".webp": true})
+	if err != nil {
+		return err
+	}
+	if *output != "" && len(inputs) != 1 {
+		return usagef("-o needs a single input, got %d", len(inputs))
+	}
+	seen := make(map[string]string)
+	var errs []error
+	for _, in := range inputs {
+		out := *output
+		if out == "" {
+			out = outputPath(in, *outdir, formatExt(opt.Format))
+		}
+		if err := checkOutput(seen, in.Path, out); err != nil {
+			return err
+		}
+		files, err := decodeFile(in.Path, out, *framesFormat, opt)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", in.Path, err))
+			continue
+		}
+		if !*quiet {
+			if len(files) == 1 {
+				fmt.Fprintf(stdout, "%s -> %s\n", in.Path, files[0])
+			} else {
+				fmt.Fprintf(stdout, "%s -> %s ... %s (%d frames)\n", in.Path, files[0], files[len(files)-1], len(files))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeFile decodes the WebP file input to output, or to the numbered
+// files of the frames of an animation, and returns the written files.
+func decodeFile(input, output, framesFormat string, opt *decodeOptions) (files []string, err error) {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return
+	}
+	features, err := webp.Features(data)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return
+	}
+
+	if !features.HasAnimation {
+		m, err := decodeStill(data, opt)
+		if err != nil {
+			return nil, err
+		}
+		return []string{output}, writeFile(output, func(w io.Writer) error {
+			if isYUVFormat(opt.Format) {
+				return writeYUV(w, m, opt.Format)
+			}
+			return writeImage(w, m, opt.Format, opt.Quality)
+		})
+	}
+
+	if isYUVFormat(opt.Format) {
+		return nil, fmt.Errorf("-format %s is not supported for the animations", opt.Format)
+	}
+	frames, err := webp.DecodeAnimFrames(data)
+	if err != nil {
+		return
+	}
+	images := make([]image.Image, len(frames))
+	for i, f := range frames {
+		if images[i], err = opt.transform(f.Image); err != nil {
+			return
+		}
+	}
+	if opt.Format == "gif" {
+		return []string{output}, writeFile(output, func(w io.Writer) error {
+			return writeGIF(w, frames, images, features.LoopCount)
+		})
+	}
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	for i, m := range images {
+		name := fmt.Sprintf(framesFormat, base, i+1) + filepath.Ext(output)
+		err = writeFile(name, func(w io.Writer) error {
+			return writeImage(w, m, opt.Format, opt.Quality)
+		})
+		if err != nil {
+			return
+		}
+		files = append(files, name)
+	}
+	return
+}
+
+// decodeStill decodes a still image with the options: to the limited
+// range YUV planes of libwebp for the YUV formats, as dwebp, by the region
+// decoder of libwebp otherwise.
+func decodeStill(data []byte, opt *decodeOptions) (m image.Image, err error) {
+	if isYUVFormat(opt.Format) {
+		mode := webp.MODE_YUV
+		if _, _, hasAlpha, _ := webp.GetInfo(data); hasAlpha {
+			mode = webp.MODE_YUVA
+		}
+		if m, err = webp.DecodeColorspace(data, mode); err == nil && opt.Flip {
+			flipYUV(m)
+		}
+		return
+	}
+
+	p, err := webp.NewRegionDecoderWithOptions(data, &opt.Dithering)
+	if err != nil {
+		return
+	}
+	r := p.Bounds()
+	if !opt.Crop.Empty() {
+		if !opt.Crop.In(r) {
+			return nil, fmt.Errorf("-crop %v is outside of the image %v", opt.Crop, r)
+		}
+		r = opt.Crop
+	}
+	width, height := opt.size(r)
+	if m, err = p.DecodeRegionToSize(r, width, height); err == nil && opt.Flip {
+		m = transform.FlipV(m)
+	}
+	return
+}
+
+// transform crops, scales and flips a frame of an animation.
+func (opt *decodeOptions) transform(m image.Image) (image.Image, error) {
+	if !opt.Crop.Empty() {
+		if !opt.Crop.In(m.Bounds()) {
+			return nil, fmt.Errorf("-crop %v is outside of the image %v", opt.Crop, m.Bounds())
+		}
+		m = transform.Crop(m, opt.Crop)
+	}
+	if opt.scaled() {
+		width, height := opt.size(m.Bounds())
+		m = transform.Resize(m, width, height, nil)
+	}
+	if opt.Flip {
+		m = transform.FlipV(m)
+	}
+	return m, nil
+}
+
+// writeGIF writes the frames of an animation, with their durations, as an
+// animated GIF which plays loopCount times (0 forever). The frames are
+// composited on the whole canvas, they are disposed to the background if
+// some are not opaque, so that their transparent pixels stay transparent.
+func writeGIF(w io.Writer, frames []*webp.Frame, images []image.Image, loopCount int) error {
+	// the GIF loop count is the number of repetitions: -1 plays once, 0
+	// repeats forever
+	g := &gif.GIF{}
+	switch {
+	case loopCount == 1:
+		g.LoopCount = -1
+	case loopCount > 1:
+		g.LoopCount = loopCount - 1
+	}
+	disposal := byte(gif.DisposalNone)
+	for _, m := range images {
+		if !isOpaque(m) {
+			disposal = gif.DisposalBackground
+			break
+		}
+	}
+	for i, m := range images {
+		g.Image = append(g.Image, toPaletted(m))
+		g.Delay = append(g.Delay, (frames[i].Duration+5)/10) // 100ths of a second
+		g.Disposal = append(g.Disposal, disposal)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// writeFile creates the file name and writes it with write. The file is
+// removed on error.
+func writeFile(name string, write func(w io.Writer) error) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(name)
+		}
+	}()
+	bw := bufio.NewWriter(f)
+	if err = write(bw); err != nil {
+		return
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,247 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jageros/webp"
+)
+
+// tEncodeAnimation assembles an animation of the lossless frames, with
+// their offsets (even) and durations, on a transparent canvas.
+func tEncodeAnimation(t *testing.T, width, height, loopCount int, frames []image.Image, offsets []image.Point, durations []int) []byte {
+	t.Helper()
+	animFrames := make([]*webp.AnimFrame, len(frames))
+	for i, m := range frames {
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, m, &webp.Options{Lossless: true}); err != nil {
+			t.Fatal(err)
+		}
+		animFrames[i] = &webp.AnimFrame{Data: buf.Bytes(), X: offsets[i].X, Y: offsets[i].Y, Duration: durations[i]}
+	}
+	data, err := webp.MuxAnimation(animFrames, &webp.AnimOptions{CanvasWidth: width, CanvasHeight: height, LoopCount: loopCount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func tUniform(r image.Rectangle, c color.Color) *image.NRGBA {
+	m := image.NewNRGBA(r)
+	draw.Draw(m, r, image.NewUniform(c), image.Point{}, draw.Src)
+	return m
+}
+
+func TestDecode(t *testing.T) {
+	dir := t.TempDir()
+	input := testdataDir + "yellow_rose.lossy-with-alpha.webp"
+	want, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, err := webp.Features(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	width, height := features.Width, features.Height
+
+	for _, tt := range []struct {
+		args   []string
+		output string
+		header string
+		size   image.Point // of the decoded image
+	}{
+		{nil, "out.png", "\x89PNG", image.Pt(width, height)},
+		{[]string{"-quality", "80"}, "out.jpg", "\xff\xd8", image.Pt(width, height)},
+		{[]string{"-flip", "-dither", "50", "-alpha_dither"}, "out.tif", "II*\x00", image.Pt(width, height)},
+		{[]string{"-crop", "11,21,100,50"}, "out.bmp", "BM", image.Pt(100, 50)},
+		{[]string{"-scale", "50,0"}, "out.gif", "GIF8", image.Pt(50, (50*height+width/2)/width)},
+		{[]string{"-crop", "0,0,40,40", "-scale", "20,10"}, "out.png", "\x89PNG", image.Pt(20, 10)},
+		{[]string{"-format", "pam"}, "out.x", fmt.Sprintf("P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\n", width, height), image.Point{}},
+		{nil, "out.ppm", fmt.Sprintf("P6\n%d %d\n255\n", width, height), image.Point{}},
+		{nil, "out.pgm", fmt.Sprintf("P5\n%d %d\n255\n", (width+1)&^1, 2*height+(height+1)/2), image.Point{}},
+	} {
+		output := filepath.Join(dir, tt.output)
+		args := append(append([]string{"-quiet", "-o", output}, tt.args...), input)
+		if err := runDecode(args, io.Discard); err != nil {
+			t.Fatal(tt.args, err)
+		}
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, []byte(tt.header)) {
+			t.Fatalf("%v %s: %q", tt.args, tt.output, data[:min(len(data), 40)])
+		}
+		if tt.size != (image.Point{}) {
+			config, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil || config.Width != tt.size.X || config.Height != tt.size.Y {
+				t.Fatal(tt.args, tt.output, config, err)
+			}
+		}
+	}
+
+	// the raw YUV 4:2:0 planes, with alpha
+	output := filepath.Join(dir, "out.yuv")
+	if err := runDecode([]string{"-quiet", "-o", output, input}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(output)
+	uvWidth, uvHeight := (width+1)/2, (height+1)/2
+	if len(data) != 2*width*height+2*uvWidth*uvHeight {
+		t.Fatal(len(data))
+	}
+	yuv, err := webp.DecodeColorspace(want, webp.MODE_YUVA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := yuv.(*image.NYCbCrA); !bytes.Equal(data[:width], p.Y[:width]) || !bytes.Equal(data[len(data)-width:], p.A[(height-1)*p.AStride:][:width]) {
+		t.Fatal("the planes differ")
+	}
+
+	// the flip of the rows
+	flipped := filepath.Join(dir, "flipped.yuv")
+	if err := runDecode([]string{"-quiet", "-flip", "-o", flipped, input}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := os.ReadFile(flipped)
+	if !bytes.Equal(p[:width], data[(height-1)*width:][:width]) {
+		t.Fatal("the rows are not flipped")
+	}
+}
+
+func TestDecode_animation(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "anim.webp")
+	r := image.Rect(0, 0, 32, 24)
+	data := tEncodeAnimation(t, 32, 24, 2,
+		[]image.Image{tUniform(image.Rect(0, 0, 24, 20), color.NRGBA{255, 0, 0, 255}), tUniform(image.Rect(0, 0, 8, 8), color.NRGBA{0, 0, 255, 255}), tUniform(r, color.NRGBA{0, 255, 0, 128})},
+		[]image.Point{{}, {4, 6}, {}},
+		[]int{100, 200, 300})
+	if err := os.WriteFile(input, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the numbered frames
+	var stdout bytes.Buffer
+	if err := runDecode([]string{"-crop", "2,2,20,20", "-scale", "10,0", "-outdir", filepath.Join(dir, "frames"), input}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "(3 frames)") {
+		t.Fatal(stdout.String())
+	}
+	for i := 1; i <= 3; i++ {
+		f, err := os.Open(filepath.Join(dir, "frames", fmt.Sprintf("anim-%04d.png", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _, err := image.Decode(f)
+		f.Close()
+		if err != nil || m.Bounds() != image.Rect(0, 0, 10, 10) {
+			t.Fatal(i, err)
+		}
+	}
+
+	// the animated GIF
+	output := filepath.Join(dir, "anim.gif")
+	if err := runDecode([]string{"-quiet", "-o", output, input}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 3 || fmt.Sprint(g.Delay) != "[10 20 30]" || g.LoopCount != 1 {
+		t.Fatal(len(g.Image), g.Delay, g.LoopCount)
+	}
+	// the frames are not opaque, they do not show through each other
+	if fmt.Sprint(g.Disposal) != fmt.Sprint([]byte{gif.DisposalBackground, gif.DisposalBackground, gif.DisposalBackground}) {
+		t.Fatal(g.Disposal)
+	}
+	// the canvas is transparent out of the first frame
+	if _, _, _, a := g.Image[0].At(30, 22).RGBA(); a != 0 {
+		t.Fatal("the canvas is not transparent", a)
+	}
+	if _, _, _, a := g.Image[0].At(0, 0).RGBA(); a != 0xffff {
+		t.Fatal("the frame is not opaque", a)
+	}
+
+	// the loop counts of WebP are the plays, of GIF the repetitions
+	for _, tt := range []struct{ webp, gif int }{{0, 0}, {1, -1}, {3, 2}} {
+		data := tEncodeAnimation(t, 8, 8, tt.webp,
+			[]image.Image{tUniform(image.Rect(0, 0, 8, 8), color.NRGBA{255, 0, 0, 255}), tUniform(image.Rect(0, 0, 8, 8), color.NRGBA{0, 0, 255, 255})},
+			[]image.Point{{}, {}},
+			[]int{100, 100})
+		looped := filepath.Join(dir, "looped.webp")
+		if err := os.WriteFile(looped, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := runDecode([]string{"-quiet", "-o", output, looped}, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := gif.DecodeAll(f)
+		f.Close()
+		if err != nil || g.LoopCount != tt.gif {
+			t.Fatal(tt.webp, g.LoopCount, err)
+		}
+		// the opaque frames are kept
+		if g.Disposal[0] != gif.DisposalNone {
+			t.Fatal(g.Disposal)
+		}
+	}
+
+	// the YUV formats are not supported
+	if err := runDecode([]string{"-quiet", "-format", "yuv", input}, io.Discard); err == nil {
+		t.Fatal("yuv animation")
+	}
+}
+
+func TestDecode_errors(t *testing.T) {
+	defer func(w io.Writer) { stderr = w }(stderr)
+	stderr = io.Discard
+	input := testdataDir + "tux.lossless.webp"
+	for _, args := range [][]string{
+		{},
+		{"-format", "xyz", input},
+		{"-o", "out.xyz", input},
+		{"-quality", "0", input},
+		{"-crop", "0,0,0,10", input},
+		{"-scale", "0,0", input},
+		{"-dither", "101", input},
+		{"-format", "yuv", "-crop", "0,0,10,10", input},
+		{"-frames", "%d", input},
+		{"-o", "x.png", input, testdataDir + "photo.lossy.webp"},
+	} {
+		if _, ok := runDecode(args, io.Discard).(*usageError); !ok {
+			t.Fatal(args)
+		}
+	}
+
+	dir := t.TempDir()
+	err := runDecode([]string{"-crop", "300,300,200,200", "-outdir", dir, input, testdataDir + "photo.lossy.webp"}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Fatal(err)
+	}
+}
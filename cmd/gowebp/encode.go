@@ -0,0 +1,331 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jageros/webp"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+// encodeExts are the extensions of the files encoded from the directories
+// of -r. The WebP files are only encoded when they are named.
+var encodeExts = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true,
+	".bmp": true, ".tif": true, ".tiff": true,
+}
+
+// encodeTarget is the conversion of an input file to an output file.
+type encodeTarget struct {
+	Input  string
+	Output string
+}
+
+// runEncode is the encode command, the cwebp tool of libwebp: the inputs
+// are files, glob patterns, or directories with -r, and each one is
+// encoded to a .webp file next to it or in -outdir, or to -o.
+func runEncode(args []string, stdout io.Writer) error {
+	flags := newFlagSet("encode", "[flags] input...")
+	var (
+		output    = flags.String("o", "", "output `file`, for a single input")
+		outdir    = flags.String("outdir", "", "output `directory`, the tree of the -r directories is kept (default: next to the inputs)")
+		recursive = flags.Bool("r", false, "encode the images of the directories recursively")
+		workers   = flags.Int("j", 0, "count of parallel encodes (default: the count of CPUs)")
+		memory    = flags.Int64("mem", 0, "memory limit of the parallel encodes, in `MiB` (0: no limit)")
+		failFast  = flags.Bool("fail_fast", false, "stop at the first failure")
+		quiet     = flags.Bool("quiet", false, "do not print the statistics")
+		psnr      = flags.Bool("psnr", false, "print the PSNR of the encoded images (not with -crop and -resize)")
+		metadata  = flags.String("metadata", "none", "metadata to copy from JPEG, PNG and WebP inputs: all, none, or a comma-separated `list` of exif, icc, xmp")
+
+		quality      = flags.Float64("q", 75, "quality `factor` (0:small..100:big); the effort in lossless")
+		lossless     = flags.Bool("lossless", false, "encode the image without any loss")
+		level        = flags.Int("z", -1, "activate the lossless preset of `level` 0:fast..9:slowest (default 6 with -lossless)")
+		nearLossless = flags.Int("near_lossless", 100, "near-lossless preprocessing `level` (0..100=off), implies -lossless")
+		exact        = flags.Bool("exact", false, "preserve the RGB values in the transparent areas")
+		exactAlpha   = flags.Bool("exact_alpha", false, "keep the alpha values exact in the near-lossless encodes")
+		deltaPalette = flags.Bool("delta_palette", false, "use the delta palette of the lossless encoder")
+		sharpYUV     = flags.Bool("sharp_yuv", false, "use the sharper (and slower) RGB to YUV conversion")
+		preset       = flags.String("preset", "default", "lossy preset: default, picture, photo, drawing, icon or text")
+		dither       = flags.String("dither", "truncate", "reduction of the 16-bit images: truncate, round, ordered, floyd-steinberg or blue-noise")
+		colors       = flags.Int("colors", 0, "quantize the image to a palette of `count` colors (2..256), implies -lossless")
+		colorsDither = flags.Float64("colors_dither", 0, "error diffusion of -colors, 0 (none) ~ 1 (full)")
+		colorsPSNR   = flags.Float64("colors_min_psnr", 0, "minimum PSNR of -colors, in `dB`, the image is not quantized below it")
+		crop         = flags.String("crop", "", "crop the image to the rectangle `x,y,w,h`")
+		resize       = flags.String("resize", "", "resize the (cropped) image to `w,h`, 0 keeps the aspect ratio")
+		fit          = flags.Bool("fit", false, "fit the image in the -resize size, keeping its aspect ratio")
+		auto         = flags.Bool("auto", false, "choose lossless or lossy and the preset from an analysis of the image")
+		trial        = flags.Bool("trial", false, "with -auto, encode in both formats and keep the smaller one above -min_psnr")
+		minPSNR      = flags.Float64("min_psnr", 0, "minimum PSNR of -trial, in `dB` (default 40)")
+	)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return &usageError{}
+	}
+
+	opt := &webp.Options{
+		Lossless:        *lossless,
+		Quality:         float32(*quality),
+		Exact:           *exact,
+		ExactAlpha:      *exactAlpha,
+		UseDeltaPalette: *deltaPalette,
+		UseSharpYUV:     *sharpYUV,
+		Trial:           *trial,
+		MinPSNR:         float32(*minPSNR),
+	}
+	if *quality < 0 || *quality > 100 {
+		return usagef("invalid -q %v", *quality)
+	}
+	switch {
+	case *level >= webp.FastestLosslessLevel && *level <= webp.BestLosslessLevel:
+		opt.Lossless, opt.LosslessLevel = true, level
+	case *level != -1:
+		return usagef("invalid -z %d", *level)
+	}
+	if *nearLossless < 0 || *nearLossless > 100 {
+		return usagef("invalid -near_lossless %d", *nearLossless)
+	}
+	if *nearLossless < 100 {
+		opt.Lossless, opt.NearLossless = true, 100-*nearLossless
+	}
+	if *auto {
+		opt.Mode = webp.ModeAuto
+	}
+	var err error
+	if opt.Preset, err = parsePreset(*preset); err != nil {
+		return err
+	}
+	if opt.Dither, err = parseDither(*dither); err != nil {
+		return err
+	}
+	if *colors != 0 {
+		if *colors < 2 || *colors > 256 {
+			return usagef("invalid -colors %d", *colors)
+		}
+		opt.Lossless, opt.Quantize = true, &webp.QuantizeOptions{
+			MaxColors: *colors,
+			Dither:    float32(*colorsDither),
+			MinPSNR:   float32(*colorsPSNR),
+		}
+	}
+	if *crop != "" {
+		v, err := parseInts("-crop", *crop, 4)
+		if err != nil {
+			return err
+		}
+		opt.Crop = image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3])
+	}
+	if *resize != "" {
+		v, err := parseInts("-resize", *resize, 2)
+		if err != nil {
+			return err
+		}
+		opt.Resize = &webp.ResizeOptions{Width: v[0], Height: v[1], Fit: *fit}
+	}
+	formats, err := parseMetadataFlag(*metadata)
+	if err != nil {
+		return err
+	}
+
+	inputs, err := expandInputs(flags.Args(), *recursive, encodeExts)
+	if err != nil {
+		return err
+	}
+	if *output != "" && len(inputs) != 1 {
+		return usagef("-o needs a single input, got %d", len(inputs))
+	}
+	targets := make([]encodeTarget, len(inputs))
+	seen := make(map[string]string)
+	for i, in := range inputs {
+		out := *output
+		if out == "" {
+			out = outputPath(in, *outdir, ".webp")
+		}
+		if err := checkOutput(seen, in.Path, out); err != nil {
+			return err
+		}
+		targets[i] = encodeTarget{Input: in.Path, Output: out}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	jobs := make(chan webp.BatchJob)
+	results := make(chan webp.BatchResult)
+	go func() {
+		defer close(jobs)
+		for _, t := range targets {
+			jobs <- webp.BatchJob{InputPath: t.Input, Output: new(bytes.Buffer)}
+		}
+	}()
+	statsc := make(chan webp.BatchStats, 1)
+	errc := make(chan error, 1)
+	go func() {
+		stats, err := webp.BatchChan(ctx, jobs, results, &webp.BatchOptions{
+			Workers:     *workers,
+			MemoryLimit: *memory << 20,
+			FailFast:    *failFast,
+			Options:     opt,
+		})
+		statsc <- stats
+		errc <- err
+	}()
+
+	// the outputs are written as the encodes complete
+	var errs []error
+	var written, inputBytes, outputBytes int64
+	for r := range results {
+		if r.Err != nil {
+			continue // returned by BatchChan
+		}
+		t := targets[r.Index]
+		data := r.Job.Output.(*bytes.Buffer).Bytes()
+		info, err := finishEncode(t, data, formats, *psnr && opt.Crop.Empty() && opt.Resize == nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.Input, err))
+			continue
+		}
+		written++
+		inputBytes += r.InputSize
+		outputBytes += info.Size
+		if !*quiet {
+			fmt.Fprintf(stdout, "%s -> %s: %dx%d, %s -> %s (%.1f%%), %.2f bpp, %v%s\n",
+				t.Input, t.Output, info.Width, info.Height,
+				formatBytes(r.InputSize), formatBytes(info.Size), percent(info.Size, r.InputSize),
+				float64(8*info.Size)/float64(info.Width*info.Height),
+				r.Duration.Round(time.Millisecond), info.PSNR)
+		}
+	}
+	stats, err := <-statsc, <-errc
+	if err != nil {
+		errs = append([]error{err}, errs...)
+	}
+	if !*quiet && stats.Jobs > 1 {
+		fmt.Fprintf(stdout, "%d files: %d encoded, %d failed, %d skipped, %s -> %s (%.1f%%), %v\n",
+			stats.Jobs, written, stats.Jobs-stats.Skipped-int(written), stats.Skipped,
+			formatBytes(inputBytes), formatBytes(outputBytes), percent(outputBytes, inputBytes),
+			stats.Elapsed.Round(time.Millisecond))
+	}
+	return errors.Join(errs...)
+}
+
+// encodeInfo describes an output of encode.
+type encodeInfo struct {
+	Width, Height int
+	Size          int64
+	PSNR          string // ", PSNR x dB", if measured
+}
+
+// finishEncode copies the metadata of the formats from the input to the
+// encoded image in data, and writes it to the output. The PSNR compares
+// the decoded output with the input.
+func finishEncode(t encodeTarget, data []byte, formats []string, psnr bool) (info encodeInfo, err error) {
+	var src []byte
+	if len(formats) > 0 || psnr {
+		if src, err = os.ReadFile(t.Input); err != nil {
+			return
+		}
+	}
+	if len(formats) > 0 {
+		if data, err = copyMetadata(data, src, formats); err != nil {
+			return
+		}
+	}
+	if info.Width, info.Height, _, err = webp.GetInfo(data); err != nil {
+		return
+	}
+	info.Size = int64(len(data))
+
+	if psnr {
+		ref, _, err := image.Decode(bytes.NewReader(src))
+		if err != nil {
+			return info, err
+		}
+		dist, err := webp.DecodeNRGBA(data)
+		if err != nil {
+			return info, err
+		}
+		d, err := webp.Distortion(ref, dist, webp.PSNR)
+		if err != nil {
+			return info, err
+		}
+		info.PSNR = fmt.Sprintf(", PSNR %.2f dB", d[4])
+	}
+
+	if err = os.MkdirAll(filepath.Dir(t.Output), 0o755); err != nil {
+		return
+	}
+	err = os.WriteFile(t.Output, data, 0o644)
+	return
+}
+
+func parsePreset(s string) (webp.WebPPreset, error) {
+	for p := webp.WEBP_PRESET_DEFAULT; p <= webp.WEBP_PRESET_TEXT; p++ {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, usagef("invalid -preset %q", s)
+}
+
+func parseDither(s string) (webp.Dither, error) {
+	for d := webp.DitherTruncate; d <= webp.DitherBlueNoise; d++ {
+		if d.String() == s {
+			return d, nil
+		}
+	}
+	return 0, usagef("invalid -dither %q", s)
+}
+
+// parseInts parses the n comma-separated integers of the flag name.
+func parseInts(name, s string, n int) ([]int, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, usagef("invalid %s %q, want %d comma-separated integers", name, s, n)
+	}
+	v := make([]int, n)
+	for i, f := range fields {
+		var err error
+		if v[i], err = strconv.Atoi(strings.TrimSpace(f)); err != nil || v[i] < 0 {
+			return nil, usagef("invalid %s %q", name, s)
+		}
+	}
+	return v, nil
+}
+
+// formatBytes formats n in B, KiB or MiB.
+func formatBytes(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%d B", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
@@ -0,0 +1,244 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jageros/webp"
+)
+
+const testdataDir = "../../testdata/"
+
+// copyFile copies the testdata file name to dst, creating its directory.
+func copyFile(t *testing.T, name, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(testdataDir + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(dst, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readWebP returns the features of the WebP file name.
+func readWebP(t *testing.T, name string) ([]byte, *webp.ImageFeatures) {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, err := webp.Features(data)
+	if err != nil {
+		t.Fatal(name, err)
+	}
+	return data, features
+}
+
+func TestEncode(t *testing.T) {
+	dir := t.TempDir()
+	copyFile(t, "tux.png", filepath.Join(dir, "in", "tux.png"))
+	copyFile(t, "video-001-gray.tiff", filepath.Join(dir, "in", "sub", "gray.tiff"))
+	copyFile(t, "tux.lossless.webp", filepath.Join(dir, "in", "sub", "tux.webp")) // not walked
+
+	// the tree of the directory is kept in -outdir
+	var stdout bytes.Buffer
+	out := filepath.Join(dir, "out")
+	err := runEncode([]string{"-r", "-j", "2", "-q", "50", "-psnr", "-outdir", out, filepath.Join(dir, "in")}, &stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tux.webp", "sub/gray.webp"} {
+		if _, features := readWebP(t, filepath.Join(out, name)); features.Format != webp.FormatLossy {
+			t.Fatal(name, features.Format)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(out, "sub", "tux.webp")); !os.IsNotExist(err) {
+		t.Fatal("the webp input is encoded", err)
+	}
+	if s := stdout.String(); strings.Count(s, " dB\n") != 2 || !strings.Contains(s, "2 files: 2 encoded, 0 failed") {
+		t.Fatal(s)
+	}
+
+	// the globs, next to the inputs
+	stdout.Reset()
+	if err = runEncode([]string{"-quiet", "-lossless", filepath.Join(dir, "in", "*.png")}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if _, features := readWebP(t, filepath.Join(dir, "in", "tux.webp")); features.Format != webp.FormatLossless {
+		t.Fatal(features.Format)
+	}
+	if stdout.Len() != 0 {
+		t.Fatal(stdout.String())
+	}
+}
+
+func TestEncode_options(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.webp")
+	for _, tt := range []struct {
+		args          []string
+		format        webp.Format
+		width, height int
+	}{
+		{[]string{"-z", "0"}, webp.FormatLossless, 386, 395},
+		{[]string{"-near_lossless", "60", "-exact"}, webp.FormatLossless, 386, 395},
+		{[]string{"-colors", "16", "-colors_dither", "0.5"}, webp.FormatLossless, 386, 395},
+		{[]string{"-preset", "icon", "-sharp_yuv", "-exact_alpha"}, webp.FormatLossy, 386, 395},
+		{[]string{"-crop", "10,20,100,50", "-resize", "50,0"}, webp.FormatLossy, 50, 25},
+		{[]string{"-resize", "100,100", "-fit"}, webp.FormatLossy, 98, 100},
+		{[]string{"-auto", "-trial", "-min_psnr", "35"}, 0, 386, 395},
+	} {
+		args := append(append([]string{"-quiet", "-o", output}, tt.args...), testdataDir+"tux.png")
+		if err := runEncode(args, new(bytes.Buffer)); err != nil {
+			t.Fatal(tt.args, err)
+		}
+		_, features := readWebP(t, output)
+		if tt.format != 0 && features.Format != tt.format || features.Width != tt.width || features.Height != tt.height {
+			t.Fatal(tt.args, features)
+		}
+	}
+}
+
+func TestEncode_errors(t *testing.T) {
+	dir := t.TempDir()
+	defer func(w io.Writer) { stderr = w }(stderr)
+	stderr = io.Discard
+	for _, args := range [][]string{
+		{},
+		{"-unknown", "x.png"},
+		{"-q", "101", testdataDir + "tux.png"},
+		{"-z", "10", testdataDir + "tux.png"},
+		{"-preset", "unknown", testdataDir + "tux.png"},
+		{"-dither", "unknown", testdataDir + "tux.png"},
+		{"-crop", "1,2,3", testdataDir + "tux.png"},
+		{"-metadata", "gps", testdataDir + "tux.png"},
+		{"-o", filepath.Join(dir, "x.webp"), testdataDir + "tux.png", testdataDir + "1_webp_ll.png"},
+		{testdataDir},
+	} {
+		err := runEncode(args, new(bytes.Buffer))
+		if _, ok := err.(*usageError); !ok {
+			t.Fatal(args, err)
+		}
+	}
+
+	// the failures are reported after the other encodes
+	copyFile(t, "tux.png", filepath.Join(dir, "a.png"))
+	if err := os.WriteFile(filepath.Join(dir, "b.png"), []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	err := runEncode([]string{filepath.Join(dir, "*.png")}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "b.png") {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "2 files: 1 encoded, 1 failed") {
+		t.Fatal(stdout.String())
+	}
+	readWebP(t, filepath.Join(dir, "a.webp"))
+}
+
+// pngChunk returns a PNG chunk of the type typ.
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(append(chunk, typ...), data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// jpegSegment returns a JPEG segment of the marker.
+func jpegSegment(marker byte, data ...string) []byte {
+	n := 2
+	for _, s := range data {
+		n += len(s)
+	}
+	segment := []byte{0xff, marker, byte(n >> 8), byte(n)}
+	for _, s := range data {
+		segment = append(segment, s...)
+	}
+	return segment
+}
+
+func TestEncode_metadata(t *testing.T) {
+	const (
+		exif = "MM\x00\x2a\x00\x00\x00\x08\x00\x00"
+		xmp  = "<x:xmpmeta xmlns:x='adobe:ns:meta/'></x:xmpmeta>"
+	)
+	icc := strings.Repeat("icc profile ", 20)
+	m := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i)
+	}
+	m.Set(0, 0, color.NRGBA{1, 2, 3, 4})
+
+	// a PNG with the metadata chunks after IHDR
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	var zicc bytes.Buffer
+	zw := zlib.NewWriter(&zicc)
+	zw.Write([]byte(icc))
+	zw.Close()
+	p := buf.Bytes()
+	pngData := append([]byte(nil), p[:33]...)
+	pngData = append(pngData, pngChunk("eXIf", []byte(exif))...)
+	pngData = append(pngData, pngChunk("iCCP", append([]byte("icc\x00\x00"), zicc.Bytes()...))...)
+	pngData = append(pngData, pngChunk("iTXt", []byte(pngXMPKeyword+"\x00\x00\x00\x00\x00"+xmp))...)
+	pngData = append(pngData, p[33:]...)
+
+	// a JPEG with the metadata segments after SOI, the ICC profile in 2 parts
+	buf.Reset()
+	if err := jpeg.Encode(&buf, m, nil); err != nil {
+		t.Fatal(err)
+	}
+	p = buf.Bytes()
+	jpegData := append([]byte(nil), p[:2]...)
+	jpegData = append(jpegData, jpegSegment(0xe1, jpegEXIFHeader, exif)...)
+	jpegData = append(jpegData, jpegSegment(0xe2, jpegICCHeader, "\x02\x02", icc[100:])...)
+	jpegData = append(jpegData, jpegSegment(0xe2, jpegICCHeader, "\x01\x02", icc[:100])...)
+	jpegData = append(jpegData, jpegSegment(0xe1, jpegXMPHeader, xmp)...)
+	jpegData = append(jpegData, p[2:]...)
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"in.png": pngData, "in.jpg": jpegData} {
+		input := filepath.Join(dir, name)
+		if err := os.WriteFile(input, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"EXIF": exif, "ICCP": icc, "XMP": xmp}
+		for flag, formats := range map[string][]string{"all": {"EXIF", "ICCP", "XMP"}, "icc,xmp": {"ICCP", "XMP"}, "none": nil} {
+			output := filepath.Join(dir, "out.webp")
+			if err := runEncode([]string{"-quiet", "-metadata", flag, "-o", output, input}, new(bytes.Buffer)); err != nil {
+				t.Fatal(name, flag, err)
+			}
+			data, features := readWebP(t, output)
+			if features.HasEXIF != slices.Contains(formats, "EXIF") || features.HasICCP != slices.Contains(formats, "ICCP") || features.HasXMP != slices.Contains(formats, "XMP") {
+				t.Fatal(name, flag, features)
+			}
+			for _, format := range formats {
+				if p, err := webp.GetMetadata(data, format); err != nil || string(p) != want[format] {
+					t.Fatalf("%s %s: %s = %q, %v", name, flag, format, p, err)
+				}
+			}
+		}
+	}
+}
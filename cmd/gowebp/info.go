@@ -0,0 +1,339 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jageros/webp"
+)
+
+// infoReport is the report of info on a WebP file.
+type infoReport struct {
+	File     string         `json:"file"`
+	Size     int            `json:"size"`
+	Chunks   []infoChunk    `json:"chunks"`
+	Features infoFeatures   `json:"features"`
+	Frames   []infoFrame    `json:"frames,omitempty"`
+	Metadata []infoMetadata `json:"metadata,omitempty"`
+}
+
+// infoChunk is a chunk of the RIFF container, at its offset in the file.
+// The frames of the animations hold the chunks of their image.
+type infoChunk struct {
+	FourCC string      `json:"fourcc"`
+	Offset int         `json:"offset"`
+	Size   int         `json:"size"` // of the payload
+	Chunks []infoChunk `json:"chunks,omitempty"`
+}
+
+type infoFeatures struct {
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	Format          string `json:"format"`
+	HasAlpha        bool   `json:"has_alpha"`
+	HasAnimation    bool   `json:"has_animation"`
+	CanvasWidth     int    `json:"canvas_width"`
+	CanvasHeight    int    `json:"canvas_height"`
+	FrameCount      int    `json:"frame_count"`
+	LoopCount       int    `json:"loop_count"`
+	BackgroundColor string `json:"background_color,omitempty"` // #rrggbbaa, of the animations
+}
+
+// infoFrame is a frame of an animation, as stored in its ANMF chunk.
+type infoFrame struct {
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Duration int    `json:"duration"` // in milliseconds
+	Dispose  string `json:"dispose"`  // none or background
+	Blend    bool   `json:"blend"`
+	Format   string `json:"format"`
+	HasAlpha bool   `json:"has_alpha"`
+}
+
+type infoMetadata struct {
+	Format  string `json:"format"`
+	Size    int    `json:"size"`
+	Summary string `json:"summary"`
+}
+
+// runInfo is the info command, the webpinfo tool of libwebp: the chunks,
+// the features, the frames and the metadata of each WebP input are printed
+// as text, or as a JSON object per input.
+func runInfo(args []string, stdout io.Writer) error {
+	flags := newFlagSet("info", "[flags] input.webp...")
+	var (
+		recursive = flags.Bool("r", false, "read the .webp files of the directories recursively")
+		asJSON    = flags.Bool("json", false, "print a JSON object per input")
+	)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return &usageError{}
+	}
+
+	inputs, err := expandInputs(flags.Args(), *recursive, map[string]bool{".webp": true})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	var errs []error
+	for _, in := range inputs {
+		report, err := readInfo(in.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", in.Path, err))
+			continue
+		}
+		if *asJSON {
+			err = enc.Encode(report)
+		} else {
+			err = report.print(stdout)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readInfo reads the report of the WebP file name.
+func readInfo(name string) (*infoReport, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("not a WebP file")
+	}
+	body := data[12:]
+	if n := int(binary.LittleEndian.Uint32(data[4:8])) - 4; n >= 0 && n < len(body) {
+		body = body[:n] // trailing data after the RIFF chunk
+	}
+	chunks, err := parseChunks(body, 12)
+	if err != nil {
+		return nil, err
+	}
+	features, err := webp.Features(data)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &infoReport{
+		File:   name,
+		Size:   len(data),
+		Chunks: chunks,
+		Features: infoFeatures{
+			Width:        features.Width,
+			Height:       features.Height,
+			Format:       features.Format.String(),
+			HasAlpha:     features.HasAlpha,
+			HasAnimation: features.HasAnimation,
+			CanvasWidth:  features.CanvasWidth,
+			CanvasHeight: features.CanvasHeight,
+			FrameCount:   features.FrameCount,
+			LoopCount:    features.LoopCount,
+		},
+	}
+	if features.HasAnimation {
+		c := features.BackgroundColor
+		report.Features.BackgroundColor = fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+		for _, c := range chunks {
+			if c.FourCC == "ANMF" {
+				report.Frames = append(report.Frames, parseFrame(data, c))
+			}
+		}
+	}
+	for _, format := range metadataFormats {
+		if !hasMetadata(features, format) {
+			continue
+		}
+		p, err := webp.GetMetadata(data, format)
+		if err != nil {
+			return nil, err
+		}
+		report.Metadata = append(report.Metadata, infoMetadata{format, len(p), summarizeMetadata(format, p)})
+	}
+	return report, nil
+}
+
+// parseChunks returns the chunks of p, at the offset in the file. The
+// payload of the ANMF chunks is parsed after the header of the frame.
+func parseChunks(p []byte, offset int) (chunks []infoChunk, err error) {
+	for len(p) > 0 {
+		if len(p) < 8 {
+			return nil, fmt.Errorf("truncated chunk header at offset %d", offset)
+		}
+		c := infoChunk{FourCC: string(p[:4]), Offset: offset, Size: int(binary.LittleEndian.Uint32(p[4:8]))}
+		if c.Size > len(p)-8 {
+			return nil, fmt.Errorf("truncated %q chunk at offset %d", c.FourCC, offset)
+		}
+		if c.FourCC == "ANMF" {
+			if c.Size < 16 {
+				return nil, fmt.Errorf("invalid ANMF chunk at offset %d", offset)
+			}
+			if c.Chunks, err = parseChunks(p[8+16:8+c.Size], offset+8+16); err != nil {
+				return nil, err
+			}
+		}
+		chunks = append(chunks, c)
+		n := min(8+c.Size+c.Size%2, len(p)) // padded to an even size
+		p, offset = p[n:], offset+n
+	}
+	return
+}
+
+// parseFrame returns the frame of the ANMF chunk c of data.
+func parseFrame(data []byte, c infoChunk) infoFrame {
+	le24 := func(p []byte) int { return int(p[0]) | int(p[1])<<8 | int(p[2])<<16 }
+	p := data[c.Offset+8:]
+	f := infoFrame{
+		X:        2 * le24(p[0:]),
+		Y:        2 * le24(p[3:]),
+		Width:    1 + le24(p[6:]),
+		Height:   1 + le24(p[9:]),
+		Duration: le24(p[12:]),
+		Dispose:  "none",
+		Blend:    p[15]&0x02 == 0,
+	}
+	if p[15]&0x01 != 0 {
+		f.Dispose = "background"
+	}
+	for _, c := range c.Chunks {
+		switch c.FourCC {
+		case "ALPH":
+			f.HasAlpha = true
+		case "VP8 ":
+			f.Format = webp.FormatLossy.String()
+		case "VP8L":
+			f.Format = webp.FormatLossless.String()
+			// the alpha_is_used bit of the header, after the signature byte
+			if c.Size >= 5 {
+				header := data[c.Offset+8+1:]
+				f.HasAlpha = binary.LittleEndian.Uint32(header)>>28&1 != 0
+			}
+		}
+	}
+	return f
+}
+
+func hasMetadata(features *webp.ImageFeatures, format string) bool {
+	switch format {
+	case "EXIF":
+		return features.HasEXIF
+	case "ICCP":
+		return features.HasICCP
+	case "XMP":
+		return features.HasXMP
+	}
+	return false
+}
+
+// summarizeMetadata returns a line on the metadata p of the format: the
+// byte order and the IFD0 entries of EXIF, the version, class and color
+// space of an ICC profile, the beginning of XMP.
+func summarizeMetadata(format string, p []byte) string {
+	switch format {
+	case "EXIF":
+		p = bytes.TrimPrefix(p, []byte(jpegEXIFHeader))
+		var order binary.ByteOrder
+		var s string
+		switch {
+		case bytes.HasPrefix(p, []byte("II*\x00")):
+			order, s = binary.LittleEndian, "TIFF little-endian"
+		case bytes.HasPrefix(p, []byte("MM\x00*")):
+			order, s = binary.BigEndian, "TIFF big-endian"
+		default:
+			return "invalid TIFF header"
+		}
+		if len(p) >= 8 {
+			if ifd := int(order.Uint32(p[4:8])); ifd >= 8 && ifd+2 <= len(p) {
+				s += fmt.Sprintf(", %d IFD0 entries", order.Uint16(p[ifd:]))
+			}
+		}
+		return s
+
+	case "ICCP":
+		if len(p) < 128 || string(p[36:40]) != "acsp" {
+			return "invalid ICC profile header"
+		}
+		return fmt.Sprintf("ICC v%d.%d, class %s, color space %s", p[8], p[9]>>4,
+			strings.TrimSpace(string(p[12:16])), strings.TrimSpace(string(p[16:20])))
+
+	case "XMP":
+		const maxLen = 60
+		s := strings.Join(strings.Fields(string(p)), " ")
+		if len(s) > maxLen {
+			s = s[:maxLen] + "..."
+		}
+		return s
+	}
+	return ""
+}
+
+// print prints the report as text.
+func (r *infoReport) print(w io.Writer) error {
+	f := r.Features
+	fmt.Fprintf(w, "%s: %d bytes\n", r.File, r.Size)
+	fmt.Fprintf(w, "  Features: %dx%d %s", f.Width, f.Height, f.Format)
+	if f.HasAlpha {
+		fmt.Fprintf(w, ", alpha")
+	}
+	fmt.Fprintln(w)
+	if f.HasAnimation {
+		fmt.Fprintf(w, "  Animation: canvas %dx%d, %d frames, loop count %d, background %s\n",
+			f.CanvasWidth, f.CanvasHeight, f.FrameCount, f.LoopCount, f.BackgroundColor)
+	}
+
+	fmt.Fprintf(w, "  Chunks:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "    fourcc\toffset\tsize\n")
+	for _, c := range r.Chunks {
+		fmt.Fprintf(tw, "    %s\t%d\t%d\n", c.FourCC, c.Offset, c.Size)
+		for _, c := range c.Chunks {
+			fmt.Fprintf(tw, "      %s\t%d\t%d\n", c.FourCC, c.Offset, c.Size)
+		}
+	}
+	tw.Flush()
+
+	if len(r.Frames) > 0 {
+		fmt.Fprintf(w, "  Frames:\n")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "    #\tx\ty\twidth\theight\tduration\tdispose\tblend\tformat\talpha\n")
+		for i, f := range r.Frames {
+			fmt.Fprintf(tw, "    %d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", i+1, f.X, f.Y, f.Width, f.Height,
+				f.Duration, f.Dispose, yesNo(f.Blend), f.Format, yesNo(f.HasAlpha))
+		}
+		tw.Flush()
+	}
+
+	if len(r.Metadata) > 0 {
+		fmt.Fprintf(w, "  Metadata:\n")
+		for _, m := range r.Metadata {
+			fmt.Fprintf(w, "    %-4s %s: %s\n", m.Format, formatBytes(int64(m.Size)), m.Summary)
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
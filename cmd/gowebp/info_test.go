@@ -0,0 +1,109 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jageros/webp"
+)
+
+func TestInfo(t *testing.T) {
+	dir := t.TempDir()
+	data := tEncodeAnimation(t, 32, 24, 2,
+		[]image.Image{tUniform(image.Rect(0, 0, 32, 24), color.NRGBA{255, 0, 0, 255}), tUniform(image.Rect(0, 0, 8, 8), color.NRGBA{0, 0, 255, 128})},
+		[]image.Point{{}, {4, 6}},
+		[]int{100, 200})
+	data, err := webp.SetMetadata(data, []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x03"), "EXIF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "anim.webp")
+	if err := os.WriteFile(input, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if err := runInfo([]string{"-json", input, testdataDir + "tux.lossless.webp"}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&stdout)
+	var anim, still infoReport
+	if err := dec.Decode(&anim); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&still); err != nil {
+		t.Fatal(err)
+	}
+
+	if f := anim.Features; f.Format != "lossless" || !f.HasAnimation || f.CanvasWidth != 32 || f.FrameCount != 2 || f.LoopCount != 2 {
+		t.Fatal(f)
+	}
+	var fourccs []string
+	for _, c := range anim.Chunks {
+		fourccs = append(fourccs, c.FourCC)
+	}
+	if s := strings.Join(fourccs, ","); s != "VP8X,ANIM,ANMF,ANMF,EXIF" || anim.Chunks[2].Chunks[0].FourCC != "VP8L" {
+		t.Fatal(s, anim.Chunks)
+	}
+	if c := anim.Chunks[4]; !bytes.Equal(data[c.Offset+8:][:c.Size], []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x03")) {
+		t.Fatal("bad offset of the EXIF chunk", c)
+	}
+	want := infoFrame{X: 4, Y: 6, Width: 8, Height: 8, Duration: 200, Dispose: "none", Blend: true, Format: "lossless", HasAlpha: true}
+	if len(anim.Frames) != 2 || anim.Frames[1] != want || anim.Frames[0].HasAlpha {
+		t.Fatal(anim.Frames)
+	}
+	if len(anim.Metadata) != 1 || anim.Metadata[0] != (infoMetadata{"EXIF", 10, "TIFF big-endian, 3 IFD0 entries"}) {
+		t.Fatal(anim.Metadata)
+	}
+	if f := still.Features; f.Width != 386 || f.HasAnimation || len(still.Frames) != 0 || len(still.Chunks) != 1 {
+		t.Fatal(still)
+	}
+
+	// the text report
+	stdout.Reset()
+	if err := runInfo([]string{input}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"canvas 32x24, 2 frames, loop count 2", "\n      VP8L  ", "\n    2  4  6  8 ", "EXIF 10 B: TIFF big-endian"} {
+		if !strings.Contains(stdout.String(), s) {
+			t.Fatalf("no %q in\n%s", s, stdout.String())
+		}
+	}
+}
+
+func TestInfo_errors(t *testing.T) {
+	defer func(w io.Writer) { stderr = w }(stderr)
+	stderr = io.Discard
+	if _, ok := runInfo(nil, io.Discard).(*usageError); !ok {
+		t.Fatal("no usage error")
+	}
+
+	dir := t.TempDir()
+	data, err := os.ReadFile(testdataDir + "tux.lossless.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.webp")
+	if err := os.WriteFile(truncated, data[:100], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	err = runInfo([]string{truncated, testdataDir + "tux.lossless.webp", testdataDir + "tux.png"}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "truncated") || !strings.Contains(err.Error(), "not a WebP file") {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "tux.lossless.webp: 29920 bytes") {
+		t.Fatal(stdout.String())
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inputFile is an input file of a command.
+type inputFile struct {
+	Path string // of the file
+	Rel  string // of the output in -outdir, relative to the walked directory
+}
+
+// expandInputs returns the files of the arguments: the files, the matches
+// of the glob patterns, and the files of the directories with the
+// extensions exts with recursive. The directories matched by a pattern are
+// skipped without recursive.
+func expandInputs(args []string, recursive bool, exts map[string]bool) (inputs []inputFile, err error) {
+	for _, arg := range args {
+		paths, glob := []string{arg}, strings.ContainsAny(arg, "*?[")
+		if glob {
+			if paths, err = filepath.Glob(arg); err != nil {
+				return nil, usagef("invalid pattern %q", arg)
+			}
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("%s: no matching files", arg)
+			}
+		}
+		for _, path := range paths {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case !fi.IsDir():
+				inputs = append(inputs, inputFile{Path: path, Rel: filepath.Base(path)})
+				continue
+			case !recursive && glob:
+				continue
+			case !recursive:
+				return nil, usagef("%s is a directory, use -r", path)
+			}
+			err = filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() || !exts[strings.ToLower(filepath.Ext(name))] {
+					return err
+				}
+				rel, err := filepath.Rel(path, name)
+				if err != nil {
+					return err
+				}
+				inputs = append(inputs, inputFile{Path: name, Rel: rel})
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("no input images")
+	}
+	return
+}
+
+// outputPath returns the path of the output of an input, with the
+// extension ext, next to it or in outdir.
+func outputPath(in inputFile, outdir, ext string) string {
+	name := in.Path
+	if outdir != "" {
+		name = filepath.Join(outdir, in.Rel)
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+}
+
+// checkOutput checks that the output of input overwrites neither the input
+// nor the output of another input, in seen.
+func checkOutput(seen map[string]string, input, output string) error {
+	if filepath.Clean(output) == filepath.Clean(input) {
+		return fmt.Errorf("%s: the output would overwrite the input, use -o or -outdir", input)
+	}
+	if prev, ok := seen[output]; ok {
+		return fmt.Errorf("%s and %s have the same output %s", prev, input, output)
+	}
+	seen[output] = input
+	return nil
+}
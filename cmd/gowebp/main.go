@@ -0,0 +1,125 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Gowebp converts images to and from WebP, inspects and edits WebP files,
+// as the cwebp, dwebp, webpinfo and webpmux tools of libwebp.
+//
+// Usage:
+//
+//	gowebp <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	encode  encode PNG, JPEG, GIF, BMP, TIFF or WebP images to WebP
+//	decode  decode WebP images to PNG, JPEG, TIFF, BMP, GIF, PAM, PPM, PGM or YUV
+//	info    print the chunks, features, frames and metadata of WebP images
+//	mux     get, set or strip metadata, set the loop count, extract or assemble frames
+//
+// Run "gowebp <command> -h" for the flags of a command.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// commands are the subcommands of gowebp. A command parses its arguments
+// and writes its reports to stdout.
+var commands = []struct {
+	Name    string
+	Summary string
+	Run     func(args []string, stdout io.Writer) error
+}{
+	{"encode", "encode PNG, JPEG, GIF, BMP, TIFF or WebP images to WebP", runEncode},
+	{"decode", "decode WebP images to PNG, JPEG, TIFF, BMP, GIF, PAM, PPM, PGM or YUV", runDecode},
+	{"info", "print the chunks, features, frames and metadata of WebP images", runInfo},
+	{"mux", "get, set or strip metadata, set the loop count, extract or assemble frames", runMux},
+}
+
+// stderr is the output of the errors and of the usage.
+var stderr io.Writer = os.Stderr
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(stderr)
+		os.Exit(2)
+	}
+	name, args := os.Args[1], os.Args[2:]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage(os.Stdout)
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.Name != name {
+			continue
+		}
+		if err := cmd.Run(args, os.Stdout); err != nil {
+			if msg := err.Error(); err != flag.ErrHelp && msg != "" {
+				fmt.Fprintf(stderr, "gowebp %s: %v\n", name, err)
+			}
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+	fmt.Fprintf(stderr, "gowebp: unknown command %q\n\n", name)
+	usage(stderr)
+	os.Exit(2)
+}
+
+// usageError is an error of the command line. The errors of the flags are
+// reported by the flag set, their message is empty.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+// exitCode returns 2 for the errors of the command line, 1 otherwise.
+func exitCode(err error) int {
+	switch err.(type) {
+	case *usageError:
+		return 2
+	}
+	if err == flag.ErrHelp {
+		return 0
+	}
+	return 1
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage:\n\n\tgowebp <command> [flags] [arguments]\n\nThe commands are:\n\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "\t%-7s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(w, "\nRun \"gowebp <command> -h\" for the flags of a command.\n")
+}
+
+// newFlagSet returns the flag set of a command, whose parsing errors are
+// returned as usage errors.
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet("gowebp "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gowebp %s %s\n\nFlags:\n", name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args with fs, which prints the error and the usage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jageros/webp"
+)
+
+// The formats of the metadata, as the chunks of WebP.
+var metadataFormats = []string{"EXIF", "ICCP", "XMP"}
+
+// parseMetadataFlag returns the formats selected by the -metadata flag: a
+// comma-separated list of exif, icc and xmp, or all, or none.
+func parseMetadataFlag(s string) (formats []string, err error) {
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "all":
+			formats = append(formats, metadataFormats...)
+		case "none", "":
+		case "exif":
+			formats = append(formats, "EXIF")
+		case "icc", "iccp":
+			formats = append(formats, "ICCP")
+		case "xmp":
+			formats = append(formats, "XMP")
+		default:
+			return nil, usagef("invalid metadata %q", name)
+		}
+	}
+	return
+}
+
+// readMetadata returns the EXIF, ICC profile and XMP of the JPEG, PNG or
+// WebP image in data, by format. The other formats have no metadata.
+func readMetadata(data []byte) map[string][]byte {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		return readJPEGMetadata(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return readPNGMetadata(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		metadata := make(map[string][]byte)
+		for _, format := range metadataFormats {
+			if p, err := webp.GetMetadata(data, format); err == nil && len(p) > 0 {
+				metadata[format] = p
+			}
+		}
+		return metadata
+	}
+	return nil
+}
+
+// The signatures of the JPEG application segments of the metadata.
+const (
+	jpegEXIFHeader = "Exif\x00\x00"
+	jpegXMPHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+	jpegICCHeader  = "ICC_PROFILE\x00"
+)
+
+// readJPEGMetadata reads the APP1 (EXIF, XMP) and APP2 (ICC profile, in
+// numbered parts) segments before the image data.
+func readJPEGMetadata(data []byte) map[string][]byte {
+	metadata := make(map[string][]byte)
+	iccParts := make(map[int][]byte)
+	for p := data[2:]; len(p) >= 4 && p[0] == 0xff; {
+		marker := p[1]
+		if marker == 0xd8 || marker >= 0xd0 && marker <= 0xd7 || marker == 0x01 || marker == 0xff {
+			p = p[1:] // no length, or a fill byte
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			break // start of scan, end of image
+		}
+		n := int(binary.BigEndian.Uint16(p[2:4]))
+		if n < 2 || len(p) < 2+n {
+			break
+		}
+		segment := p[4 : 2+n]
+		p = p[2+n:]
+
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(segment, []byte(jpegEXIFHeader)):
+			if metadata["EXIF"] == nil {
+				metadata["EXIF"] = segment[len(jpegEXIFHeader):]
+			}
+		case marker == 0xe1 && bytes.HasPrefix(segment, []byte(jpegXMPHeader)):
+			if metadata["XMP"] == nil {
+				metadata["XMP"] = segment[len(jpegXMPHeader):]
+			}
+		case marker == 0xe2 && bytes.HasPrefix(segment, []byte(jpegICCHeader)):
+			if part := segment[len(jpegICCHeader):]; len(part) >= 2 {
+				iccParts[int(part[0])] = part[2:]
+			}
+		}
+	}
+
+	if len(iccParts) > 0 {
+		seqs := make([]int, 0, len(iccParts))
+		for seq := range iccParts {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+		var icc []byte
+		for _, seq := range seqs {
+			icc = append(icc, iccParts[seq]...)
+		}
+		metadata["ICCP"] = icc
+	}
+	return metadata
+}
+
+// pngXMPKeyword is the keyword of the iTXt chunk of the XMP.
+const pngXMPKeyword = "XML:com.adobe.xmp"
+
+// readPNGMetadata reads the eXIf, iCCP and XMP iTXt chunks.
+func readPNGMetadata(data []byte) map[string][]byte {
+	metadata := make(map[string][]byte)
+	for p := data[8:]; len(p) >= 12; {
+		n := int(binary.BigEndian.Uint32(p[:4]))
+		if n < 0 || len(p) < 12+n {
+			break
+		}
+		typ, chunk := string(p[4:8]), p[8:8+n]
+		p = p[12+n:]
+
+		switch typ {
+		case "eXIf":
+			metadata["EXIF"] = chunk
+		case "iCCP":
+			// profile name, 0, compression method, zlib data
+			if i := bytes.IndexByte(chunk, 0); i >= 0 && i+2 <= len(chunk) {
+				if icc, err := inflate(chunk[i+2:]); err == nil {
+					metadata["ICCP"] = icc
+				}
+			}
+		case "iTXt":
+			if xmp, ok := pngXMP(chunk); ok {
+				metadata["XMP"] = xmp
+			}
+		case "IEND":
+			return metadata
+		}
+	}
+	return metadata
+}
+
+// pngXMP returns the text of an iTXt chunk of the XMP keyword.
+func pngXMP(chunk []byte) ([]byte, bool) {
+	// keyword, 0, compression flag, compression method, language tag, 0,
+	// translated keyword, 0, text
+	keyword, rest, ok := bytes.Cut(chunk, []byte{0})
+	if !ok || string(keyword) != pngXMPKeyword || len(rest) < 2 {
+		return nil, false
+	}
+	compressed := rest[0] == 1
+	if _, rest, ok = bytes.Cut(rest[2:], []byte{0}); !ok {
+		return nil, false
+	}
+	if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+		return nil, false
+	}
+	if !compressed {
+		return rest, true
+	}
+	text, err := inflate(rest)
+	return text, err == nil
+}
+
+func inflate(p []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// copyMetadata adds the metadata of the formats from the input image in
+// src to the WebP image in data. The formats missing in src are skipped.
+func copyMetadata(data, src []byte, formats []string) ([]byte, error) {
+	metadata := readMetadata(src)
+	for _, format := range formats {
+		p := metadata[format]
+		if len(p) == 0 {
+			continue
+		}
+		var err error
+		if data, err = webp.SetMetadata(data, p, format); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
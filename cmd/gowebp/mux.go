@@ -0,0 +1,241 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jageros/webp"
+)
+
+// muxFrameSpec is the syntax of -frame, as webpmux: the file, the
+// duration, the offset, the disposal (1 to the background) and the
+// blending (-b for none).
+var muxFrameSpec = regexp.MustCompile(`^(.+?)\+(\d+)(?:\+(\d+)\+(\d+)(?:\+([01])([+-]b)?)?)?$`)
+
+// muxFrame is a frame of -frame, whose Data is read from File.
+type muxFrame struct {
+	File string
+	webp.AnimFrame
+}
+
+// parseMuxFrame parses the value of -frame.
+func parseMuxFrame(s string) (*muxFrame, error) {
+	m := muxFrameSpec.FindStringSubmatch(s)
+	if m == nil {
+		return nil, usagef("invalid -frame %q, want file+d[+x+y[+m[+b|-b]]]", s)
+	}
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	f := &muxFrame{File: m[1]}
+	f.Duration, f.X, f.Y = atoi(m[2]), atoi(m[3]), atoi(m[4])
+	f.DisposeToBackground = m[5] == "1"
+	f.NoBlend = m[6] == "-b"
+	if f.X%2 != 0 || f.Y%2 != 0 {
+		return nil, usagef("invalid -frame %q, the offsets must be even", s)
+	}
+	if f.Duration >= 1<<24 {
+		return nil, usagef("invalid -frame %q, the duration is too large", s)
+	}
+	return f, nil
+}
+
+// parseMetadataFormat returns the single format of the value of a flag:
+// exif, icc or xmp.
+func parseMetadataFormat(name, s string) (string, error) {
+	formats, err := parseMetadataFlag(s)
+	if err != nil || len(formats) != 1 {
+		return "", usagef("invalid %s %q, want exif, icc or xmp", name, s)
+	}
+	return formats[0], nil
+}
+
+// runMux is the mux command, the webpmux tool of libwebp. It gets, sets or
+// strips the metadata of a WebP file and sets the loop count of an
+// animation, extracts a frame of an animation, or assembles still WebP
+// images into an animation. The images are not encoded again.
+func runMux(args []string, stdout io.Writer) error {
+	flags := newFlagSet("mux", "[flags] [input.webp]")
+	var (
+		sets   [][2]string // format, file
+		frames []*muxFrame
+	)
+	var (
+		output  = flags.String("o", "", "output `file` (default: stdout for -get)")
+		quiet   = flags.Bool("quiet", false, "do not print the written file")
+		get     = flags.String("get", "", "write the metadata of the `format` exif, icc or xmp")
+		extract = flags.Int("extract", 0, "write the frame `n` (from 1) of an animation as a still image")
+		strip   = flags.String("strip", "", "strip the metadata: a comma-separated `list` of exif, icc and xmp, or all")
+		loop    = flags.Int("loop", -1, "set the loop `count` of the animation, 0 is infinite")
+		bgcolor = flags.String("bgcolor", "255,255,255,255", "background color `A,R,G,B` of the animation assembled from -frame")
+	)
+	flags.Func("set", "set the metadata `format=file`, format is exif, icc or xmp; repeatable", func(s string) error {
+		name, file, ok := strings.Cut(s, "=")
+		format, err := parseMetadataFormat("-set", name)
+		if !ok || err != nil || file == "" {
+			return fmt.Errorf("want format=file")
+		}
+		sets = append(sets, [2]string{format, file})
+		return nil
+	})
+	flags.Func("frame", "add the frame `file+d[+x+y[+m[+b|-b]]]` to the animation, as webpmux: the still WebP file, its duration d in milliseconds, its offset x,y (even), its disposal m (1 to the background) and its blending (-b for none); repeatable", func(s string) error {
+		f, err := parseMuxFrame(s)
+		if err != nil {
+			return err
+		}
+		frames = append(frames, f)
+		return nil
+	})
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	edits := len(sets) > 0 || *strip != "" || *loop >= 0
+	switch {
+	case *get != "" && (*extract != 0 || edits || len(frames) > 0),
+		*extract != 0 && (edits || len(frames) > 0):
+		return usagef("-get and -extract cannot be combined with the other operations")
+	case *get == "" && *extract == 0 && !edits && len(frames) == 0:
+		flags.Usage()
+		return &usageError{}
+	case len(frames) > 0 && flags.NArg() != 0:
+		return usagef("-frame assembles an animation without input, got %d", flags.NArg())
+	case len(frames) == 0 && flags.NArg() != 1:
+		return usagef("want a single input, got %d", flags.NArg())
+	case *output == "" && *get == "":
+		return usagef("-o is required")
+	case *extract < 0:
+		return usagef("invalid -extract %d", *extract)
+	case *loop > 0xffff:
+		return usagef("invalid -loop %d", *loop)
+	}
+	var getFormat string
+	if *get != "" {
+		var err error
+		if getFormat, err = parseMetadataFormat("-get", *get); err != nil {
+			return err
+		}
+	}
+	stripFormats, err := parseMetadataFlag(*strip)
+	if err != nil {
+		return err
+	}
+	v, err := parseInts("-bgcolor", *bgcolor, 4)
+	if err != nil {
+		return err
+	}
+	if max(v[0], v[1], v[2], v[3]) > 255 {
+		return usagef("invalid -bgcolor %q", *bgcolor)
+	}
+	bg := color.NRGBA{R: uint8(v[1]), G: uint8(v[2]), B: uint8(v[3]), A: uint8(v[0])}
+
+	var input string
+	var data []byte
+	if len(frames) > 0 {
+		input = fmt.Sprintf("%d frames", len(frames))
+		if data, err = muxAnimation(frames, max(*loop, 0), bg); err != nil {
+			return err
+		}
+	} else {
+		input = flags.Arg(0)
+		if data, err = os.ReadFile(input); err != nil {
+			return err
+		}
+	}
+	features, err := webp.Features(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+
+	switch {
+	case getFormat != "":
+		if !hasMetadata(features, getFormat) {
+			return fmt.Errorf("%s: no %s metadata", input, getFormat)
+		}
+		if data, err = webp.GetMetadata(data, getFormat); err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+		if *output == "" {
+			_, err = stdout.Write(data)
+			return err
+		}
+
+	case *extract != 0:
+		if !features.HasAnimation || *extract > features.FrameCount {
+			return fmt.Errorf("%s: no frame %d, the image has %d frames", input, *extract, features.FrameCount)
+		}
+		f, err := webp.GetAnimFrame(data, *extract-1)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+		data = f.Data
+
+	default:
+		for _, format := range stripFormats {
+			if data, err = webp.DelMetadata(data, format); err != nil {
+				return fmt.Errorf("%s: strip %s: %w", input, format, err)
+			}
+		}
+		for _, set := range sets {
+			metadata, err := os.ReadFile(set[1])
+			if err != nil {
+				return err
+			}
+			if len(metadata) == 0 {
+				return fmt.Errorf("%s: set %s: %s is empty", input, set[0], set[1])
+			}
+			if data, err = webp.SetMetadata(data, metadata, set[0]); err != nil {
+				return fmt.Errorf("%s: set %s: %w", input, set[0], err)
+			}
+		}
+		if *loop >= 0 && len(frames) == 0 {
+			if !features.HasAnimation {
+				return fmt.Errorf("%s: -loop needs an animation", input)
+			}
+			if data, err = webp.SetLoopCount(data, *loop); err != nil {
+				return fmt.Errorf("%s: %w", input, err)
+			}
+		}
+	}
+
+	err = writeFile(*output, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err == nil && !*quiet {
+		fmt.Fprintf(stdout, "%s -> %s: %s\n", input, *output, formatBytes(int64(len(data))))
+	}
+	return err
+}
+
+// muxAnimation assembles the still WebP files of the frames into an
+// animation, on the canvas of their bounds.
+func muxAnimation(frames []*muxFrame, loopCount int, bg color.NRGBA) ([]byte, error) {
+	animFrames := make([]*webp.AnimFrame, len(frames))
+	for i, f := range frames {
+		data, err := os.ReadFile(f.File)
+		if err != nil {
+			return nil, err
+		}
+		features, err := webp.Features(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.File, err)
+		}
+		if features.HasAnimation {
+			return nil, fmt.Errorf("%s: the frames must be still images", f.File)
+		}
+		f.Data = data
+		animFrames[i] = &f.AnimFrame
+	}
+	return webp.MuxAnimation(animFrames, &webp.AnimOptions{LoopCount: loopCount, BackgroundColor: bg})
+}
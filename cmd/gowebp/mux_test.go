@@ -0,0 +1,160 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jageros/webp"
+)
+
+func TestMux_metadata(t *testing.T) {
+	dir := t.TempDir()
+	input := testdataDir + "tux.lossless.webp"
+	exif, xmp := filepath.Join(dir, "exif.bin"), filepath.Join(dir, "xmp.xml")
+	for name, data := range map[string]string{exif: "II*\x00\x08\x00\x00\x00\x00\x00", xmp: "<x:xmpmeta xmlns:x='adobe:ns:meta/'/>"} {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := filepath.Join(dir, "out.webp")
+	var stdout bytes.Buffer
+	if err := runMux([]string{"-set", "exif=" + exif, "-set", "xmp=" + xmp, "-o", output, input}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), input+" -> "+output) {
+		t.Fatal(stdout.String())
+	}
+	if _, features := readWebP(t, output); !features.HasEXIF || !features.HasXMP || features.HasICCP {
+		t.Fatal(features)
+	}
+
+	// -get writes to stdout, or to -o
+	stdout.Reset()
+	if err := runMux([]string{"-get", "xmp", output}, &stdout); err != nil || stdout.String() != "<x:xmpmeta xmlns:x='adobe:ns:meta/'/>" {
+		t.Fatalf("%q %v", stdout.String(), err)
+	}
+	got := filepath.Join(dir, "got.bin")
+	if err := runMux([]string{"-quiet", "-get", "EXIF", "-o", got, output}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := os.ReadFile(got); string(p) != "II*\x00\x08\x00\x00\x00\x00\x00" {
+		t.Fatalf("%q", p)
+	}
+	if err := runMux([]string{"-get", "icc", output}, io.Discard); err == nil || !strings.Contains(err.Error(), "no ICCP metadata") {
+		t.Fatal(err)
+	}
+
+	// the stripped chunks, the missing ones are ignored
+	stripped := filepath.Join(dir, "stripped.webp")
+	if err := runMux([]string{"-quiet", "-strip", "exif,icc", "-o", stripped, output}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, features := readWebP(t, stripped); features.HasEXIF || !features.HasXMP {
+		t.Fatal(features)
+	}
+	if err := runMux([]string{"-quiet", "-strip", "all", "-o", stripped, output}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, features := readWebP(t, stripped); features.HasEXIF || features.HasXMP {
+		t.Fatal(features)
+	}
+}
+
+func TestMux_animation(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i, m := range []image.Image{tUniform(image.Rect(0, 0, 32, 24), color.NRGBA{255, 0, 0, 255}), tUniform(image.Rect(0, 0, 8, 8), color.NRGBA{0, 0, 255, 255})} {
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, m, &webp.Options{Lossless: true}); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filepath.Join(dir, []string{"a+1.webp", "b.webp"}[i]))
+		if err := os.WriteFile(files[i], buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the assembled frames, the file name has a +
+	anim := filepath.Join(dir, "anim.webp")
+	args := []string{"-quiet", "-frame", files[0] + "+100", "-frame", files[1] + "+250+4+6+1-b", "-loop", "3", "-bgcolor", "255,0,128,255", "-o", anim}
+	if err := runMux(args, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	data, features := readWebP(t, anim)
+	if !features.HasAnimation || features.CanvasWidth != 32 || features.CanvasHeight != 24 || features.FrameCount != 2 || features.LoopCount != 3 {
+		t.Fatal(features)
+	}
+	if features.BackgroundColor != (color.NRGBA{0, 128, 255, 255}) {
+		t.Fatal(features.BackgroundColor)
+	}
+	frame, err := webp.GetAnimFrame(data, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.X != 4 || frame.Y != 6 || frame.Duration != 250 || !frame.DisposeToBackground || !frame.NoBlend {
+		t.Fatal(frame.X, frame.Y, frame.Duration, frame.DisposeToBackground, frame.NoBlend)
+	}
+
+	// the loop count
+	looped := filepath.Join(dir, "looped.webp")
+	if err := runMux([]string{"-quiet", "-loop", "0", "-o", looped, anim}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, features := readWebP(t, looped); features.LoopCount != 0 || features.FrameCount != 2 {
+		t.Fatal(features)
+	}
+	if err := runMux([]string{"-loop", "1", "-o", looped, files[0]}, io.Discard); err == nil {
+		t.Fatal("loop count of a still image")
+	}
+
+	// the extracted frame
+	extracted := filepath.Join(dir, "frame.webp")
+	if err := runMux([]string{"-quiet", "-extract", "2", "-o", extracted, anim}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, features := readWebP(t, extracted); features.HasAnimation || features.Width != 8 || features.Height != 8 {
+		t.Fatal(features)
+	}
+	if err := runMux([]string{"-extract", "3", "-o", extracted, anim}, io.Discard); err == nil || !strings.Contains(err.Error(), "no frame 3") {
+		t.Fatal(err)
+	}
+	if err := runMux([]string{"-frame", anim + "+100", "-o", extracted}, io.Discard); err == nil || !strings.Contains(err.Error(), "still images") {
+		t.Fatal(err)
+	}
+}
+
+func TestMux_errors(t *testing.T) {
+	defer func(w io.Writer) { stderr = w }(stderr)
+	stderr = io.Discard
+	input := testdataDir + "tux.lossless.webp"
+	for _, args := range [][]string{
+		{},
+		{input},
+		{"-get", "gps", input},
+		{"-get", "exif", "-strip", "all", input},
+		{"-extract", "1", "-loop", "0", "-o", "x.webp", input},
+		{"-strip", "all", input},
+		{"-strip", "all", "-o", "x.webp", input, input},
+		{"-set", "exif", "-o", "x.webp", input},
+		{"-frame", "a.webp", "-o", "x.webp"},
+		{"-frame", "a.webp+100+1+0", "-o", "x.webp"},
+		{"-frame", "a.webp+100", "-o", "x.webp", input},
+		{"-frame", "a.webp+100", "-bgcolor", "256,0,0,0", "-o", "x.webp"},
+		{"-loop", "65536", "-o", "x.webp", input},
+	} {
+		if _, ok := runMux(args, io.Discard).(*usageError); !ok {
+			t.Fatal(args)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// outputFormats are the output formats of decode, by name, with their
+// file extension.
+var outputFormats = []struct {
+	Name string
+	Ext  string
+}{
+	{"png", ".png"},
+	{"jpeg", ".jpg"},
+	{"tiff", ".tiff"},
+	{"bmp", ".bmp"},
+	{"gif", ".gif"},
+	{"pam", ".pam"},
+	{"ppm", ".ppm"},
+	{"pgm", ".pgm"},
+	{"yuv", ".yuv"},
+}
+
+// formatOf returns the output format of the name or of the extension of a
+// file name, "" if it is unknown.
+func formatOf(s string) string {
+	s = strings.ToLower(s)
+	if s == "jpg" || s == ".jpeg" {
+		return "jpeg"
+	}
+	if s == ".tif" {
+		return "tiff"
+	}
+	for _, f := range outputFormats {
+		if s == f.Name || s == f.Ext {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+func formatExt(format string) string {
+	for _, f := range outputFormats {
+		if f.Name == format {
+			return f.Ext
+		}
+	}
+	return ""
+}
+
+// isYUVFormat reports whether format is written from the YUV planes.
+func isYUVFormat(format string) bool {
+	return format == "pgm" || format == "yuv"
+}
+
+// writeImage writes m to w in the RGB format, with the quality of JPEG.
+func writeImage(w io.Writer, m image.Image, format string, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(w, m)
+	case "jpeg":
+		return jpeg.Encode(w, m, &jpeg.Options{Quality: quality})
+	case "tiff":
+		return tiff.Encode(w, m, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	case "bmp":
+		return bmp.Encode(w, toNRGBA(m))
+	case "gif":
+		return gif.Encode(w, toPaletted(m), nil)
+	case "pam":
+		return writePNM(w, toNRGBA(m), true)
+	case "ppm":
+		return writePNM(w, toNRGBA(m), false)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// writePNM writes m as a PAM (RGBA) or PPM (RGB) file, as dwebp.
+func writePNM(w io.Writer, m *image.NRGBA, alpha bool) error {
+	b := m.Bounds()
+	bw := bufio.NewWriter(w)
+	if alpha {
+		fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n", b.Dx(), b.Dy())
+	} else {
+		fmt.Fprintf(bw, "P6\n%d %d\n255\n", b.Dx(), b.Dy())
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := m.Pix[m.PixOffset(b.Min.X, y):][:4*b.Dx()]
+		if alpha {
+			bw.Write(row)
+			continue
+		}
+		for i := 0; i < len(row); i += 4 {
+			bw.Write(row[i : i+3])
+		}
+	}
+	return bw.Flush()
+}
+
+// writeYUV writes the 4:2:0 planes of m, an *image.YCbCr or an
+// *image.NYCbCrA, as dwebp: the Y, U, V and alpha planes one after the
+// other in yuv, or as a grayscale PGM in pgm, with the U and V planes side
+// by side under the Y plane, and the alpha plane under them.
+func writeYUV(w io.Writer, m image.Image, format string) error {
+	var p *image.YCbCr
+	var a []byte
+	var aStride int
+	switch m := m.(type) {
+	case *image.YCbCr:
+		p = m
+	case *image.NYCbCrA:
+		p, a, aStride = &m.YCbCr, m.A, m.AStride
+	default:
+		return fmt.Errorf("unsupported image %T", m)
+	}
+	width, height := p.Rect.Dx(), p.Rect.Dy()
+	uvWidth, uvHeight := (width+1)/2, (height+1)/2
+	aHeight := 0
+	if a != nil {
+		aHeight = height
+	}
+
+	bw := bufio.NewWriter(w)
+	pad := format == "pgm" && width%2 == 1
+	rows := func(plane []byte, stride, width, height int) {
+		for y := 0; y < height; y++ {
+			bw.Write(plane[y*stride:][:width])
+			if pad {
+				bw.WriteByte(0)
+			}
+		}
+	}
+	if format == "pgm" {
+		fmt.Fprintf(bw, "P5\n%d %d\n255\n", (width+1)&^1, height+uvHeight+aHeight)
+		rows(p.Y, p.YStride, width, height)
+		for y := 0; y < uvHeight; y++ {
+			bw.Write(p.Cb[y*p.CStride:][:uvWidth])
+			bw.Write(p.Cr[y*p.CStride:][:uvWidth])
+		}
+	} else {
+		rows(p.Y, p.YStride, width, height)
+		rows(p.Cb, p.CStride, uvWidth, uvHeight)
+		rows(p.Cr, p.CStride, uvWidth, uvHeight)
+	}
+	rows(a, aStride, width, aHeight)
+	return bw.Flush()
+}
+
+// flipYUV flips the planes of m, an *image.YCbCr or an *image.NYCbCrA,
+// vertically in place. The chroma rows are flipped as the luma rows of an
+// even height, as libwebp.
+func flipYUV(m image.Image) {
+	flip := func(plane []byte, stride, height int) {
+		tmp := make([]byte, stride)
+		for y := 0; y < height/2; y++ {
+			top, bottom := plane[y*stride:][:stride], plane[(height-1-y)*stride:][:stride]
+			copy(tmp, top)
+			copy(top, bottom)
+			copy(bottom, tmp)
+		}
+	}
+	var p *image.YCbCr
+	switch m := m.(type) {
+	case *image.YCbCr:
+		p = m
+	case *image.NYCbCrA:
+		p = &m.YCbCr
+		flip(m.A, m.AStride, m.Rect.Dy())
+	default:
+		return
+	}
+	height := p.Rect.Dy()
+	flip(p.Y, p.YStride, height)
+	flip(p.Cb, p.CStride, (height+1)/2)
+	flip(p.Cr, p.CStride, (height+1)/2)
+}
+
+// toNRGBA returns m as an *image.NRGBA, converted if needed.
+func toNRGBA(m image.Image) *image.NRGBA {
+	if p, ok := m.(*image.NRGBA); ok {
+		return p
+	}
+	b := m.Bounds()
+	p := image.NewNRGBA(b)
+	draw.Draw(p, b, m, b.Min, draw.Src)
+	return p
+}
+
+// toPaletted returns m with the Plan 9 palette and Floyd-Steinberg
+// dithering, for GIF. The last color of the palette is transparent if m
+// is not opaque.
+func toPaletted(m image.Image) *image.Paletted {
+	pal := color.Palette(palette.Plan9)
+	if !isOpaque(m) {
+		pal = append(pal[:len(pal)-1:len(pal)-1], color.Transparent)
+	}
+	b := m.Bounds()
+	p := image.NewPaletted(b, pal)
+	draw.FloydSteinberg.Draw(p, b, m, b.Min)
+	return p
+}
+
+func isOpaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
@@ -0,0 +1,281 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+
+package webp
+
+//#include "webp.h"
+import "C"
+import (
+	"errors"
+	"image"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// encoderStripRows is the count of rows imported at once in the pooled
+// pictures of Encoder, even for the YUV pictures.
+const encoderStripRows = 64
+
+// Encoder encodes images with the same options, which are validated once
+// by NewEncoder. It is safe for concurrent use.
+//
+// The 8-bit RGBA images (*image.NRGBA and *image.RGBA) are imported in
+// libwebp pictures kept in a sync.Pool, and reused by the next images of
+// the same size, without allocating the C memory of the picture or a Go
+// copy of the image. The pooled pictures hold the C memory of a picture
+// (4 bytes per pixel in lossless, 2.5 bytes per pixel in lossy) until they
+// are dropped by the pool. The other images, Mode ModeAuto, Quantize, Crop
+// and Resize are encoded as by Encode.
+type Encoder struct {
+	opt    *Options
+	config encoderOptions
+
+	pictures sync.Pool // *encoderPicture
+	strips   sync.Pool // *[]byte, the rows of *image.RGBA converted to NRGBA
+}
+
+// encoderPicture is a pooled picture of Encoder, freed when it is dropped.
+type encoderPicture struct {
+	pic           *C.WebPPicture
+	width, height int
+	useARGB       bool
+	cleanup       runtime.Cleanup
+}
+
+func (p *encoderPicture) free() {
+	p.cleanup.Stop()
+	webpFreeStripPicture(p.pic)
+	p.pic = nil
+}
+
+// NewEncoder returns an Encoder with the options opt, which are copied. A
+// nil opt are the default options of Encode.
+func NewEncoder(opt *Options) (*Encoder, error) {
+	e := &Encoder{}
+	var crop image.Rectangle
+	if opt != nil {
+		o := *opt
+		if o.Quantize != nil {
+			q := *o.Quantize
+			o.Quantize = &q
+		}
+		if o.Resize != nil {
+			r := *o.Resize
+			o.Resize = &r
+		}
+		if o.LosslessLevel != nil {
+			level := *o.LosslessLevel
+			o.LosslessLevel = &level
+		}
+		e.opt, crop = &o, o.Crop
+	}
+
+	// the crop rectangle is checked against the bounds of each image
+	config, err := e.opt.encoderOptions(crop)
+	if err != nil {
+		return nil, err
+	}
+	config.Crop = image.Rectangle{}
+	if !config.valid(1, 1) {
+		return nil, errors.New("webp: NewEncoder, invalid options")
+	}
+	e.config = *config
+	return e, nil
+}
+
+// Encode writes the image m to w in WEBP format, as Encode.
+func (e *Encoder) Encode(w io.Writer, m image.Image) error {
+	config := e.config
+	if e.opt != nil {
+		if err := e.opt.setGeometry(&config, m.Bounds()); err != nil {
+			return err
+		}
+	}
+	if !e.pooled(&config, m) {
+		if e.opt != nil && e.opt.Mode == ModeAuto {
+			return encodeAuto(w, m, e.opt, &config)
+		}
+		return encodeWith(w, m, &config, e.opt)
+	}
+
+	b := m.Bounds()
+	p, err := e.picture(b.Dx(), b.Dy(), &config)
+	if err != nil {
+		return err
+	}
+	if err = e.importImage(p, m); err == nil {
+		err = webpStripPictureEncode(w, p.pic, &config)
+	}
+
+	// the encoder converts the ARGB pictures of the lossy format
+	if webpStripPictureUseARGB(p.pic) == p.useARGB {
+		e.pictures.Put(p)
+	} else {
+		p.free()
+	}
+	return err
+}
+
+// pooled reports whether m is encoded with a pooled picture.
+func (e *Encoder) pooled(config *encoderOptions, m image.Image) bool {
+	if e.opt != nil && (e.opt.Mode == ModeAuto || e.opt.Quantize != nil) {
+		return false
+	}
+	if !config.Crop.Empty() || config.ResizeWidth != 0 || config.ResizeHeight != 0 {
+		return false
+	}
+	if b := m.Bounds(); b.Empty() || b.Dx() > WEBP_MAX_DIMENSION || b.Dy() > WEBP_MAX_DIMENSION {
+		return false
+	}
+	switch m.(type) {
+	case *image.NRGBA, *image.RGBA:
+		return true
+	}
+	return false
+}
+
+// picture returns a pooled picture of the size width x height.
+func (e *Encoder) picture(width, height int, config *encoderOptions) (*encoderPicture, error) {
+	if v := e.pictures.Get(); v != nil {
+		p := v.(*encoderPicture)
+		if p.width == width && p.height == height {
+			return p, nil
+		}
+		p.free()
+	}
+	pic, useARGB, err := webpNewStripPicture(width, height, config)
+	if err != nil {
+		return nil, err
+	}
+	p := &encoderPicture{pic: pic, width: width, height: height, useARGB: useARGB}
+	p.cleanup = runtime.AddCleanup(p, webpFreeStripPicture, pic)
+	return p, nil
+}
+
+// importImage imports all the rows of m in p, by strips of encoderStripRows.
+func (e *Encoder) importImage(p *encoderPicture, m image.Image) error {
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	switch m := m.(type) {
+	case *image.NRGBA:
+		for y := 0; y < height; y += encoderStripRows {
+			n := min(encoderStripRows, height-y)
+			pix := m.Pix[m.PixOffset(b.Min.X, b.Min.Y+y):][:(n-1)*m.Stride+4*width]
+			if err := webpStripPictureImport(p.pic, pix, width, m.Stride, y, n); err != nil {
+				return err
+			}
+		}
+	case *image.RGBA:
+		strip := e.strip(4 * width * encoderStripRows)
+		defer e.strips.Put(strip)
+		for y := 0; y < height; y += encoderStripRows {
+			n := min(encoderStripRows, height-y)
+			for i := 0; i < n; i++ {
+				src := m.Pix[m.PixOffset(b.Min.X, b.Min.Y+y+i):][:4*width]
+				unpremultiplyRow((*strip)[4*width*i:][:4*width], src)
+			}
+			if err := webpStripPictureImport(p.pic, (*strip)[:4*width*n], width, 4*width, y, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// strip returns a pooled buffer of at least size bytes.
+func (e *Encoder) strip(size int) *[]byte {
+	if v := e.strips.Get(); v != nil {
+		if p := v.(*[]byte); cap(*p) >= size {
+			*p = (*p)[:size]
+			return p
+		}
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+// Decoder decodes images with the same options. It is safe for concurrent
+// use.
+//
+// The images are decoded directly in Go memory, without the C buffer and
+// the copy of DecodeRGBA, and the pixels of the images given back by
+// Release are reused by the next decodes.
+type Decoder struct {
+	opt     DecoderOptions
+	buffers sync.Pool // *[]byte, the Pix of the released images
+}
+
+// NewDecoder returns a Decoder with the options opt, which are copied and
+// validated once. A nil opt are the default options of Decode.
+func NewDecoder(opt *DecoderOptions) (*Decoder, error) {
+	d := &Decoder{}
+	if opt != nil {
+		d.opt = *opt
+	}
+	if d.opt.validDithering() != nil {
+		return nil, errors.New("webp: NewDecoder, invalid options")
+	}
+	return d, nil
+}
+
+// Decode decodes the WebP image in data, as Decode: the images with alpha
+// are returned as *image.NRGBA, the opaque images as *image.RGBA, and the
+// lossy images as *image.YCbCr or *image.NYCbCrA with PreferYCbCr. With a
+// dithering, the images are decoded in new memory, as by DecodeWithOptions.
+func (d *Decoder) Decode(data []byte) (m image.Image, err error) {
+	if d.opt.PreferYCbCr || d.opt.DitheringStrength != 0 || d.opt.AlphaDitheringStrength != 0 {
+		return decode(data, &d.opt)
+	}
+	width, height, hasAlpha, err := webpGetInfo(data)
+	if err != nil {
+		return
+	}
+
+	r := image.Rect(0, 0, width, height)
+	pix := d.buffer(4 * width * height)
+	mode := MODE_rgbA
+	if hasAlpha {
+		mode = MODE_RGBA
+	}
+	if err = webpDecodeInto(data, mode, *pix, 4*width); err != nil {
+		d.buffers.Put(pix)
+		return
+	}
+	if hasAlpha {
+		return &image.NRGBA{Pix: *pix, Stride: 4 * width, Rect: r}, nil
+	}
+	return &image.RGBA{Pix: *pix, Stride: 4 * width, Rect: r}, nil
+}
+
+// Release gives back the pixels of an image returned by Decode, which must
+// not be used anymore, for the next decodes. The other images are ignored.
+func (d *Decoder) Release(m image.Image) {
+	var pix []byte
+	switch m := m.(type) {
+	case *image.NRGBA:
+		pix = m.Pix
+	case *image.RGBA:
+		pix = m.Pix
+	default:
+		return
+	}
+	pix = pix[:cap(pix)]
+	d.buffers.Put(&pix)
+}
+
+// buffer returns a pooled buffer of size bytes.
+func (d *Decoder) buffer(size int) *[]byte {
+	if v := d.buffers.Get(); v != nil {
+		if p := v.(*[]byte); cap(*p) >= size {
+			*p = (*p)[:size]
+			return p
+		}
+	}
+	buf := make([]byte, size)
+	return &buf
+}
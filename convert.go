@@ -0,0 +1,398 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+const (
+	// images smaller than this are converted by the calling goroutine.
+	minParallelPixels = 256 * 256
+	minParallelRows   = 16
+)
+
+// rowReader fills row with the alpha-premultiplied 16-bit R, G, B, A values
+// of the line y of the image.
+type rowReader func(y int, row []uint32)
+
+// newRowReader returns the row kernel of m. The kernels of the known image
+// types only read the pixel buffers, they may run concurrently.
+func newRowReader(m image.Image) (fn rowReader, concurrent bool) {
+	b := m.Bounds()
+	if l, ok := rgba8LayoutOf(m); ok {
+		return l.rowReader(), true
+	}
+	switch m := m.(type) {
+	case *image.RGBA64:
+		return func(y int, row []uint32) {
+			pix := m.Pix[m.PixOffset(b.Min.X, y):][:8*b.Dx()]
+			for i := range row {
+				row[i] = uint32(pix[2*i])<<8 | uint32(pix[2*i+1])
+			}
+		}, true
+	case *image.NRGBA64:
+		return func(y int, row []uint32) {
+			pix := m.Pix[m.PixOffset(b.Min.X, y):][:8*b.Dx()]
+			for i := 0; i < len(row); i += 4 {
+				s := pix[2*i:][:8]
+				row[i+0], row[i+1], row[i+2], row[i+3] = color.NRGBA64{
+					R: uint16(s[0])<<8 | uint16(s[1]),
+					G: uint16(s[2])<<8 | uint16(s[3]),
+					B: uint16(s[4])<<8 | uint16(s[5]),
+					A: uint16(s[6])<<8 | uint16(s[7]),
+				}.RGBA()
+			}
+		}, true
+	case *image.Gray:
+		return func(y int, row []uint32) {
+			pix := m.Pix[m.PixOffset(b.Min.X, y):][:b.Dx()]
+			for i, v := range pix {
+				v16 := uint32(v) * 0x101
+				row[4*i+0], row[4*i+1], row[4*i+2], row[4*i+3] = v16, v16, v16, 0xffff
+			}
+		}, true
+	case *image.Gray16:
+		return func(y int, row []uint32) {
+			pix := m.Pix[m.PixOffset(b.Min.X, y):][:2*b.Dx()]
+			for i := 0; i < b.Dx(); i++ {
+				v16 := uint32(pix[2*i])<<8 | uint32(pix[2*i+1])
+				row[4*i+0], row[4*i+1], row[4*i+2], row[4*i+3] = v16, v16, v16, 0xffff
+			}
+		}, true
+	case *image.YCbCr:
+		cw := ycbcrChromaWidth(m.SubsampleRatio)
+		return func(y int, row []uint32) {
+			yy := m.Y[m.YOffset(b.Min.X, y):][:b.Dx()]
+			c0 := m.COffset(b.Min.X, y) - b.Min.X/cw
+			for i, x := 0, b.Min.X; x < b.Max.X; i, x = i+1, x+1 {
+				ci := c0 + x/cw
+				row[4*i+0], row[4*i+1], row[4*i+2], row[4*i+3] = color.YCbCr{
+					Y: yy[i], Cb: m.Cb[ci], Cr: m.Cr[ci],
+				}.RGBA()
+			}
+		}, true
+	case *image.NYCbCrA:
+		cw := ycbcrChromaWidth(m.SubsampleRatio)
+		return func(y int, row []uint32) {
+			yy := m.Y[m.YOffset(b.Min.X, y):][:b.Dx()]
+			aa := m.A[m.AOffset(b.Min.X, y):][:b.Dx()]
+			c0 := m.COffset(b.Min.X, y) - b.Min.X/cw
+			for i, x := 0, b.Min.X; x < b.Max.X; i, x = i+1, x+1 {
+				ci := c0 + x/cw
+				row[4*i+0], row[4*i+1], row[4*i+2], row[4*i+3] = color.NYCbCrA{
+					YCbCr: color.YCbCr{Y: yy[i], Cb: m.Cb[ci], Cr: m.Cr[ci]},
+					A:     aa[i],
+				}.RGBA()
+			}
+		}, true
+	case *image.Paletted:
+		var palette [256][4]uint32
+		for i, c := range m.Palette {
+			if i < len(palette) {
+				palette[i][0], palette[i][1], palette[i][2], palette[i][3] = c.RGBA()
+			}
+		}
+		return func(y int, row []uint32) {
+			pix := m.Pix[m.PixOffset(b.Min.X, y):][:b.Dx()]
+			for i, v := range pix {
+				copy(row[4*i:][:4], palette[v][:])
+			}
+		}, true
+	case *image.CMYK:
+		return func(y int, row []uint32) {
+			pix := m.Pix[m.PixOffset(b.Min.X, y):][:4*b.Dx()]
+			for i := 0; i < len(pix); i += 4 {
+				row[i+0], row[i+1], row[i+2], row[i+3] = color.CMYK{
+					C: pix[i+0], M: pix[i+1], Y: pix[i+2], K: pix[i+3],
+				}.RGBA()
+			}
+		}, true
+	case *RGBImage:
+		return rgb8RowReader(m.XPix[m.PixOffset(b.Min.X, b.Min.Y):], m.XStride, b, [3]int{0, 1, 2}), true
+	case *BGRImage:
+		return rgb8RowReader(m.XPix[m.PixOffset(b.Min.X, b.Min.Y):], m.XStride, b, [3]int{2, 1, 0}), true
+	}
+
+	if p, ok := AsMemPImage(m); ok {
+		if fn := newMemPRowReader(p); fn != nil {
+			return fn, true
+		}
+	}
+
+	return func(y int, row []uint32) {
+		for i, x := 0, b.Min.X; x < b.Max.X; i, x = i+4, x+1 {
+			row[i+0], row[i+1], row[i+2], row[i+3] = m.At(x, y).RGBA()
+		}
+	}, false
+}
+
+// rgba8Layout describes the pixels of the 4 channels uint8 images.
+type rgba8Layout struct {
+	Pix           []uint8 // the first pixel of the image
+	Stride        int
+	Rect          image.Rectangle
+	Order         [4]int // offsets of R, G, B and A in a pixel
+	Premultiplied bool
+}
+
+func rgba8LayoutOf(m image.Image) (l rgba8Layout, ok bool) {
+	b := m.Bounds()
+	if b.Empty() {
+		return
+	}
+	switch m := m.(type) {
+	case *image.RGBA:
+		return rgba8Layout{m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, b, [4]int{0, 1, 2, 3}, true}, true
+	case *image.NRGBA:
+		return rgba8Layout{m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, b, [4]int{0, 1, 2, 3}, false}, true
+	case *BGRAImage:
+		return rgba8Layout{m.XPix[m.PixOffset(b.Min.X, b.Min.Y):], m.XStride, b, [4]int{2, 1, 0, 3}, m.XPremultiplied}, true
+	case *ARGBImage:
+		return rgba8Layout{m.XPix[m.PixOffset(b.Min.X, b.Min.Y):], m.XStride, b, [4]int{1, 2, 3, 0}, m.XPremultiplied}, true
+	}
+	return
+}
+
+// line returns the pixels of the line y.
+func (l rgba8Layout) line(y int) []uint8 {
+	return l.Pix[(y-l.Rect.Min.Y)*l.Stride:][:4*l.Rect.Dx()]
+}
+
+func (l rgba8Layout) rowReader() rowReader {
+	o := l.Order
+	if l.Premultiplied {
+		return func(y int, row []uint32) {
+			pix := l.line(y)
+			for i := 0; i < len(pix); i += 4 {
+				row[i+0] = uint32(pix[i+o[0]]) * 0x101
+				row[i+1] = uint32(pix[i+o[1]]) * 0x101
+				row[i+2] = uint32(pix[i+o[2]]) * 0x101
+				row[i+3] = uint32(pix[i+o[3]]) * 0x101
+			}
+		}
+	}
+	return func(y int, row []uint32) {
+		pix := l.line(y)
+		for i := 0; i < len(pix); i += 4 {
+			row[i+0], row[i+1], row[i+2], row[i+3] = color.NRGBA{
+				R: pix[i+o[0]], G: pix[i+o[1]], B: pix[i+o[2]], A: pix[i+o[3]],
+			}.RGBA()
+		}
+	}
+}
+
+// rgb8RowReader returns the row kernel of the 3 channels uint8 images,
+// pix starts at the first pixel of the image.
+func rgb8RowReader(pix []uint8, stride int, b image.Rectangle, order [3]int) rowReader {
+	return func(y int, row []uint32) {
+		line := pix[(y-b.Min.Y)*stride:][:3*b.Dx()]
+		for i := 0; i < b.Dx(); i++ {
+			row[4*i+0] = uint32(line[3*i+order[0]]) * 0x101
+			row[4*i+1] = uint32(line[3*i+order[1]]) * 0x101
+			row[4*i+2] = uint32(line[3*i+order[2]]) * 0x101
+			row[4*i+3] = 0xffff
+		}
+	}
+}
+
+// ycbcrChromaWidth returns the number of luma columns sharing a chroma
+// sample, as in image.YCbCr.COffset.
+func ycbcrChromaWidth(ratio image.YCbCrSubsampleRatio) int {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420:
+		return 2
+	case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+		return 4
+	}
+	return 1
+}
+
+// newMemPRowReader returns the row kernel of the 1, 3 or 4 channels uint8
+// and uint16 (native endian) MemP images, or nil.
+func newMemPRowReader(p *MemPImage) rowReader {
+	b := p.XRect
+	ch := p.XChannels
+	if ch != 1 && ch != 3 && ch != 4 {
+		return nil
+	}
+	var sample func(pix []byte, i int) uint32
+	switch p.XDataType {
+	case reflect.Uint8:
+		sample = func(pix []byte, i int) uint32 {
+			return uint32(pix[i]) * 0x101
+		}
+	case reflect.Uint16:
+		sample = func(pix []byte, i int) uint32 {
+			if isLittleEndian {
+				return uint32(pix[2*i+1])<<8 | uint32(pix[2*i])
+			}
+			return uint32(pix[2*i])<<8 | uint32(pix[2*i+1])
+		}
+	default:
+		return nil
+	}
+	return func(y int, row []uint32) {
+		pix := p.XPix[p.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			r := row[4*x:][:4]
+			switch ch {
+			case 1:
+				v := sample(pix, x)
+				r[0], r[1], r[2], r[3] = v, v, v, 0xffff
+			case 3:
+				r[0], r[1], r[2], r[3] = sample(pix, 3*x+0), sample(pix, 3*x+1), sample(pix, 3*x+2), 0xffff
+			case 4:
+				r[0], r[1], r[2], r[3] = sample(pix, 4*x+0), sample(pix, 4*x+1), sample(pix, 4*x+2), sample(pix, 4*x+3)
+			}
+		}
+	}
+}
+
+// convertRows reads the rows of m and passes them to the row writer fn.
+// The rows are split between a bounded number of goroutines for the
+// large images whose reader supports it.
+func convertRows(m image.Image, fn func(y int, row []uint32)) {
+	b := m.Bounds()
+	if b.Empty() {
+		return
+	}
+	read, concurrent := newRowReader(m)
+
+	workers := runtime.GOMAXPROCS(0)
+	if n := b.Dy() / minParallelRows; workers > n {
+		workers = n
+	}
+	if !concurrent || workers < 2 || b.Dx()*b.Dy() < minParallelPixels {
+		row := make([]uint32, 4*b.Dx())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			read(y, row)
+			fn(y, row)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	step := (b.Dy() + workers - 1) / workers
+	for y0 := b.Min.Y; y0 < b.Max.Y; y0 += step {
+		y1 := y0 + step
+		if y1 > b.Max.Y {
+			y1 = b.Max.Y
+		}
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			row := make([]uint32, 4*b.Dx())
+			for y := y0; y < y1; y++ {
+				read(y, row)
+				fn(y, row)
+			}
+		}(y0, y1)
+	}
+	wg.Wait()
+}
+
+func convertToGray(m image.Image) *image.Gray {
+	gray := image.NewGray(m.Bounds())
+	convertRows(m, func(y int, row []uint32) {
+		dst := gray.Pix[gray.PixOffset(gray.Rect.Min.X, y):][:gray.Rect.Dx()]
+		for i := range dst {
+			r, g, b := row[4*i+0], row[4*i+1], row[4*i+2]
+			// same as color.GrayModel
+			dst[i] = uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+		}
+	})
+	return gray
+}
+
+func convertToRGBA(m image.Image) *image.RGBA {
+	rgba := image.NewRGBA(m.Bounds())
+	convertRows(m, func(y int, row []uint32) {
+		dst := rgba.Pix[rgba.PixOffset(rgba.Rect.Min.X, y):][:4*rgba.Rect.Dx()]
+		for i := range dst {
+			dst[i] = uint8(row[i] >> 8)
+		}
+	})
+	return rgba
+}
+
+func convertToNRGBA(m image.Image) *image.NRGBA {
+	b := m.Bounds()
+	nrgba := image.NewNRGBA(b)
+	if l, ok := rgba8LayoutOf(m); ok && !l.Premultiplied {
+		// NRGBAModel keeps the straight colors.
+		o := l.Order
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			src := l.line(y)
+			dst := nrgba.Pix[nrgba.PixOffset(b.Min.X, y):][:4*b.Dx()]
+			for i := 0; i < len(src); i += 4 {
+				dst[i+0], dst[i+1], dst[i+2], dst[i+3] = src[i+o[0]], src[i+o[1]], src[i+o[2]], src[i+o[3]]
+			}
+		}
+		return nrgba
+	}
+	if src, ok := m.(*image.Paletted); ok {
+		var palette [256]color.NRGBA
+		for i, c := range src.Palette {
+			if i < len(palette) {
+				palette[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+			}
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			pix := src.Pix[src.PixOffset(b.Min.X, y):][:b.Dx()]
+			dst := nrgba.Pix[nrgba.PixOffset(b.Min.X, y):][:4*b.Dx()]
+			for i, v := range pix {
+				c := palette[v]
+				dst[4*i+0], dst[4*i+1], dst[4*i+2], dst[4*i+3] = c.R, c.G, c.B, c.A
+			}
+		}
+		return nrgba
+	}
+	if _, ok := newRowReader(m); !ok {
+		// the colors may already be color.NRGBA, which NRGBAModel keeps.
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				nrgba.Set(x, y, m.At(x, y))
+			}
+		}
+		return nrgba
+	}
+	convertRows(m, func(y int, row []uint32) {
+		dst := nrgba.Pix[nrgba.PixOffset(nrgba.Rect.Min.X, y):][:4*nrgba.Rect.Dx()]
+		for i := 0; i < len(dst); i += 4 {
+			r, g, b, a := row[i+0], row[i+1], row[i+2], row[i+3]
+			// same as color.NRGBAModel
+			switch a {
+			case 0xffff:
+				dst[i+0], dst[i+1], dst[i+2], dst[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), 0xff
+			case 0:
+				dst[i+0], dst[i+1], dst[i+2], dst[i+3] = 0, 0, 0, 0
+			default:
+				r = (r * 0xffff) / a
+				g = (g * 0xffff) / a
+				b = (b * 0xffff) / a
+				dst[i+0], dst[i+1], dst[i+2], dst[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+			}
+		}
+	})
+	return nrgba
+}
+
+func convertToRGB(m image.Image) *RGBImage {
+	rgb := NewRGBImage(m.Bounds())
+	convertRows(m, func(y int, row []uint32) {
+		dst := rgb.XPix[rgb.PixOffset(rgb.XRect.Min.X, y):][:3*rgb.XRect.Dx()]
+		for i := 0; i < rgb.XRect.Dx(); i++ {
+			dst[3*i+0] = uint8(row[4*i+0] >> 8)
+			dst[3*i+1] = uint8(row[4*i+1] >> 8)
+			dst[3*i+2] = uint8(row[4*i+2] >> 8)
+		}
+	})
+	return rgb
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+
+package webp
+
+import (
+	"errors"
+	"image"
+)
+
+// Metric is the distortion measured by Distortion.
+type Metric int
+
+const (
+	PSNR Metric = iota // peak signal-to-noise ratio
+	SSIM               // structural similarity, as -10*log10(1 - ssim)
+	LSIM               // local similarity of libwebp
+)
+
+func (m Metric) String() string {
+	switch m {
+	case PSNR:
+		return "PSNR"
+	case SSIM:
+		return "SSIM"
+	case LSIM:
+		return "LSIM"
+	}
+	return "unknown"
+}
+
+// Distortion measures the distortion of dist against the reference image
+// ref with libwebp (WebPPictureDistortion). The images must have the same
+// size. They are compared in 8-bit alpha-premultiplied RGBA, so that the
+// colors of the transparent pixels, which the encoder may discard, are not
+// counted.
+//
+// The results are in dB, for the R, G, B and A channels and for all the
+// channels, and are capped at 99 dB for identical images.
+func Distortion(ref, dist image.Image, metric Metric) (perChannel [5]float32, err error) {
+	if metric < PSNR || metric > LSIM {
+		err = errors.New("webp: Distortion, unknown metric")
+		return
+	}
+	size := ref.Bounds().Size()
+	if size != dist.Bounds().Size() {
+		err = errors.New("webp: Distortion, images have different sizes")
+		return
+	}
+	if size.X <= 0 || size.Y <= 0 {
+		err = errors.New("webp: Distortion, empty image")
+		return
+	}
+
+	p0, p1 := toRGBAImage(ref), toRGBAImage(dist)
+	bgra, err := webpPictureDistortion(p0.Pix, p0.Stride, p1.Pix, p1.Stride, size.X, size.Y, int(metric))
+	if err != nil {
+		return
+	}
+	perChannel = [5]float32{bgra[2], bgra[1], bgra[0], bgra[3], bgra[4]}
+	return
+}
@@ -0,0 +1,327 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+)
+
+// Dither is the reduction of the 16-bit images (RGB48Image, image.Gray16,
+// image.RGBA64, image.NRGBA64 and the uint16 MemP images) to the 8-bit
+// samples of the encoder.
+type Dither int
+
+const (
+	DitherTruncate       Dither = iota // keep the high byte
+	DitherRound                        // round to the nearest 8-bit value
+	DitherOrdered                      // 8x8 Bayer matrix
+	DitherFloydSteinberg               // error diffusion
+	DitherBlueNoise                    // 32x32 blue noise mask
+)
+
+func (d Dither) String() string {
+	switch d {
+	case DitherTruncate:
+		return "truncate"
+	case DitherRound:
+		return "round"
+	case DitherOrdered:
+		return "ordered"
+	case DitherFloydSteinberg:
+		return "floyd-steinberg"
+	case DitherBlueNoise:
+		return "blue-noise"
+	}
+	return "unknown"
+}
+
+// reduceDepth converts the 16-bit image m to an *image.Gray, *RGBImage or
+// *image.NRGBA with the dither d. It returns false if m is not a 16-bit
+// image, or for DitherTruncate, which is the default conversion.
+func reduceDepth(m image.Image, d Dither) (image.Image, bool) {
+	if d == DitherTruncate || d > DitherBlueNoise {
+		return nil, false
+	}
+
+	var channels int
+	switch m.(type) {
+	case *image.Gray16:
+		channels = 1
+	case *image.RGBA64, *image.NRGBA64:
+		channels = 4
+	default:
+		p, ok := AsMemPImage(m)
+		if !ok || p.XDataType != reflect.Uint16 {
+			return nil, false
+		}
+		if channels = p.XChannels; channels != 1 && channels != 3 && channels != 4 {
+			return nil, false
+		}
+	}
+
+	b := m.Bounds()
+	var dst image.Image
+	var dstPix func(y int) []uint8
+	switch channels {
+	case 1:
+		gray := image.NewGray(b)
+		dst, dstPix = gray, func(y int) []uint8 {
+			return gray.Pix[gray.PixOffset(b.Min.X, y):][:b.Dx()]
+		}
+	case 3:
+		rgb := NewRGBImage(b)
+		dst, dstPix = rgb, func(y int) []uint8 {
+			return rgb.XPix[rgb.PixOffset(b.Min.X, y):][:3*b.Dx()]
+		}
+	case 4:
+		nrgba := image.NewNRGBA(b)
+		dst, dstPix = nrgba, func(y int) []uint8 {
+			return nrgba.Pix[nrgba.PixOffset(b.Min.X, y):][:4*b.Dx()]
+		}
+	}
+	if b.Empty() {
+		return dst, true
+	}
+
+	if d == DitherFloydSteinberg {
+		ditherFloydSteinberg(m, channels, dstPix)
+		return dst, true
+	}
+
+	threshold := ditherThreshold(d)
+	convertRows(m, func(y int, row []uint32) {
+		straightRow(row)
+		pix := dstPix(y)
+		for i, x := 0, b.Min.X; x < b.Max.X; i, x = i+1, x+1 {
+			t := threshold(x, y)
+			s := row[4*i:][:4]
+			switch channels {
+			case 1:
+				pix[i] = quantize16(s[0], t)
+			case 3:
+				pix[3*i+0] = quantize16(s[0], t)
+				pix[3*i+1] = quantize16(s[1], t)
+				pix[3*i+2] = quantize16(s[2], t)
+			case 4:
+				pix[4*i+0] = quantize16(s[0], t)
+				pix[4*i+1] = quantize16(s[1], t)
+				pix[4*i+2] = quantize16(s[2], t)
+				pix[4*i+3] = quantize16(s[3], 128)
+			}
+		}
+	})
+	return dst, true
+}
+
+// straightRow converts the alpha-premultiplied 16-bit row to straight alpha.
+func straightRow(row []uint32) {
+	for i := 0; i < len(row); i += 4 {
+		switch a := row[i+3]; a {
+		case 0xffff:
+		case 0:
+			row[i+0], row[i+1], row[i+2] = 0, 0, 0
+		default:
+			for c := 0; c < 3; c++ {
+				if v := row[i+c] * 0xffff / a; v < 0xffff {
+					row[i+c] = v
+				} else {
+					row[i+c] = 0xffff
+				}
+			}
+		}
+	}
+}
+
+// quantize16 returns the 8-bit value of the 16-bit sample v, offset by the
+// threshold t in [0, 257).
+func quantize16(v, t uint32) uint8 {
+	return uint8((v + t) / 257)
+}
+
+// ditherThreshold returns the threshold function of the dither d, for the
+// pixel at (x, y).
+func ditherThreshold(d Dither) func(x, y int) uint32 {
+	switch d {
+	case DitherOrdered:
+		return func(x, y int) uint32 {
+			return bayer8[y&7][x&7]
+		}
+	case DitherBlueNoise:
+		blueNoiseOnce.Do(initBlueNoise)
+		return func(x, y int) uint32 {
+			return blueNoise[y&(blueNoiseSize-1)][x&(blueNoiseSize-1)]
+		}
+	}
+	return func(x, y int) uint32 {
+		return 128
+	}
+}
+
+// bayer8 is the 8x8 Bayer matrix, scaled to thresholds in [0, 257).
+var bayer8 = func() (m [8][8]uint32) {
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			var v uint32
+			for bit := 0; bit < 3; bit++ {
+				v = v<<2 | uint32((x^y)>>bit&1)<<1 | uint32(y>>bit&1)
+			}
+			m[y][x] = (2*v + 1) * 257 / 128
+		}
+	}
+	return
+}()
+
+const blueNoiseSize = 32
+
+var (
+	blueNoiseOnce sync.Once
+	blueNoise     [blueNoiseSize][blueNoiseSize]uint32
+)
+
+// initBlueNoise builds the blue noise mask with the void-and-cluster method
+// of Ulichney.
+func initBlueNoise() {
+	const (
+		size  = blueNoiseSize
+		n     = size * size
+		sigma = 1.5
+	)
+
+	// toroidal Gaussian energy of a pixel on its neighbours
+	var kernel [size][size]float64
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			fx := math.Min(float64(dx), float64(size-dx))
+			fy := math.Min(float64(dy), float64(size-dy))
+			kernel[dy][dx] = math.Exp(-(fx*fx + fy*fy) / (2 * sigma * sigma))
+		}
+	}
+
+	var pattern [n]bool
+	var energy [n]float64
+	set := func(i int, on bool) {
+		pattern[i] = on
+		sign := 1.0
+		if !on {
+			sign = -1
+		}
+		x0, y0 := i%size, i/size
+		for y := 0; y < size; y++ {
+			row := kernel[(y-y0+size)%size][:]
+			for x := 0; x < size; x++ {
+				energy[y*size+x] += sign * row[(x-x0+size)%size]
+			}
+		}
+	}
+	// tightestCluster returns the set pixel of the highest energy,
+	// largestVoid the unset pixel of the lowest energy.
+	tightestCluster := func() (k int) {
+		k = -1
+		for i := range pattern {
+			if pattern[i] && (k < 0 || energy[i] > energy[k]) {
+				k = i
+			}
+		}
+		return
+	}
+	largestVoid := func() (k int) {
+		k = -1
+		for i := range pattern {
+			if !pattern[i] && (k < 0 || energy[i] < energy[k]) {
+				k = i
+			}
+		}
+		return
+	}
+
+	// initial binary pattern, relaxed until it is evenly distributed
+	r := rand.New(rand.NewSource(1))
+	ones := 0
+	for ones < n/10 {
+		if i := r.Intn(n); !pattern[i] {
+			set(i, true)
+			ones++
+		}
+	}
+	for {
+		c := tightestCluster()
+		set(c, false)
+		v := largestVoid()
+		set(v, true)
+		if c == v {
+			break
+		}
+	}
+	initial, initialEnergy := pattern, energy
+
+	var rank [n]int
+	for k := ones; k > 0; k-- {
+		i := tightestCluster()
+		set(i, false)
+		rank[i] = k - 1
+	}
+	pattern, energy = initial, initialEnergy
+	for k := ones; k < n; k++ {
+		i := largestVoid()
+		set(i, true)
+		rank[i] = k
+	}
+
+	for i, v := range rank {
+		blueNoise[i/size][i%size] = uint32((2*v + 1) * 257 / (2 * n))
+	}
+}
+
+// ditherFloydSteinberg diffuses the quantization error of every channel to
+// the unprocessed neighbours. The rows depend on each other, so this runs on
+// the calling goroutine.
+func ditherFloydSteinberg(m image.Image, channels int, dstPix func(y int) []uint8) {
+	b := m.Bounds()
+	w := b.Dx()
+	read, _ := newRowReader(m)
+	row := make([]uint32, 4*w)
+
+	// errors of the current and the next line, in 1/16 of 16-bit units,
+	// with a pixel of padding on both sides.
+	cur := make([]int32, 4*(w+2))
+	next := make([]int32, 4*(w+2))
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		read(y, row)
+		straightRow(row)
+		pix := dstPix(y)
+		for x := 0; x < w; x++ {
+			s := row[4*x:][:4]
+			for c := 0; c < 3 && c < channels; c++ {
+				e := 4*(x+1) + c
+				v := int32(s[c]) + cur[e]/16
+				if v < 0 {
+					v = 0
+				} else if v > 0xffff {
+					v = 0xffff
+				}
+				q := (v + 128) / 257
+				pix[channels*x+c] = uint8(q)
+
+				diff := v - q*257
+				cur[e+4] += diff * 7
+				next[e-4] += diff * 3
+				next[e] += diff * 5
+				next[e+4] += diff * 1
+			}
+			if channels == 4 {
+				pix[4*x+3] = quantize16(s[3], 128)
+			}
+		}
+		cur, next = next, cur
+		for i := range next {
+			next[i] = 0
+		}
+	}
+}
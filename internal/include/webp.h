@@ -8,6 +8,8 @@
 #include <stddef.h>
 #include <stdint.h>
 #include <webp/decode.h>
+#include <webp/encode.h>
+#include <webp/mux.h>
 
 #ifdef __cplusplus
 extern "C" {
@@ -19,6 +21,26 @@ int webpGetInfo(
 	int* has_alpha
 );
 
+typedef struct webpFeatures {
+	int width;
+	int height;
+	int has_alpha;
+	int has_animation;
+	int format; // 0: undefined/mixed, 1: lossy, 2: lossless
+
+	uint32_t format_flags; // bit-wise combination of WebPFeatureFlags
+	int canvas_width;
+	int canvas_height;
+	int frame_count;
+	int loop_count;
+	uint32_t background_color; // BGRA byte order
+} webpFeatures;
+
+int webpGetFeatures(
+	const uint8_t* data, size_t data_size,
+	webpFeatures* features
+);
+
 uint8_t* webpDecodeGray(
 	const uint8_t* data, size_t data_size,
 	int* width, int* height
@@ -31,6 +53,10 @@ uint8_t* webpDecodeRGBA(
 	const uint8_t* data, size_t data_size,
 	int* width, int* height
 );
+uint8_t* webpDecodePremulRGBA(
+	const uint8_t* data, size_t data_size,
+	int* width, int* height
+);
 
 int webpDecodeGrayToSize(const uint8_t* data, size_t data_size,
 	int width, int height, int outStride, uint8_t* out
@@ -41,6 +67,28 @@ int webpDecodeRGBToSize(const uint8_t* data, size_t data_size,
 int webpDecodeRGBAToSize(const uint8_t* data, size_t data_size,
 	int width, int height, int outStride, uint8_t* out
 );
+int webpDecodePremulRGBAToSize(const uint8_t* data, size_t data_size,
+	int width, int height, int outStride, uint8_t* out
+);
+
+int webpDecodeInto(const uint8_t* data, size_t data_size,
+	int colorspace, uint8_t* out, int out_stride, size_t out_size
+);
+// webpDecodeRegion decodes the crop rectangle, scaled to scaled_width x
+// scaled_height, in RGBA (not premultiplied), with the dithering strengths
+// (0 ~ 100) of the lossy images. libwebp rounds the crop offsets down to
+// even.
+int webpDecodeRegion(const uint8_t* data, size_t data_size,
+	int crop_x, int crop_y, int crop_width, int crop_height,
+	int scaled_width, int scaled_height,
+	int dithering_strength, int alpha_dithering_strength,
+	uint8_t* out, int out_stride, size_t out_size
+);
+int webpDecodeYUVAInto(const uint8_t* data, size_t data_size,
+	uint8_t* y, int y_stride, size_t y_size,
+	uint8_t* u, uint8_t* v, int uv_stride, size_t uv_size,
+	uint8_t* a, int a_stride, size_t a_size
+);
 
 uint8_t* webpEncodeGray(
 	const uint8_t* gray, int width, int height, int stride, float quality_factor,
@@ -55,6 +103,56 @@ uint8_t* webpEncodeRGBA(
 	size_t* output_size
 );
 
+// preset: WebPPreset, near_lossless: 100 is off (lossless only),
+// use_sharp_yuv: RGB to YUV conversion of the lossy pictures imported from RGB,
+// lossless_level: 0 ~ 9 of WebPConfigLosslessPreset (lossless only),
+// exact_alpha: no lossy alpha compression and no near-lossless preprocessing of the alpha,
+// crop_width/crop_height of 0: no cropping,
+// resize_width/resize_height of 0: no rescaling (only one 0 keeps the aspect ratio).
+typedef struct webpEncoderOptions {
+	int lossless;
+	float quality;
+	int exact;
+	int preset;
+	int near_lossless;
+	int use_sharp_yuv;
+	int lossless_level;
+	int use_delta_palette;
+	int exact_alpha;
+	int crop_x, crop_y, crop_width, crop_height;
+	int resize_width, resize_height;
+} webpEncoderOptions;
+
+// webpGoWrite writes a chunk of the output to the Go writer of the handle,
+// it returns 0 on error, which aborts the encoding (implemented in Go).
+int webpGoWrite(uint8_t* data, size_t data_size, uintptr_t writer);
+
+// channels: 1 (gray), 3 (RGB) or 4 (RGBA, not premultiplied),
+// writer: the handle of the Go writer of the output.
+int webpEncodePix(
+	const uint8_t* pix, int channels, int width, int height, int stride,
+	const webpEncoderOptions* opt,
+	uintptr_t writer
+);
+
+int webpEncodeYUVA(
+	const uint8_t* y, const uint8_t* u, const uint8_t* v, const uint8_t* a,
+	int width, int height, int y_stride, int uv_stride, int a_stride,
+	const webpEncoderOptions* opt,
+	uintptr_t writer
+);
+
+// The strip pictures are allocated as ARGB or YUV420A, as webpEncodePix
+// imports them, and filled by strips of RGBA rows (not premultiplied). The
+// YUV strips start on an even row, only the last one has an odd height.
+// webpStripPictureEncode keeps pic, which may be cropped, rescaled or
+// converted by the encoder, webpFreeStripPicture frees it.
+WebPPicture* webpNewStripPicture(int width, int height, const webpEncoderOptions* opt);
+int webpStripPictureImport(WebPPicture* pic, const uint8_t* rgba, int stride, int y, int rows);
+int webpStripPictureEncode(WebPPicture* pic, const webpEncoderOptions* opt, uintptr_t writer);
+int webpStripPictureUseARGB(const WebPPicture* pic);
+void webpFreeStripPicture(WebPPicture* pic);
+
 uint8_t* webpEncodeLosslessGray(
 	const uint8_t* gray, int width, int height, int stride,
 	size_t* output_size
@@ -68,6 +166,13 @@ uint8_t* webpEncodeLosslessRGBA(
 	size_t* output_size
 );
 
+// metric: 0 (PSNR), 1 (SSIM) or 2 (LSIM), result in the B/G/R/A/All order.
+int webpPictureDistortion(
+	const uint8_t* ref, int ref_stride, const uint8_t* dist, int dist_stride,
+	int width, int height, int metric,
+	float result[5]
+);
+
 char* webpGetEXIF(const uint8_t* data, size_t data_size, size_t* metadata_size);
 char* webpGetICCP(const uint8_t* data, size_t data_size, size_t* metadata_size);
 char* webpGetXMP(const uint8_t* data, size_t data_size, size_t* metadata_size);
@@ -80,6 +185,17 @@ uint8_t* webpDelEXIF(const uint8_t* data, size_t data_size, size_t* new_data_siz
 uint8_t* webpDelICCP(const uint8_t* data, size_t data_size, size_t* new_data_size);
 uint8_t* webpDelXMP(const uint8_t* data, size_t data_size, size_t* new_data_size);
 
+// loop_count: 0 (infinite), the data must be an animation.
+uint8_t* webpSetLoopCount(const uint8_t* data, size_t data_size, int loop_count, size_t* new_data_size);
+
+// n: 1-based, the frame is returned as a still WebP file.
+uint8_t* webpGetFrame(const uint8_t* data, size_t data_size, int n, int* x_offset, int* y_offset, int* duration, int* dispose, int* blend, size_t* frame_size);
+
+WebPMux* webpNewMux(void);
+int webpMuxPushFrame(WebPMux* mux, const uint8_t* data, size_t data_size, int x_offset, int y_offset, int duration, int dispose, int blend);
+uint8_t* webpMuxAssembleAnimation(WebPMux* mux, int canvas_width, int canvas_height, int loop_count, uint32_t bgcolor, size_t* output_size);
+void webpMuxDelete(WebPMux* mux);
+
 void* webpMalloc(size_t size);
 void webpFree(void* p);
 
@@ -33,6 +33,59 @@ int webpGetInfo(
 	return 1;
 }
 
+int webpGetFeatures(
+	const uint8_t* data, size_t data_size,
+	webpFeatures* features
+) {
+	WebPBitstreamFeatures bs;
+	WebPData webp_data = {data, data_size};
+	WebPDemuxer* demux;
+	WebPIterator iter;
+
+	memset(features, 0, sizeof(*features));
+	if(WebPGetFeatures(data, data_size, &bs) != VP8_STATUS_OK) {
+		return 0;
+	}
+	features->width = bs.width;
+	features->height = bs.height;
+	features->has_alpha = bs.has_alpha;
+	features->has_animation = bs.has_animation;
+	features->format = bs.format;
+
+	if((demux = WebPDemux(&webp_data)) == NULL) {
+		return 0;
+	}
+	features->format_flags = WebPDemuxGetI(demux, WEBP_FF_FORMAT_FLAGS);
+	features->canvas_width = WebPDemuxGetI(demux, WEBP_FF_CANVAS_WIDTH);
+	features->canvas_height = WebPDemuxGetI(demux, WEBP_FF_CANVAS_HEIGHT);
+	features->frame_count = WebPDemuxGetI(demux, WEBP_FF_FRAME_COUNT);
+	features->loop_count = WebPDemuxGetI(demux, WEBP_FF_LOOP_COUNT);
+	features->background_color = WebPDemuxGetI(demux, WEBP_FF_BACKGROUND_COLOR);
+
+	// The container of an animation reports an undefined format,
+	// resolve it from the frames: lossy, lossless or mixed.
+	if(features->has_animation && WebPDemuxGetFrame(demux, 1, &iter)) {
+		int format = -1;
+		do {
+			if(WebPGetFeatures(iter.fragment.bytes, iter.fragment.size, &bs) != VP8_STATUS_OK) {
+				format = 0;
+				break;
+			}
+			if(format < 0) {
+				format = bs.format;
+			} else if(format != bs.format) {
+				format = 0;
+				break;
+			}
+		} while(WebPDemuxNextFrame(&iter));
+		WebPDemuxReleaseIterator(&iter);
+		features->format = (format < 0)? 0: format;
+	}
+
+	WebPDemuxDelete(demux);
+	return 1;
+}
+
 uint8_t* webpDecodeGray(
 	const uint8_t* data, size_t data_size,
 	int* width, int* height
@@ -88,6 +141,31 @@ uint8_t* webpDecodeRGBA(
 	return WebPDecodeRGBA(data, data_size, width, height);
 }
 
+uint8_t* webpDecodePremulRGBA(
+	const uint8_t* data, size_t data_size,
+	int* width, int* height
+) {
+	WebPDecoderConfig config;
+	if(!WebPInitDecoderConfig(&config)) {
+		return NULL;
+	}
+
+	config.output.colorspace = MODE_rgbA;
+	if(WebPDecode(data, data_size, &config) != VP8_STATUS_OK) {
+		WebPFreeDecBuffer(&config.output);
+		return NULL;
+	}
+	if(width != NULL) {
+		*width = config.output.width;
+	}
+	if(height != NULL) {
+		*height = config.output.height;
+	}
+
+	// the rgba plane owns the private memory of the decoder buffer.
+	return config.output.u.RGBA.rgba;
+}
+
 int webpDecodeGrayToSize(const uint8_t* data, size_t data_size,
 	int width, int height, int outStride, uint8_t* out
 ) {
@@ -145,8 +223,8 @@ int webpDecodeRGBToSize(const uint8_t* data, size_t data_size,
 	return WebPDecode(data, data_size, &config);
 }
 
-int webpDecodeRGBAToSize(const uint8_t* data, size_t data_size,
-	int width, int height, int outStride, uint8_t* out
+static int decodeRGBAToSize(const uint8_t* data, size_t data_size,
+	WEBP_CSP_MODE colorspace, int width, int height, int outStride, uint8_t* out
 ) {
 	WebPDecoderConfig config;
 	if(!WebPInitDecoderConfig(&config)) {
@@ -158,7 +236,7 @@ int webpDecodeRGBAToSize(const uint8_t* data, size_t data_size,
 	config.options.use_scaling = 1;
 	config.options.scaled_width = width;
 	config.options.scaled_height = height;
-	config.output.colorspace = MODE_RGBA;
+	config.output.colorspace = colorspace;
 	config.output.u.RGBA.rgba = out;
 	config.output.u.RGBA.stride = outStride;
 	config.output.u.RGBA.size = outStride * height;
@@ -167,6 +245,95 @@ int webpDecodeRGBAToSize(const uint8_t* data, size_t data_size,
 	return WebPDecode(data, data_size, &config);
 }
 
+int webpDecodeRGBAToSize(const uint8_t* data, size_t data_size,
+	int width, int height, int outStride, uint8_t* out
+) {
+	return decodeRGBAToSize(data, data_size, MODE_RGBA, width, height, outStride, out);
+}
+
+int webpDecodePremulRGBAToSize(const uint8_t* data, size_t data_size,
+	int width, int height, int outStride, uint8_t* out
+) {
+	return decodeRGBAToSize(data, data_size, MODE_rgbA, width, height, outStride, out);
+}
+
+int webpDecodeInto(const uint8_t* data, size_t data_size,
+	int colorspace, uint8_t* out, int out_stride, size_t out_size
+) {
+	WebPDecoderConfig config;
+	if(!WebPInitDecoderConfig(&config)) {
+		return -1;
+	}
+
+	config.output.colorspace = (WEBP_CSP_MODE)colorspace;
+	config.output.u.RGBA.rgba = out;
+	config.output.u.RGBA.stride = out_stride;
+	config.output.u.RGBA.size = out_size;
+	config.output.is_external_memory = 1;
+
+	return WebPDecode(data, data_size, &config);
+}
+
+int webpDecodeRegion(const uint8_t* data, size_t data_size,
+	int crop_x, int crop_y, int crop_width, int crop_height,
+	int scaled_width, int scaled_height,
+	int dithering_strength, int alpha_dithering_strength,
+	uint8_t* out, int out_stride, size_t out_size
+) {
+	WebPDecoderConfig config;
+	if(!WebPInitDecoderConfig(&config)) {
+		return -1;
+	}
+
+	config.options.use_cropping = 1;
+	config.options.crop_left = crop_x;
+	config.options.crop_top = crop_y;
+	config.options.crop_width = crop_width;
+	config.options.crop_height = crop_height;
+	if (scaled_width != crop_width || scaled_height != crop_height) {
+		config.options.use_scaling = 1;
+		config.options.scaled_width = scaled_width;
+		config.options.scaled_height = scaled_height;
+	}
+	config.options.dithering_strength = dithering_strength;
+	config.options.alpha_dithering_strength = alpha_dithering_strength;
+	config.output.colorspace = MODE_RGBA;
+	config.output.u.RGBA.rgba = out;
+	config.output.u.RGBA.stride = out_stride;
+	config.output.u.RGBA.size = out_size;
+	config.output.is_external_memory = 1;
+
+	return WebPDecode(data, data_size, &config);
+}
+
+int webpDecodeYUVAInto(const uint8_t* data, size_t data_size,
+	uint8_t* y, int y_stride, size_t y_size,
+	uint8_t* u, uint8_t* v, int uv_stride, size_t uv_size,
+	uint8_t* a, int a_stride, size_t a_size
+) {
+	WebPDecoderConfig config;
+	if(!WebPInitDecoderConfig(&config)) {
+		return -1;
+	}
+
+	config.output.colorspace = (a != NULL)? MODE_YUVA: MODE_YUV;
+	config.output.u.YUVA.y = y;
+	config.output.u.YUVA.y_stride = y_stride;
+	config.output.u.YUVA.y_size = y_size;
+	config.output.u.YUVA.u = u;
+	config.output.u.YUVA.u_stride = uv_stride;
+	config.output.u.YUVA.u_size = uv_size;
+	config.output.u.YUVA.v = v;
+	config.output.u.YUVA.v_stride = uv_stride;
+	config.output.u.YUVA.v_size = uv_size;
+	config.output.u.YUVA.a = a;
+	config.output.u.YUVA.a_stride = a_stride;
+	config.output.u.YUVA.a_size = a_size;
+	config.output.is_external_memory = 1;
+
+	return WebPDecode(data, data_size, &config);
+}
+
 uint8_t* webpEncodeGray(
 	const uint8_t* gray, int width, int height, int stride, float quality_factor,
 	size_t* output_size
@@ -213,6 +380,233 @@ uint8_t* webpEncodeRGBA(
 }
 
 
+// webpWriterBridge passes the encoded chunks to the Go writer of the handle
+// in custom_ptr.
+static int webpWriterBridge(const uint8_t* data, size_t data_size, const WebPPicture* pic) {
+	return webpGoWrite((uint8_t*)data, data_size, (uintptr_t)pic->custom_ptr);
+}
+
+// webpEncodePictureKeep crops, rescales and encodes pic to the Go writer,
+// pic is not freed.
+static int webpEncodePictureKeep(WebPPicture* pic, const webpEncoderOptions* opt, uintptr_t writer) {
+	WebPConfig config;
+	int ok;
+
+	if (!WebPConfigPreset(&config, (WebPPreset)opt->preset, opt->quality)) {
+		return 0;
+	}
+	config.lossless = opt->lossless;
+	config.exact = opt->exact;
+	config.near_lossless = opt->near_lossless;
+	config.use_sharp_yuv = opt->use_sharp_yuv;
+	config.use_delta_palette = opt->use_delta_palette;
+
+	ok = !opt->lossless || WebPConfigLosslessPreset(&config, opt->lossless_level);
+	ok = ok && WebPValidateConfig(&config);
+	if (ok && opt->crop_width > 0 && opt->crop_height > 0) {
+		// the YUV420 pictures are cropped at even offsets
+		if (!pic->use_argb && ((opt->crop_x | opt->crop_y) & 1)) {
+			ok = WebPPictureYUVAToARGB(pic);
+		}
+		ok = ok && WebPPictureCrop(pic, opt->crop_x, opt->crop_y, opt->crop_width, opt->crop_height);
+	}
+	if (ok && (opt->resize_width > 0 || opt->resize_height > 0)) {
+		ok = WebPPictureRescale(pic, opt->resize_width, opt->resize_height);
+	}
+	if (ok && opt->exact_alpha && WebPPictureHasTransparency(pic)) {
+		// the near-lossless preprocessing changes all the channels
+		config.near_lossless = 100;
+	}
+	if (ok) {
+		pic->writer = webpWriterBridge;
+		pic->custom_ptr = (void*)writer;
+		ok = WebPEncode(&config, pic);
+	}
+	return ok;
+}
+
+// webpEncodePicture is webpEncodePictureKeep, but pic is freed.
+static int webpEncodePicture(WebPPicture* pic, const webpEncoderOptions* opt, uintptr_t writer) {
+	int ok = webpEncodePictureKeep(pic, opt, writer);
+	WebPPictureFree(pic);
+	return ok;
+}
+
+// webpUseARGB reports whether the pictures imported from RGB are ARGB: as
+// the simple encoding API, but the odd crop offsets need ARGB, and the sharp
+// YUV conversion is done by the encoder.
+static int webpUseARGB(const webpEncoderOptions* opt) {
+	return opt->lossless || opt->use_sharp_yuv ||
+		(opt->crop_width > 0 && ((opt->crop_x | opt->crop_y) & 1));
+}
+
+int webpEncodePix(
+	const uint8_t* pix, int channels, int width, int height, int stride,
+	const webpEncoderOptions* opt,
+	uintptr_t writer
+) {
+	WebPPicture pic;
+	uint8_t* rgb = NULL;
+	int x, y, ok;
+
+	if (!WebPPictureInit(&pic)) {
+		return 0;
+	}
+
+	pic.use_argb = webpUseARGB(opt);
+	pic.width = width;
+	pic.height = height;
+
+	switch (channels) {
+	case 1:
+		if((rgb = (uint8_t*)malloc(width*height*3)) == NULL) {
+			return 0;
+		}
+		for(y = 0; y < height; ++y) {
+			const uint8_t* src = pix + y*stride;
+			uint8_t* dst = rgb + y*width*3;
+			for(x = 0; x < width; ++x) {
+				uint8_t v = *src++;
+				*dst++ = v;
+				*dst++ = v;
+				*dst++ = v;
+			}
+		}
+		ok = WebPPictureImportRGB(&pic, rgb, width*3);
+		free(rgb);
+		break;
+	case 3:
+		ok = WebPPictureImportRGB(&pic, pix, stride);
+		break;
+	case 4:
+		ok = WebPPictureImportRGBA(&pic, pix, stride);
+		break;
+	default:
+		ok = 0;
+	}
+	if (!ok) {
+		WebPPictureFree(&pic);
+		return 0;
+	}
+
+	return webpEncodePicture(&pic, opt, writer);
+}
+
+int webpEncodeYUVA(
+	const uint8_t* y, const uint8_t* u, const uint8_t* v, const uint8_t* a,
+	int width, int height, int y_stride, int uv_stride, int a_stride,
+	const webpEncoderOptions* opt,
+	uintptr_t writer
+) {
+	WebPPicture pic;
+
+	if (!WebPPictureInit(&pic)) {
+		return 0;
+	}
+
+	// the planes are only read by the encoder, they are not owned by pic.
+	pic.use_argb = 0;
+	pic.colorspace = (a != NULL)? WEBP_YUV420A: WEBP_YUV420;
+	pic.width = width;
+	pic.height = height;
+	pic.y = (uint8_t*)y;
+	pic.u = (uint8_t*)u;
+	pic.v = (uint8_t*)v;
+	pic.y_stride = y_stride;
+	pic.uv_stride = uv_stride;
+	pic.a = (uint8_t*)a;
+	pic.a_stride = a_stride;
+
+	return webpEncodePicture(&pic, opt, writer);
+}
+
+
+WebPPicture* webpNewStripPicture(int width, int height, const webpEncoderOptions* opt) {
+	WebPPicture* pic;
+
+	if((pic = (WebPPicture*)malloc(sizeof(WebPPicture))) == NULL) {
+		return NULL;
+	}
+	if (!WebPPictureInit(pic)) {
+		free(pic);
+		return NULL;
+	}
+
+	// the alpha plane is allocated up front, the encoder skips it if all
+	// the rows are opaque.
+	pic->use_argb = webpUseARGB(opt);
+	pic->colorspace = WEBP_YUV420A;
+	pic->width = width;
+	pic->height = height;
+	if (!WebPPictureAlloc(pic)) {
+		free(pic);
+		return NULL;
+	}
+	return pic;
+}
+
+int webpStripPictureImport(WebPPicture* pic, const uint8_t* rgba, int stride, int y, int rows) {
+	WebPPicture strip;
+	int i, x, ok;
+
+	if (y < 0 || rows <= 0 || y+rows > pic->height) {
+		return 0;
+	}
+	if (pic->use_argb) {
+		for(i = 0; i < rows; ++i) {
+			const uint8_t* src = rgba + i*stride;
+			uint32_t* dst = pic->argb + (y+i)*pic->argb_stride;
+			for(x = 0; x < pic->width; ++x, src += 4) {
+				dst[x] = ((uint32_t)src[3] << 24) | ((uint32_t)src[0] << 16) | ((uint32_t)src[1] << 8) | src[2];
+			}
+		}
+		return 1;
+	}
+
+	// the chroma is subsampled by blocks of 2x2 pixels, so the strips are
+	// converted from an even row, as the whole picture would be.
+	if ((y & 1) || ((rows & 1) && y+rows != pic->height)) {
+		return 0;
+	}
+	if (!WebPPictureInit(&strip)) {
+		return 0;
+	}
+	strip.use_argb = 0;
+	strip.width = pic->width;
+	strip.height = rows;
+	if ((ok = WebPPictureImportRGBA(&strip, rgba, stride))) {
+		const int uv_width = (pic->width+1)/2;
+		for(i = 0; i < rows; ++i) {
+			memcpy(pic->y + (y+i)*pic->y_stride, strip.y + i*strip.y_stride, pic->width);
+			if (strip.a != NULL) {
+				memcpy(pic->a + (y+i)*pic->a_stride, strip.a + i*strip.a_stride, pic->width);
+			} else {
+				memset(pic->a + (y+i)*pic->a_stride, 0xff, pic->width);
+			}
+		}
+		for(i = 0; i < (rows+1)/2; ++i) {
+			memcpy(pic->u + (y/2+i)*pic->uv_stride, strip.u + i*strip.uv_stride, uv_width);
+			memcpy(pic->v + (y/2+i)*pic->uv_stride, strip.v + i*strip.uv_stride, uv_width);
+		}
+	}
+	WebPPictureFree(&strip);
+	return ok;
+}
+
+int webpStripPictureEncode(WebPPicture* pic, const webpEncoderOptions* opt, uintptr_t writer) {
+	return webpEncodePictureKeep(pic, opt, writer);
+}
+
+int webpStripPictureUseARGB(const WebPPicture* pic) {
+	return pic->use_argb;
+}
+
+void webpFreeStripPicture(WebPPicture* pic) {
+	WebPPictureFree(pic);
+	free(pic);
+}
+
+
 uint8_t* webpEncodeLosslessGray(
 	const uint8_t* gray, int width, int height, int stride,
 	size_t* output_size
@@ -287,6 +681,30 @@ uint8_t* webpEncodeLosslessRGBA(
 	return wrt.mem;
 }
 
+int webpPictureDistortion(
+	const uint8_t* ref, int ref_stride, const uint8_t* dist, int dist_stride,
+	int width, int height, int metric,
+	float result[5]
+) {
+	WebPPicture src, pic;
+	int ok;
+
+	if (!WebPPictureInit(&src) || !WebPPictureInit(&pic)) {
+		return 0;
+	}
+	src.use_argb = pic.use_argb = 1;
+	src.width = pic.width = width;
+	src.height = pic.height = height;
+
+	ok = WebPPictureImportRGBA(&src, ref, ref_stride) &&
+		WebPPictureImportRGBA(&pic, dist, dist_stride) &&
+		WebPPictureDistortion(&pic, &src, metric, result);
+
+	WebPPictureFree(&src);
+	WebPPictureFree(&pic);
+	return ok;
+}
+
 char* webpGetEXIF(const uint8_t* data, size_t data_size, size_t* metadata_size) {
 	char* metadata = NULL;
 	WebPData webp_data = {data, data_size};
@@ -434,6 +852,92 @@ uint8_t* webpDelXMP(const uint8_t* data, size_t data_size, size_t* new_data_size
 	return (uint8_t*)(output_data.bytes);
 }
 
+uint8_t* webpSetLoopCount(const uint8_t* data, size_t data_size, int loop_count, size_t* new_data_size) {
+	WebPData image = {data, data_size};
+	WebPData output_data = {NULL, 0};
+	WebPMuxAnimParams params;
+	WebPMux* mux = WebPMuxCreate(&image, 0);
+	if(WebPMuxGetAnimationParams(mux, &params) == WEBP_MUX_OK) {
+		params.loop_count = loop_count;
+		if(WebPMuxSetAnimationParams(mux, &params) == WEBP_MUX_OK) {
+			WebPMuxAssemble(mux, &output_data);
+		}
+	}
+	WebPMuxDelete(mux);
+	*new_data_size = output_data.size;
+	return (uint8_t*)(output_data.bytes);
+}
+
+uint8_t* webpGetFrame(
+	const uint8_t* data, size_t data_size, int n,
+	int* x_offset, int* y_offset, int* duration, int* dispose, int* blend,
+	size_t* frame_size
+) {
+	WebPData image = {data, data_size};
+	WebPMuxFrameInfo frame;
+	uint8_t* bitstream = NULL;
+	WebPMux* mux = WebPMuxCreate(&image, 0);
+	*frame_size = 0;
+	if(WebPMuxGetFrame(mux, n, &frame) == WEBP_MUX_OK) {
+		// the bitstream is a still WebP file, with the ALPH chunk
+		bitstream = (uint8_t*)(frame.bitstream.bytes);
+		*frame_size = frame.bitstream.size;
+		*x_offset = frame.x_offset;
+		*y_offset = frame.y_offset;
+		*duration = frame.duration;
+		*dispose = frame.dispose_method == WEBP_MUX_DISPOSE_BACKGROUND;
+		*blend = frame.blend_method == WEBP_MUX_BLEND;
+	}
+	WebPMuxDelete(mux);
+	return bitstream;
+}
+
+WebPMux* webpNewMux(void) {
+	return WebPMuxNew();
+}
+
+int webpMuxPushFrame(
+	WebPMux* mux, const uint8_t* data, size_t data_size,
+	int x_offset, int y_offset, int duration, int dispose, int blend
+) {
+	WebPMuxFrameInfo frame;
+	memset(&frame, 0, sizeof(frame));
+	frame.bitstream.bytes = data;
+	frame.bitstream.size = data_size;
+	frame.id = WEBP_CHUNK_ANMF;
+	frame.x_offset = x_offset;
+	frame.y_offset = y_offset;
+	frame.duration = duration;
+	frame.dispose_method = dispose ? WEBP_MUX_DISPOSE_BACKGROUND : WEBP_MUX_DISPOSE_NONE;
+	frame.blend_method = blend ? WEBP_MUX_BLEND : WEBP_MUX_NO_BLEND;
+	return WebPMuxPushFrame(mux, &frame, 1) == WEBP_MUX_OK;
+}
+
+uint8_t* webpMuxAssembleAnimation(
+	WebPMux* mux, int canvas_width, int canvas_height, int loop_count, uint32_t bgcolor,
+	size_t* output_size
+) {
+	WebPData output_data = {NULL, 0};
+	WebPMuxAnimParams params;
+	params.bgcolor = bgcolor;
+	params.loop_count = loop_count;
+	if(canvas_width > 0 && canvas_height > 0) {
+		if(WebPMuxSetCanvasSize(mux, canvas_width, canvas_height) != WEBP_MUX_OK) {
+			*output_size = 0;
+			return NULL;
+		}
+	}
+	if(WebPMuxSetAnimationParams(mux, &params) == WEBP_MUX_OK) {
+		WebPMuxAssemble(mux, &output_data);
+	}
+	*output_size = output_data.size;
+	return (uint8_t*)(output_data.bytes);
+}
+
+void webpMuxDelete(WebPMux* mux) {
+	WebPMuxDelete(mux);
+}
+
 void* webpMalloc(size_t size) {
 	return malloc(size);
 }
@@ -0,0 +1,330 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+
+package webp
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// QuantizeOptions are the parameters of the palette quantization of the
+// lossless encoder.
+type QuantizeOptions struct {
+	MaxColors int     // 2 ~ 256, 256 if 0
+	Dither    float32 // 0 (none) ~ 1 (full Floyd-Steinberg diffusion of the color error)
+
+	// MinPSNR is the quality floor, in dB: the image is encoded unchanged
+	// if its quantization is below it. 0 always keeps the quantization.
+	MinPSNR float32
+}
+
+const quantizeIterations = 3 // k-means refinements of the median cut palette
+
+// qcolor is an alpha-premultiplied color, in 8-bit units, as the distances
+// between colors are measured.
+type qcolor [4]float64
+
+func (c *qcolor) distance(p *qcolor) float64 {
+	d0, d1, d2, d3 := c[0]-p[0], c[1]-p[1], c[2]-p[2], c[3]-p[3]
+	return d0*d0 + d1*d1 + d2*d2 + d3*d3
+}
+
+// premultiplied returns the qcolor of the non-premultiplied color c.
+func premultiplied(c [4]uint8) qcolor {
+	a := float64(c[3])
+	return qcolor{float64(c[0]) * a / 255, float64(c[1]) * a / 255, float64(c[2]) * a / 255, a}
+}
+
+// straight returns the non-premultiplied color of c.
+func (c *qcolor) straight() (s [4]uint8) {
+	a := math.Round(math.Max(0, math.Min(255, c[3])))
+	if a == 0 {
+		return
+	}
+	for i := 0; i < 3; i++ {
+		s[i] = uint8(math.Round(math.Max(0, math.Min(255, c[i]*255/a))))
+	}
+	s[3] = uint8(a)
+	return
+}
+
+type qentry struct {
+	Color  qcolor
+	Weight float64
+}
+
+// quantizeImage reduces the colors of m with a median cut palette refined
+// by k-means, with an alpha-aware distance. It returns false if m already
+// has few enough colors, or if the quantization is below the quality floor.
+// The fully transparent pixels become transparent black.
+func quantizeImage(m image.Image, q *QuantizeOptions) (*image.NRGBA, bool) {
+	maxColors := q.MaxColors
+	if maxColors <= 0 || maxColors > 256 {
+		maxColors = 256
+	}
+	if maxColors < 2 {
+		maxColors = 2
+	}
+	src := toNRGBAImage(m)
+	b := src.Bounds()
+	if b.Empty() {
+		return nil, false
+	}
+
+	// the histogram of the colors, with a single transparent color
+	histogram := make(map[[4]uint8]int)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		pix := src.Pix[src.PixOffset(b.Min.X, y):][:4*b.Dx()]
+		for i := 0; i < len(pix); i += 4 {
+			c := [4]uint8{pix[i+0], pix[i+1], pix[i+2], pix[i+3]}
+			if c[3] == 0 {
+				c = [4]uint8{}
+			}
+			histogram[c]++
+		}
+	}
+	if len(histogram) <= maxColors {
+		return nil, false
+	}
+	// the transparent color keeps its own entry
+	_, transparent := histogram[[4]uint8{}]
+	if transparent {
+		delete(histogram, [4]uint8{})
+		maxColors--
+	}
+	entries := make([]qentry, 0, len(histogram))
+	for c, n := range histogram {
+		entries = append(entries, qentry{premultiplied(c), float64(n)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		for k := 0; k < 4; k++ {
+			if entries[i].Color[k] != entries[j].Color[k] {
+				return entries[i].Color[k] < entries[j].Color[k]
+			}
+		}
+		return false
+	})
+
+	palette := medianCut(entries, maxColors)
+	for i := 0; i < quantizeIterations; i++ {
+		palette = kmeans(entries, palette)
+	}
+	if transparent {
+		palette = append(palette, qcolor{})
+	}
+
+	dst := remapPalette(src, palette, float64(q.Dither))
+	if q.MinPSNR > 0 && quantizePSNR(src, dst) < float64(q.MinPSNR) {
+		return nil, false
+	}
+	return dst, true
+}
+
+// medianCut splits the boxes of colors of the largest weighted range at
+// their weighted median, until there are n boxes, and returns their means.
+func medianCut(entries []qentry, n int) []qcolor {
+	type box struct {
+		Entries []qentry
+		Channel int
+		Score   float64
+	}
+	newBox := func(entries []qentry) box {
+		var lo, hi qcolor
+		var weight float64
+		for k := 0; k < 4; k++ {
+			lo[k], hi[k] = math.Inf(1), math.Inf(-1)
+		}
+		for _, e := range entries {
+			weight += e.Weight
+			for k := 0; k < 4; k++ {
+				lo[k] = math.Min(lo[k], e.Color[k])
+				hi[k] = math.Max(hi[k], e.Color[k])
+			}
+		}
+		bx := box{Entries: entries}
+		for k := 0; k < 4; k++ {
+			if r := hi[k] - lo[k]; r*math.Sqrt(weight) > bx.Score {
+				bx.Channel, bx.Score = k, r*math.Sqrt(weight)
+			}
+		}
+		if len(entries) < 2 {
+			bx.Score = 0
+		}
+		return bx
+	}
+
+	boxes := []box{newBox(entries)}
+	for len(boxes) < n {
+		k := 0
+		for i := range boxes {
+			if boxes[i].Score > boxes[k].Score {
+				k = i
+			}
+		}
+		bx := boxes[k]
+		if bx.Score == 0 {
+			break
+		}
+
+		c := bx.Channel
+		sort.SliceStable(bx.Entries, func(i, j int) bool {
+			return bx.Entries[i].Color[c] < bx.Entries[j].Color[c]
+		})
+		var total, sum float64
+		for _, e := range bx.Entries {
+			total += e.Weight
+		}
+		split := len(bx.Entries) - 1
+		for i, e := range bx.Entries[:split] {
+			if sum += e.Weight; sum >= total/2 {
+				split = i + 1
+				break
+			}
+		}
+		boxes[k] = newBox(bx.Entries[:split])
+		boxes = append(boxes, newBox(bx.Entries[split:]))
+	}
+
+	palette := make([]qcolor, len(boxes))
+	for i, bx := range boxes {
+		palette[i] = mean(bx.Entries)
+	}
+	return palette
+}
+
+func mean(entries []qentry) (c qcolor) {
+	var weight float64
+	for _, e := range entries {
+		weight += e.Weight
+		for k := 0; k < 4; k++ {
+			c[k] += e.Color[k] * e.Weight
+		}
+	}
+	for k := 0; k < 4; k++ {
+		c[k] /= weight
+	}
+	return
+}
+
+// kmeans assigns the colors to their nearest palette entry, and returns
+// the means of the clusters.
+func kmeans(entries []qentry, palette []qcolor) []qcolor {
+	clusters := make([][]qentry, len(palette))
+	for _, e := range entries {
+		k := nearest(palette, &e.Color)
+		clusters[k] = append(clusters[k], e)
+	}
+	next := make([]qcolor, 0, len(palette))
+	for _, cluster := range clusters {
+		if len(cluster) > 0 {
+			next = append(next, mean(cluster))
+		}
+	}
+	return next
+}
+
+func nearest(palette []qcolor, c *qcolor) (k int) {
+	best := math.Inf(1)
+	for i := range palette {
+		if d := c.distance(&palette[i]); d < best {
+			k, best = i, d
+		}
+	}
+	return
+}
+
+// remapPalette maps the pixels of src to the palette, diffusing the
+// quantization error scaled by dither.
+func remapPalette(src *image.NRGBA, palette []qcolor, dither float64) *image.NRGBA {
+	b := src.Bounds()
+	w := b.Dx()
+	dst := image.NewNRGBA(b)
+
+	// the palette as it is encoded, and the nearest entry of the exact
+	// colors
+	colors := make([][4]uint8, len(palette))
+	for i := range palette {
+		colors[i] = palette[i].straight()
+		palette[i] = premultiplied(colors[i])
+	}
+	cache := make(map[[4]uint8]int)
+
+	dither = math.Max(0, math.Min(1, dither))
+	var cur, next []qcolor
+	if dither > 0 {
+		cur, next = make([]qcolor, w+2), make([]qcolor, w+2)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		sp := src.Pix[src.PixOffset(b.Min.X, y):][:4*w]
+		dp := dst.Pix[dst.PixOffset(b.Min.X, y):][:4*w]
+		for x := 0; x < w; x++ {
+			s := [4]uint8{sp[4*x+0], sp[4*x+1], sp[4*x+2], sp[4*x+3]}
+			if s[3] == 0 {
+				s = [4]uint8{}
+			}
+
+			// the transparent pixels are not dithered, they keep the
+			// background clean
+			var k int
+			var c qcolor
+			if dither > 0 && s[3] != 0 {
+				c = premultiplied(s)
+				e := &cur[x+1]
+				for i := 0; i < 4; i++ {
+					c[i] = math.Max(0, math.Min(255, c[i]+e[i]))
+				}
+				k = nearest(palette, &c)
+			} else if v, ok := cache[s]; ok {
+				k = v
+			} else {
+				c = premultiplied(s)
+				k = nearest(palette, &c)
+				cache[s] = k
+			}
+			copy(dp[4*x:][:4], colors[k][:])
+
+			// the color error is diffused, the alpha edges stay sharp
+			if dither > 0 && s[3] != 0 {
+				for i := 0; i < 3; i++ {
+					diff := (c[i] - palette[k][i]) * dither
+					cur[x+2][i] += diff * 7 / 16
+					next[x+0][i] += diff * 3 / 16
+					next[x+1][i] += diff * 5 / 16
+					next[x+2][i] += diff * 1 / 16
+				}
+			}
+		}
+		if dither > 0 {
+			cur, next = next, cur
+			for i := range next {
+				next[i] = qcolor{}
+			}
+		}
+	}
+	return dst
+}
+
+// quantizePSNR returns the PSNR of the quantized image, on the
+// premultiplied colors as Distortion.
+func quantizePSNR(src, dst *image.NRGBA) float64 {
+	b := src.Bounds()
+	var sse float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		sp := src.Pix[src.PixOffset(b.Min.X, y):][:4*b.Dx()]
+		dp := dst.Pix[dst.PixOffset(b.Min.X, y):][:4*b.Dx()]
+		for i := 0; i < len(sp); i += 4 {
+			c0 := premultiplied([4]uint8{sp[i+0], sp[i+1], sp[i+2], sp[i+3]})
+			c1 := premultiplied([4]uint8{dp[i+0], dp[i+1], dp[i+2], dp[i+3]})
+			sse += c0.distance(&c1)
+		}
+	}
+	if sse == 0 {
+		return 99
+	}
+	return 10 * math.Log10(255*255*4*float64(b.Dx()*b.Dy())/sse)
+}
@@ -29,14 +29,14 @@ func LoadConfig(name string) (config image.Config, err error) {
 		return
 	}
 	header = header[:n]
-	width, height, _, err := GetInfo(header)
+	width, height, hasAlpha, err := GetInfo(header)
 	if err != nil {
 		return
 	}
 
 	config.Width = width
 	config.Height = height
-	config.ColorModel = color.RGBAModel
+	config.ColorModel = colorModelOf(hasAlpha)
 	return
 }
 
@@ -59,7 +59,7 @@ func Load(name string) (m image.Image, err error) {
 	if _, err = f.Read(data); err != nil {
 		return nil, err
 	}
-	if m, err = DecodeRGBA(data); err != nil {
+	if m, err = decode(data, nil); err != nil {
 		return
 	}
 	return
@@ -74,28 +74,158 @@ func DecodeConfig(r io.Reader) (config image.Config, err error) {
 		return
 	}
 	header, err = header[:n], nil
-	width, height, _, err := GetInfo(header)
+	width, height, hasAlpha, err := GetInfo(header)
 	if err != nil {
 		return
 	}
 	config.Width = width
 	config.Height = height
-	config.ColorModel = color.RGBAModel
+	config.ColorModel = colorModelOf(hasAlpha)
 	return
 }
 
+// colorModelOf returns the color model of the image returned by Decode.
+func colorModelOf(hasAlpha bool) color.Model {
+	if hasAlpha {
+		return color.NRGBAModel
+	}
+	return color.RGBAModel
+}
+
+// DecoderOptions are the decoding parameters.
+type DecoderOptions struct {
+	// Decode lossy images to their native *image.YCbCr (or *image.NYCbCrA)
+	// form instead of converting them to RGBA.
+	PreferYCbCr bool
+
+	// DitheringStrength (0 ~ 100) dithers the colors of the lossy images,
+	// which masks the banding of the smooth gradients, as dwebp -dither.
+	// AlphaDitheringStrength (0 ~ 100) smooths the alpha planes of the
+	// lossy images which were quantized by the encoder, as dwebp
+	// -alpha_dither. They do not apply to the YCbCr images.
+	DitheringStrength      int
+	AlphaDitheringStrength int
+}
+
+func (opt *DecoderOptions) validDithering() error {
+	if opt.DitheringStrength < 0 || opt.DitheringStrength > 100 || opt.AlphaDitheringStrength < 0 || opt.AlphaDitheringStrength > 100 {
+		return errors.New("webp: Decode, invalid dithering strength")
+	}
+	return nil
+}
+
 // Decode reads a WEBP image from r and returns it as an image.Image.
+// Images with alpha are returned as *image.NRGBA, matching the straight
+// alpha stored by WebP, opaque images as *image.RGBA.
 func Decode(r io.Reader) (m image.Image, err error) {
+	return DecodeWithOptions(r, nil)
+}
+
+// DecodeWithOptions reads a WEBP image from r with the given options and
+// returns it as an image.Image.
+func DecodeWithOptions(r io.Reader, opt *DecoderOptions) (m image.Image, err error) {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return
 	}
-	if m, err = DecodeRGBA(data); err != nil {
+	return decode(data, opt)
+}
+
+func decode(data []byte, opt *DecoderOptions) (m image.Image, err error) {
+	if opt != nil && opt.PreferYCbCr {
+		features, err := Features(data)
+		if err != nil {
+			return nil, err
+		}
+		if features.Format == FormatLossy && !features.HasAnimation {
+			return DecodeYCbCr(data)
+		}
+	}
+	if opt != nil && (opt.DitheringStrength != 0 || opt.AlphaDitheringStrength != 0) {
+		return decodeDithered(data, opt)
+	}
+	_, _, hasAlpha, err := webpGetInfo(data)
+	if err != nil {
 		return
 	}
-	return
+	if hasAlpha {
+		return DecodeNRGBA(data)
+	}
+	return DecodeRGBA(data)
 }
 
+// decodeDithered decodes the WebP image in data with the dithering of opt,
+// by a region decoder of the whole image.
+func decodeDithered(data []byte, opt *DecoderOptions) (image.Image, error) {
+	p, err := NewRegionDecoderWithOptions(data, opt)
+	if err != nil {
+		return nil, err
+	}
+	m, err := p.DecodeRegionToSize(p.Bounds(), p.features.Width, p.features.Height)
+	if err != nil {
+		return nil, err
+	}
+	if p.features.HasAlpha {
+		return m, nil
+	}
+	return &image.RGBA{Pix: m.Pix, Stride: m.Stride, Rect: m.Rect}, nil
+}
+
+// DecodeRows decodes the WEBP image read from r with the incremental
+// decoder of libwebp, and calls fn with each row as soon as it is decoded,
+// from the top. The row is in 8-bit RGBA, not premultiplied, len(row) is 4
+// times the width, and it is only valid during the call. An error returned
+// by fn aborts the decoding, and is returned.
+//
+// The rows are not held in Go memory, but libwebp holds its output frame
+// and the compressed data read so far in C memory until the image is
+// decoded. The animated images are not supported.
+func DecodeRows(r io.Reader, fn func(y int, row []byte) error) (err error) {
+	idec, err := webpINewRGBA()
+	if err != nil {
+		return
+	}
+	defer webpIDelete(idec)
+
+	var row []byte
+	var next int // the next row for fn
+	emit := func() error {
+		pix, lastY, width, _, stride := webpIDecGetRGBA(idec)
+		for ; next < lastY; next++ {
+			if row == nil {
+				row = make([]byte, 4*width)
+			}
+			copy(row, pix[next*stride:][:4*width])
+			if err := fn(next, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	buf := make([]byte, decodeRowsBufferSize)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			done, err := webpIAppend(idec, buf[:n])
+			if err != nil {
+				return err
+			}
+			if err = emit(); err != nil || done {
+				return err
+			}
+		}
+		if rerr == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+const decodeRowsBufferSize = 32 << 10 // size of the reads of DecodeRows
+
 func init() {
 	image.RegisterFormat("webp", "RIFF????WEBPVP8", Decode, DecodeConfig)
 }
@@ -5,9 +5,15 @@
 package webp
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
+	"image/color"
 	_ "image/png"
+	"io"
 	"os"
+	"runtime"
 	"testing"
 )
 
@@ -69,3 +75,199 @@ func delta(u0, u1 uint32) int64 {
 	}
 	return d
 }
+
+func TestDecodeWithOptions(t *testing.T) {
+	for _, v := range []struct {
+		Filename string
+		Options  *DecoderOptions
+		Expected string
+	}{
+		{"video-001.lossy.webp", nil, "*image.RGBA"},
+		{"video-001.lossy.webp", &DecoderOptions{PreferYCbCr: true}, "*image.YCbCr"},
+		{"yellow_rose.lossy-with-alpha.webp", &DecoderOptions{PreferYCbCr: true}, "*image.NYCbCrA"},
+		{"1_webp_ll.webp", nil, "*image.NRGBA"},
+		{"1_webp_ll.webp", &DecoderOptions{PreferYCbCr: true}, "*image.NRGBA"},
+		{"video-001.lossy.webp", &DecoderOptions{DitheringStrength: 50}, "*image.RGBA"},
+		{"yellow_rose.lossy-with-alpha.webp", &DecoderOptions{AlphaDitheringStrength: 100}, "*image.NRGBA"},
+	} {
+		f, err := os.Open(testdataDir + v.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := DecodeWithOptions(f, v.Options)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", v.Filename, err)
+		}
+		if got := fmt.Sprintf("%T", m); got != v.Expected {
+			t.Fatalf("%s: expected = %v, got = %v", v.Filename, v.Expected, got)
+		}
+	}
+}
+
+func TestDecodeWithOptions_dithering(t *testing.T) {
+	// libwebp dithers the flat chroma blocks of the high qualities
+	src := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(64 + x/32), uint8(100 + y/32), 180, 255})
+		}
+	}
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, src, &Options{Quality: 100}))
+	data := buf.Bytes()
+	want, err := DecodeRGBA(data)
+	tAssertNil(t, err)
+
+	// the dithering is small noise over the colors
+	m, err := decode(data, &DecoderOptions{DitheringStrength: 100})
+	tAssertNil(t, err)
+	d := averageDelta(want, m)
+	tAssert(t, d > 0 && d < 4, d)
+	dec, err := NewDecoder(&DecoderOptions{DitheringStrength: 100})
+	tAssertNil(t, err)
+	m, err = dec.Decode(data)
+	tAssertNil(t, err)
+	tAssert(t, averageDelta(want, m) > 0)
+
+	// the lossless images are not dithered
+	data, err = os.ReadFile(testdataDir + "1_webp_ll.webp")
+	tAssertNil(t, err)
+	want2, err := DecodeNRGBA(data)
+	tAssertNil(t, err)
+	m, err = decode(data, &DecoderOptions{DitheringStrength: 100, AlphaDitheringStrength: 100})
+	tAssertNil(t, err)
+	tAssertEQ(t, want2, m)
+
+	_, err = decode(data, &DecoderOptions{DitheringStrength: -1})
+	tAssert(t, err != nil)
+}
+
+// tCountReader counts the bytes read, in reads of at most 1000 bytes.
+type tCountReader struct {
+	R io.Reader
+	N int
+}
+
+func (r *tCountReader) Read(p []byte) (int, error) {
+	if len(p) > 1000 {
+		p = p[:1000]
+	}
+	n, err := r.R.Read(p)
+	r.N += n
+	return n, err
+}
+
+func TestDecodeRows(t *testing.T) {
+	for _, filename := range []string{
+		"video-001.webp",
+		"tux.lossless.webp",
+		"yellow_rose.lossy-with-alpha.webp",
+	} {
+		data, err := os.ReadFile(testdataDir + filename)
+		tAssertNil(t, err, filename)
+		want, err := DecodeNRGBA(data)
+		tAssertNil(t, err, filename)
+
+		r := &tCountReader{R: bytes.NewReader(data)}
+		var rows, firstRead int
+		err = DecodeRows(r, func(y int, row []byte) error {
+			if y == 0 {
+				firstRead = r.N
+			}
+			tAssertEQ(t, rows, y, filename)
+			w := want.Rect.Dx()
+			tAssert(t, bytes.Equal(row, want.Pix[y*want.Stride:][:4*w]), filename, y)
+			rows++
+			return nil
+		})
+		tAssertNil(t, err, filename)
+		tAssertEQ(t, want.Rect.Dy(), rows, filename)
+		tAssert(t, firstRead < len(data), filename, firstRead, len(data))
+	}
+
+	data, err := os.ReadFile(testdataDir + "video-001.webp")
+	tAssertNil(t, err)
+
+	// aborted by fn
+	errStop := errors.New("stop")
+	var rows int
+	err = DecodeRows(bytes.NewReader(data), func(y int, row []byte) error {
+		if rows++; y == 10 {
+			return errStop
+		}
+		return nil
+	})
+	tAssert(t, err == errStop, err)
+	tAssertEQ(t, 11, rows)
+
+	// truncated
+	err = DecodeRows(bytes.NewReader(data[:len(data)/2]), func(y int, row []byte) error { return nil })
+	tAssert(t, err == io.ErrUnexpectedEOF, err)
+}
+
+func TestDecoder(t *testing.T) {
+	for _, opt := range []*DecoderOptions{nil, {PreferYCbCr: true}} {
+		d, err := NewDecoder(opt)
+		tAssertNil(t, err)
+		for _, filename := range []string{
+			"video-001.webp",
+			"tux.lossless.webp",
+			"yellow_rose.lossy-with-alpha.webp",
+		} {
+			data, err := os.ReadFile(testdataDir + filename)
+			tAssertNil(t, err, filename)
+			want, err := decode(data, opt)
+			tAssertNil(t, err, filename)
+
+			for i := 0; i < 3; i++ {
+				m, err := d.Decode(data)
+				tAssertNil(t, err, filename)
+				tAssertEQ(t, want, m, filename, i)
+				d.Release(m)
+			}
+		}
+	}
+
+	d, err := NewDecoder(nil)
+	tAssertNil(t, err)
+	_, err = d.Decode([]byte("RIFF"))
+	tAssert(t, err != nil)
+
+	// the options are validated once
+	for _, opt := range []*DecoderOptions{{DitheringStrength: 500}, {AlphaDitheringStrength: -1}} {
+		_, err = NewDecoder(opt)
+		tAssert(t, err != nil, *opt)
+	}
+}
+
+func TestDecoder_allocs(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "1_webp_ll.webp")
+	tAssertNil(t, err)
+
+	d, err := NewDecoder(nil)
+	tAssertNil(t, err)
+	bytesPerRun := func(fn func()) uint64 {
+		var m0, m1 runtime.MemStats
+		fn() // warm up the pool
+		runtime.ReadMemStats(&m0)
+		for i := 0; i < 10; i++ {
+			fn()
+		}
+		runtime.ReadMemStats(&m1)
+		return (m1.TotalAlloc - m0.TotalAlloc) / 10
+	}
+	direct := bytesPerRun(func() {
+		if _, err := DecodeRGBA(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+	pooled := bytesPerRun(func() {
+		m, err := d.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Release(m)
+	})
+	tAssert(t, pooled < direct/2, pooled, direct)
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+
+package webp
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// RegionDecoder decodes regions of a still WebP image, as the tiles of a
+// large map or scan. Only the features of the image are cached by
+// NewRegionDecoder: each DecodeRegion parses the headers again in libwebp.
+// The data is shared by all the decodes without being copied, it must not
+// be modified while the RegionDecoder is used.
+//
+// A RegionDecoder is safe for concurrent use: each region is decoded by an
+// independent libwebp decoder, which reads only the data of the image.
+type RegionDecoder struct {
+	data     []byte
+	features *ImageFeatures
+	opt      DecoderOptions
+}
+
+// NewRegionDecoder returns a RegionDecoder of the WebP image in data.
+func NewRegionDecoder(data []byte) (*RegionDecoder, error) {
+	return NewRegionDecoderWithOptions(data, nil)
+}
+
+// NewRegionDecoderWithOptions returns a RegionDecoder of the WebP image in
+// data, which decodes the regions with the dithering of opt. PreferYCbCr
+// is ignored.
+func NewRegionDecoderWithOptions(data []byte, opt *DecoderOptions) (*RegionDecoder, error) {
+	features, err := Features(data)
+	if err != nil {
+		return nil, err
+	}
+	if features.HasAnimation {
+		return nil, errors.New("webp: NewRegionDecoder, animated images are not supported")
+	}
+	p := &RegionDecoder{data: data, features: features}
+	if opt != nil {
+		if err = opt.validDithering(); err != nil {
+			return nil, err
+		}
+		p.opt = *opt
+	}
+	return p, nil
+}
+
+// Bounds returns the bounds of the image.
+func (p *RegionDecoder) Bounds() image.Rectangle {
+	return image.Rect(0, 0, p.features.Width, p.features.Height)
+}
+
+// Features returns the features of the image.
+func (p *RegionDecoder) Features() ImageFeatures {
+	return *p.features
+}
+
+// DecodeRegion decodes the rectangle rect of the image, scaled by scale,
+// with the crop and scale options of the libwebp decoder. The result has
+// the size of rect multiplied by scale, rounded (at least 1 x 1), and its
+// bounds start at (0, 0).
+//
+// libwebp crops from even offsets: with an odd offset, the region is
+// decoded from the previous row or column, which is dropped. When scaled,
+// that pixel is resampled with its neighbours.
+func (p *RegionDecoder) DecodeRegion(rect image.Rectangle, scale float64) (m *image.NRGBA, err error) {
+	if !(scale > 0) || math.IsInf(scale, 1) {
+		return nil, errors.New("webp: DecodeRegion, invalid scale")
+	}
+	width := max(1, int(math.Round(float64(rect.Dx())*scale)))
+	height := max(1, int(math.Round(float64(rect.Dy())*scale)))
+	return p.DecodeRegionToSize(rect, width, height)
+}
+
+// DecodeRegionToSize decodes the rectangle rect of the image, scaled to
+// width x height, as DecodeRegion. The aspect ratio of rect is not kept.
+func (p *RegionDecoder) DecodeRegionToSize(rect image.Rectangle, width, height int) (m *image.NRGBA, err error) {
+	if rect.Empty() || !rect.In(p.Bounds()) {
+		return nil, errors.New("webp: DecodeRegion, region is outside of the image")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("webp: DecodeRegion, invalid size")
+	}
+	if width > WEBP_MAX_DIMENSION || height > WEBP_MAX_DIMENSION {
+		return nil, errors.New("webp: DecodeRegion, scaled size exceeds WEBP_MAX_DIMENSION")
+	}
+
+	// the even crop, and its scaled size with the same scale as rect
+	crop := rect
+	crop.Min.X &^= 1
+	crop.Min.Y &^= 1
+	cropWidth, cropHeight := width, height
+	if crop.Min.X != rect.Min.X {
+		cropWidth = int(math.Round(float64(crop.Dx()*width) / float64(rect.Dx())))
+	}
+	if crop.Min.Y != rect.Min.Y {
+		cropHeight = int(math.Round(float64(crop.Dy()*height) / float64(rect.Dy())))
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, cropWidth, cropHeight))
+	err = webpDecodeRegion(p.data, crop, cropWidth, cropHeight, p.opt.DitheringStrength, p.opt.AlphaDitheringStrength, dst.Pix, dst.Stride)
+	if err != nil {
+		return
+	}
+	m = &image.NRGBA{
+		Pix:    dst.Pix[dst.PixOffset(cropWidth-width, cropHeight-height):],
+		Stride: dst.Stride,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+	return
+}
+
+// DecodeRegion decodes the rectangle rect of the WebP image in data,
+// scaled by scale, as RegionDecoder.DecodeRegion. A RegionDecoder saves
+// the probe of the features for each region of the same image, and reuses
+// data without a copy.
+func DecodeRegion(data []byte, rect image.Rectangle, scale float64) (m *image.NRGBA, err error) {
+	p, err := NewRegionDecoder(data)
+	if err != nil {
+		return
+	}
+	return p.DecodeRegion(rect, scale)
+}
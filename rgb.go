@@ -140,18 +140,5 @@ func NewRGBImageFrom(m image.Image) *RGBImage {
 		return m
 	}
 
-	// convert to RGBImage
-	b := m.Bounds()
-	rgb := NewRGBImage(b)
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			pr, pg, pb, _ := m.At(x, y).RGBA()
-			rgb.SetRGB(x, y, [3]uint8{
-				uint8(pr >> 8),
-				uint8(pg >> 8),
-				uint8(pb >> 8),
-			})
-		}
-	}
-	return rgb
+	return convertToRGB(m)
 }
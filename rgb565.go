@@ -0,0 +1,95 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"image"
+	"image/color"
+)
+
+var (
+	_ image.Image = (*RGB565Image)(nil)
+)
+
+// RGB565Image is an in-memory image with 16 bits per pixel, as decoded with
+// MODE_RGB_565: [r4 r3 r2 r1 r0 g5 g4 g3], [g2 g1 g0 b4 b3 b2 b1 b0].
+type RGB565Image struct {
+	XPix    []uint8
+	XStride int
+	XRect   image.Rectangle
+}
+
+func (p *RGB565Image) Bounds() image.Rectangle {
+	return p.XRect
+}
+
+func (p *RGB565Image) ColorModel() color.Model { return color.RGBAModel }
+
+func (p *RGB565Image) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		return color.RGBA{}
+	}
+	i := p.PixOffset(x, y)
+	r := p.XPix[i+0] >> 3
+	g := (p.XPix[i+0]&0x07)<<3 | p.XPix[i+1]>>5
+	b := p.XPix[i+1] & 0x1f
+	return color.RGBA{
+		R: r<<3 | r>>2,
+		G: g<<2 | g>>4,
+		B: b<<3 | b>>2,
+		A: 0xff,
+	}
+}
+
+// PixOffset returns the index of the first element of XPix that corresponds to
+// the pixel at (x, y).
+func (p *RGB565Image) PixOffset(x, y int) int {
+	return (y-p.XRect.Min.Y)*p.XStride + (x-p.XRect.Min.X)*2
+}
+
+func (p *RGB565Image) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	c1 := color.RGBAModel.Convert(c).(color.RGBA)
+	p.XPix[i+0] = c1.R&0xf8 | c1.G>>5
+	p.XPix[i+1] = (c1.G<<3)&0xe0 | c1.B>>3
+	return
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *RGB565Image) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.XRect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the XPix[i:] expression below can panic.
+	if r.Empty() {
+		return &RGB565Image{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &RGB565Image{
+		XPix:    p.XPix[i:],
+		XStride: p.XStride,
+		XRect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *RGB565Image) Opaque() bool {
+	return true
+}
+
+// NewRGB565Image returns a new RGB565Image with the given bounds.
+func NewRGB565Image(r image.Rectangle) *RGB565Image {
+	w, h := r.Dx(), r.Dy()
+	pix := make([]uint8, 2*w*h)
+	return &RGB565Image{
+		XPix:    pix,
+		XStride: 2 * w,
+		XRect:   r,
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"image"
+	"image/color"
+)
+
+var (
+	_ image.Image = (*RGBA4444Image)(nil)
+)
+
+// RGBA4444Image is an in-memory image with 16 bits per pixel, as decoded with
+// MODE_RGBA_4444 (or MODE_rgbA_4444 if XPremultiplied):
+// [r3 r2 r1 r0 g3 g2 g1 g0], [b3 b2 b1 b0 a3 a2 a1 a0].
+type RGBA4444Image struct {
+	XPix           []uint8
+	XStride        int
+	XRect          image.Rectangle
+	XPremultiplied bool // alpha-premultiplied
+}
+
+func (p *RGBA4444Image) Bounds() image.Rectangle {
+	return p.XRect
+}
+
+func (p *RGBA4444Image) ColorModel() color.Model {
+	if p.XPremultiplied {
+		return color.RGBAModel
+	}
+	return color.NRGBAModel
+}
+
+func (p *RGBA4444Image) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		if p.XPremultiplied {
+			return color.RGBA{}
+		}
+		return color.NRGBA{}
+	}
+	i := p.PixOffset(x, y)
+	r := (p.XPix[i+0] >> 4) * 0x11
+	g := (p.XPix[i+0] & 0x0f) * 0x11
+	b := (p.XPix[i+1] >> 4) * 0x11
+	a := (p.XPix[i+1] & 0x0f) * 0x11
+	if p.XPremultiplied {
+		return color.RGBA{R: r, G: g, B: b, A: a}
+	}
+	return color.NRGBA{R: r, G: g, B: b, A: a}
+}
+
+// PixOffset returns the index of the first element of XPix that corresponds to
+// the pixel at (x, y).
+func (p *RGBA4444Image) PixOffset(x, y int) int {
+	return (y-p.XRect.Min.Y)*p.XStride + (x-p.XRect.Min.X)*2
+}
+
+func (p *RGBA4444Image) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.XRect)) {
+		return
+	}
+	var r, g, b, a uint8
+	if p.XPremultiplied {
+		c1 := color.RGBAModel.Convert(c).(color.RGBA)
+		r, g, b, a = c1.R, c1.G, c1.B, c1.A
+	} else {
+		c1 := color.NRGBAModel.Convert(c).(color.NRGBA)
+		r, g, b, a = c1.R, c1.G, c1.B, c1.A
+	}
+	i := p.PixOffset(x, y)
+	p.XPix[i+0] = r&0xf0 | g>>4
+	p.XPix[i+1] = b&0xf0 | a>>4
+	return
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *RGBA4444Image) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.XRect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the XPix[i:] expression below can panic.
+	if r.Empty() {
+		return &RGBA4444Image{XPremultiplied: p.XPremultiplied}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &RGBA4444Image{
+		XPix:           p.XPix[i:],
+		XStride:        p.XStride,
+		XRect:          r,
+		XPremultiplied: p.XPremultiplied,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *RGBA4444Image) Opaque() bool {
+	if p.XRect.Empty() {
+		return true
+	}
+	i0, i1 := 1, p.XRect.Dx()*2
+	for y := p.XRect.Min.Y; y < p.XRect.Max.Y; y++ {
+		for i := i0; i < i1; i += 2 {
+			if p.XPix[i]&0x0f != 0x0f {
+				return false
+			}
+		}
+		i0 += p.XStride
+		i1 += p.XStride
+	}
+	return true
+}
+
+// NewRGBA4444Image returns a new RGBA4444Image with the given bounds.
+func NewRGBA4444Image(r image.Rectangle) *RGBA4444Image {
+	w, h := r.Dx(), r.Dy()
+	pix := make([]uint8, 2*w*h)
+	return &RGBA4444Image{
+		XPix:    pix,
+		XStride: 2 * w,
+		XRect:   r,
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+
+package webp
+
+//#include "webp.h"
+import "C"
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// StripEncoder encodes an image whose rows are written by strips, for the
+// images which are generated a part at a time and do not fit in memory as
+// an image.Image. The rows are imported in the libwebp picture as they are
+// written, and the picture is encoded to w by Close.
+//
+// The picture is allocated in C memory by NewStripEncoder, for its whole
+// size:
+//
+//   - 4 bytes per pixel (ARGB) in Lossless, with UseSharpYUV, or with an
+//     odd Crop offset;
+//   - 2.5 bytes per pixel (YUV 4:2:0 and alpha) otherwise.
+//
+// A Crop or a Resize allocates the picture of their size when encoding.
+// Close then needs the working memory of the encoder, about as much again
+// as the ARGB picture in lossless, and the whole compressed bitstream,
+// which libwebp holds in C memory until the frame is encoded: it is
+// written to w only at the end of the encode.
+//
+// A StripEncoder is not safe for concurrent use. Close must be called to
+// release the picture, also on error.
+type StripEncoder struct {
+	w             io.Writer
+	config        *encoderOptions
+	pic           *C.WebPPicture
+	useARGB       bool
+	width, height int
+	rows          int    // written rows
+	carry         []byte // the last odd row of a YUV strip, imported with the next strip
+}
+
+// NewStripEncoder returns a StripEncoder of a width x height image, with
+// the parameters opt. The size is checked against WEBP_MAX_DIMENSION, and
+// the picture is allocated, before any row is written.
+//
+// Mode ModeAuto and Quantize need the whole image, they are not supported.
+// Crop and Resize are applied by libwebp when encoding.
+func NewStripEncoder(w io.Writer, width, height int, opt *Options) (*StripEncoder, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("webp: NewStripEncoder, invalid image size")
+	}
+	if width > WEBP_MAX_DIMENSION || height > WEBP_MAX_DIMENSION {
+		return nil, errors.New("webp: NewStripEncoder, image size exceeds WEBP_MAX_DIMENSION")
+	}
+	if opt != nil && (opt.Mode == ModeAuto || opt.Quantize != nil) {
+		return nil, errors.New("webp: NewStripEncoder, ModeAuto and Quantize are not supported")
+	}
+	config, err := opt.encoderOptions(image.Rect(0, 0, width, height))
+	if err != nil {
+		return nil, err
+	}
+	pic, useARGB, err := webpNewStripPicture(width, height, config)
+	if err != nil {
+		return nil, err
+	}
+	return &StripEncoder{
+		w:       w,
+		config:  config,
+		pic:     pic,
+		useARGB: useARGB,
+		width:   width,
+		height:  height,
+	}, nil
+}
+
+// WriteRows imports the next rows of the image. pix holds the rows in
+// 8-bit RGBA, not premultiplied (as the Pix of an *image.NRGBA), a row
+// starting every stride bytes. The count of rows is the count of stride
+// in pix, the last row may be truncated to width*4 bytes: the Pix of a
+// sub-image extends to the end of the image, it must be sliced to its rows.
+func (p *StripEncoder) WriteRows(pix []byte, stride int) error {
+	if p.pic == nil {
+		return errors.New("webp: StripEncoder.WriteRows, encoder is closed")
+	}
+	if stride < p.width*4 || len(pix) < p.width*4 {
+		return errors.New("webp: StripEncoder.WriteRows, invalid pix or stride")
+	}
+	n := (len(pix)-p.width*4)/stride + 1
+	if p.rows+n > p.height {
+		return errors.New("webp: StripEncoder.WriteRows, too many rows")
+	}
+
+	y := p.rows
+	if p.carry != nil {
+		// the carried row and the first row of pix
+		y--
+		pair := make([]byte, 2*p.width*4)
+		copy(pair, p.carry)
+		copy(pair[p.width*4:], pix[:p.width*4])
+		if err := webpStripPictureImport(p.pic, pair, p.width, p.width*4, y, 2); err != nil {
+			return err
+		}
+		p.carry = nil
+		p.rows++
+		y += 2
+		if n--; n == 0 {
+			return nil
+		}
+		pix = pix[stride:]
+	}
+
+	last := y+n == p.height
+	if !p.useARGB && n%2 == 1 && !last {
+		// the YUV strips start on an even row
+		p.carry = append([]byte(nil), pix[(n-1)*stride:][:p.width*4]...)
+		p.rows++
+		if n--; n == 0 {
+			return nil
+		}
+	}
+	if err := webpStripPictureImport(p.pic, pix, p.width, stride, y, n); err != nil {
+		return err
+	}
+	p.rows += n
+	return nil
+}
+
+// Rows returns the count of rows written.
+func (p *StripEncoder) Rows() int {
+	return p.rows
+}
+
+// Close encodes the picture to w, and releases it. All the rows must have
+// been written, otherwise nothing is encoded. An error of w aborts the
+// encoding, part of the output may have been written.
+func (p *StripEncoder) Close() (err error) {
+	if p.pic == nil {
+		return errors.New("webp: StripEncoder.Close, encoder is closed")
+	}
+	defer func() {
+		webpFreeStripPicture(p.pic)
+		p.pic, p.carry = nil, nil
+	}()
+
+	if p.rows < p.height {
+		return errors.New("webp: StripEncoder.Close, missing rows")
+	}
+	return webpStripPictureEncode(p.w, p.pic, p.config)
+}
@@ -0,0 +1,443 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package transform
+
+import (
+	"image"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Filter is the resampling filter of Resize.
+type Filter int
+
+const (
+	Box        Filter = iota // area average when downscaling, nearest neighbour when upscaling
+	Bilinear                 // triangle
+	CatmullRom               // cubic, B = 0, C = 0.5
+	Lanczos3                 // windowed sinc, 3 lobes
+	Mitchell                 // cubic, B = C = 1/3
+)
+
+func (f Filter) String() string {
+	switch f {
+	case Box:
+		return "box"
+	case Bilinear:
+		return "bilinear"
+	case CatmullRom:
+		return "catmull-rom"
+	case Lanczos3:
+		return "lanczos3"
+	case Mitchell:
+		return "mitchell"
+	}
+	return "unknown"
+}
+
+// support returns the radius of the filter.
+func (f Filter) support() float64 {
+	switch f {
+	case Box:
+		return 0.5
+	case Bilinear:
+		return 1
+	case Lanczos3:
+		return 3
+	}
+	return 2
+}
+
+func (f Filter) kernel(x float64) float64 {
+	switch f {
+	case Box:
+		if x >= -0.5 && x < 0.5 {
+			return 1
+		}
+		return 0
+	case Bilinear:
+		if x = math.Abs(x); x < 1 {
+			return 1 - x
+		}
+		return 0
+	case CatmullRom:
+		return cubic(x, 0, 0.5)
+	case Lanczos3:
+		if x == 0 {
+			return 1
+		}
+		if x = math.Abs(x); x < 3 {
+			return 3 * math.Sin(math.Pi*x) * math.Sin(math.Pi*x/3) / (math.Pi * math.Pi * x * x)
+		}
+		return 0
+	case Mitchell:
+		return cubic(x, 1.0/3, 1.0/3)
+	}
+	return 0
+}
+
+// cubic is the Mitchell-Netravali family of cubic filters.
+func cubic(x, b, c float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	}
+	return 0
+}
+
+// Options are the resampling parameters.
+type Options struct {
+	Filter Filter
+
+	// Premultiply weights the colors by the alpha while filtering, so that
+	// the colors of the transparent pixels do not bleed into their
+	// neighbours.
+	Premultiply bool
+}
+
+var defaultOptions = Options{
+	Filter:      CatmullRom,
+	Premultiply: true,
+}
+
+// Resize scales m to width x height, in linear light. If one of width and
+// height is 0, it is computed from the other one to keep the aspect ratio.
+// A nil opt uses CatmullRom with alpha premultiplication.
+//
+// The gray and RGB channels are converted from sRGB to linear light before
+// filtering, and back after. The 8 and 16-bit types are resampled, the
+// others (RGB565, CMYK, paletted...) are resampled as *image.NRGBA.
+func Resize(m image.Image, width, height int, opt *Options) image.Image {
+	if opt == nil {
+		opt = &defaultOptions
+	}
+	b := m.Bounds()
+	if width <= 0 && height <= 0 || b.Empty() {
+		return layoutOrNRGBA(m).New(image.Rectangle{})
+	}
+	if width <= 0 {
+		width = int(math.Max(1, math.Round(float64(b.Dx())*float64(height)/float64(b.Dy()))))
+	}
+	if height <= 0 {
+		height = int(math.Max(1, math.Round(float64(b.Dy())*float64(width)/float64(b.Dx()))))
+	}
+
+	l, ok := layoutOf(m)
+	if !ok || l.Channels == 0 {
+		l, _ = layoutOf(toNRGBA(m))
+	}
+	nc := l.Channels
+	premultiply := opt.Premultiply && l.Alpha && nc > 1
+
+	src := readLinear(&l, premultiply)
+	xw := newWeights(b.Dx(), width, opt.Filter)
+	yw := newWeights(b.Dy(), height, opt.Filter)
+
+	// horizontal, then vertical
+	tmp := make([]float32, width*b.Dy()*nc)
+	parallel(b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src[y*b.Dx()*nc:][:b.Dx()*nc]
+			d := tmp[y*width*nc:][:width*nc]
+			for x, w := range xw {
+				for c := 0; c < nc; c++ {
+					var sum float32
+					for i, v := range w.Values {
+						sum += v * s[(w.First+i)*nc+c]
+					}
+					d[x*nc+c] = sum
+				}
+			}
+		}
+	})
+	out := make([]float32, width*height*nc)
+	parallel(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			w := yw[y]
+			d := out[y*width*nc:][:width*nc]
+			for i, v := range w.Values {
+				s := tmp[(w.First+i)*width*nc:][:width*nc]
+				for j := range d {
+					d[j] += v * s[j]
+				}
+			}
+		}
+	})
+
+	dst := l.New(image.Rect(0, 0, width, height))
+	dl, _ := layoutOf(dst)
+	writeLinear(&dl, out, premultiply)
+	return dst
+}
+
+// weights are the contributions of the source samples [First, First +
+// len(Values)) to a destination sample.
+type weights struct {
+	First  int
+	Values []float32
+}
+
+func newWeights(srcLen, dstLen int, f Filter) []weights {
+	scale := float64(srcLen) / float64(dstLen)
+	fscale := math.Max(scale, 1) // widen the filter when downscaling
+	support := f.support() * fscale
+
+	ws := make([]weights, dstLen)
+	for i := range ws {
+		center := (float64(i) + 0.5) * scale
+		lo := int(math.Floor(center - support))
+		hi := int(math.Ceil(center + support))
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > srcLen {
+			hi = srcLen
+		}
+
+		values := make([]float64, 0, hi-lo)
+		var sum float64
+		first := -1
+		for j := lo; j < hi; j++ {
+			v := f.kernel((float64(j) + 0.5 - center) / fscale)
+			if v == 0 && first < 0 {
+				continue
+			}
+			if first < 0 {
+				first = j
+			}
+			values = append(values, v)
+			sum += v
+		}
+		for len(values) > 0 && values[len(values)-1] == 0 {
+			values = values[:len(values)-1]
+		}
+		if first < 0 || sum == 0 {
+			// nearest sample
+			first = int(center)
+			if first >= srcLen {
+				first = srcLen - 1
+			}
+			values, sum = []float64{1}, 1
+		}
+
+		ws[i].First = first
+		ws[i].Values = make([]float32, len(values))
+		for k, v := range values {
+			ws[i].Values[k] = float32(v / sum)
+		}
+	}
+	return ws
+}
+
+// readLinear returns the samples of l in linear light, straight alpha, or
+// premultiplied if premultiply.
+func readLinear(l *layout, premultiply bool) []float32 {
+	w, h := l.Rect.Dx(), l.Rect.Dy()
+	nc := l.Channels
+	out := make([]float32, w*h*nc)
+	toLinear := srgb8ToLinear[:]
+	if l.Depth == 2 {
+		toLinear = srgb16ToLinearTable()
+	}
+	maxValue := float32(int(1)<<(8*l.Depth) - 1)
+
+	parallel(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			line := l.line(y)
+			d := out[y*w*nc:][:w*nc]
+			for x := 0; x < w; x++ {
+				p := line[x*l.Size:][:l.Size]
+				s := d[x*nc:][:nc]
+
+				alpha := float32(1)
+				if l.Alpha {
+					alpha = float32(l.sample(p, nc-1)) / maxValue
+					s[nc-1] = alpha
+				}
+				colors := nc
+				if l.Alpha {
+					colors--
+				}
+				for c := 0; c < colors; c++ {
+					v := l.sample(p, c)
+					switch {
+					case !l.Premultiplied || alpha == 1:
+						s[c] = toLinear[v]
+					case alpha == 0:
+						s[c] = 0
+					default:
+						s[c] = srgbToLinear(math.Min(float64(v)/float64(maxValue)/float64(alpha), 1))
+					}
+					if premultiply {
+						s[c] *= alpha
+					}
+				}
+			}
+		}
+	})
+	return out
+}
+
+// writeLinear stores the samples of readLinear to l.
+func writeLinear(l *layout, in []float32, premultiplied bool) {
+	w, h := l.Rect.Dx(), l.Rect.Dy()
+	nc := l.Channels
+	maxValue := float32(int(1)<<(8*l.Depth) - 1)
+
+	parallel(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			line := l.line(y)
+			s := in[y*w*nc:][:w*nc]
+			for x := 0; x < w; x++ {
+				p := line[x*l.Size:][:l.Size]
+				v := s[x*nc:][:nc]
+
+				alpha := float32(1)
+				if l.Alpha {
+					alpha = clamp01(v[nc-1])
+					l.setSample(p, nc-1, uint32(alpha*maxValue+0.5))
+				}
+				colors := nc
+				if l.Alpha {
+					colors--
+				}
+				for c := 0; c < colors; c++ {
+					lin := v[c]
+					if premultiplied {
+						if alpha == 0 {
+							lin = 0
+						} else {
+							lin /= alpha
+						}
+					}
+					e := linearToSRGB(clamp01(lin))
+					if l.Premultiplied {
+						e *= alpha
+					}
+					l.setSample(p, c, uint32(e*maxValue+0.5))
+				}
+			}
+		}
+	})
+}
+
+func (l *layout) sample(p []byte, c int) uint32 {
+	i := l.Order[c] * l.Depth
+	if l.Depth == 1 {
+		return uint32(p[i])
+	}
+	if l.BigEndian {
+		return uint32(p[i])<<8 | uint32(p[i+1])
+	}
+	return uint32(p[i+1])<<8 | uint32(p[i])
+}
+
+func (l *layout) setSample(p []byte, c int, v uint32) {
+	i := l.Order[c] * l.Depth
+	if l.Depth == 1 {
+		p[i] = uint8(v)
+		return
+	}
+	if l.BigEndian {
+		p[i], p[i+1] = uint8(v>>8), uint8(v)
+		return
+	}
+	p[i], p[i+1] = uint8(v), uint8(v>>8)
+}
+
+func clamp01(v float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+func srgbToLinear(v float64) float32 {
+	if v <= 0.04045 {
+		return float32(v / 12.92)
+	}
+	return float32(math.Pow((v+0.055)/1.055, 2.4))
+}
+
+var srgb8ToLinear = func() (t [256]float32) {
+	for i := range t {
+		t[i] = srgbToLinear(float64(i) / 255)
+	}
+	return
+}()
+
+var (
+	srgb16ToLinearOnce sync.Once
+	srgb16ToLinear     []float32
+)
+
+func srgb16ToLinearTable() []float32 {
+	srgb16ToLinearOnce.Do(func() {
+		srgb16ToLinear = make([]float32, 1<<16)
+		for i := range srgb16ToLinear {
+			srgb16ToLinear[i] = srgbToLinear(float64(i) / 0xffff)
+		}
+	})
+	return srgb16ToLinear
+}
+
+// linearToSRGBTable samples the sRGB encoding on a grid fine enough for the
+// linear interpolation to stay within 1/2 of a 16-bit step.
+const linearToSRGBSteps = 1 << 14
+
+var linearToSRGBTable = func() (t [linearToSRGBSteps + 1]float32) {
+	for i := range t {
+		v := float64(i) / linearToSRGBSteps
+		if v <= 0.0031308 {
+			t[i] = float32(v * 12.92)
+		} else {
+			t[i] = float32(1.055*math.Pow(v, 1/2.4) - 0.055)
+		}
+	}
+	return
+}()
+
+func linearToSRGB(v float32) float32 {
+	f := v * linearToSRGBSteps
+	i := int(f)
+	if i >= linearToSRGBSteps {
+		return linearToSRGBTable[linearToSRGBSteps]
+	}
+	t := f - float32(i)
+	return linearToSRGBTable[i]*(1-t) + linearToSRGBTable[i+1]*t
+}
+
+// parallel splits [0, n) between a bounded number of goroutines.
+func parallel(n int, fn func(lo, hi int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n/16 {
+		workers = n / 16
+	}
+	if workers < 2 {
+		fn(0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	step := (n + workers - 1) / workers
+	for lo := 0; lo < n; lo += step {
+		hi := lo + step
+		if hi > n {
+			hi = n
+		}
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			fn(lo, hi)
+		}(lo, hi)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,275 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package transform provides the resampling, cropping, rotations and flips
+// of the webp image types (RGBImage, RGB48Image, MemPImage, ...) and of the
+// standard library image types.
+//
+// The result has the same type as the source, with its origin at (0, 0).
+// The images without a pixel buffer (image.YCbCr, ...) give an *image.NRGBA.
+package transform
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"reflect"
+
+	"github.com/jageros/webp"
+)
+
+var nativeBigEndian = binary.NativeEndian.Uint16([]byte{0, 1}) == 1
+
+// layout describes the pixel buffer of an image.
+type layout struct {
+	Pix    []byte // starts at the first pixel of the image
+	Stride int
+	Rect   image.Rectangle
+	Size   int // bytes per pixel
+
+	// Channels is 0 for the formats which are only copied (RGB565, CMYK,
+	// the palette index...).
+	Channels      int
+	Depth         int   // bytes per sample, 1 or 2
+	BigEndian     bool  // for Depth 2
+	Order         []int // sample of each channel: Gray, or R, G, B (, A)
+	Alpha         bool  // the last channel is the alpha
+	Premultiplied bool
+
+	// New returns a blank image of the same type.
+	New func(r image.Rectangle) image.Image
+}
+
+var (
+	orderGray = []int{0}
+	orderRGB  = []int{0, 1, 2}
+	orderRGBA = []int{0, 1, 2, 3}
+)
+
+// layoutOf returns the layout of m, or false if m has no pixel buffer.
+func layoutOf(m image.Image) (l layout, ok bool) {
+	b := m.Bounds()
+	pix := func(p []byte, offset int) []byte {
+		if b.Empty() {
+			return nil
+		}
+		return p[offset:]
+	}
+	switch m := m.(type) {
+	case *image.Gray:
+		return layout{pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), m.Stride, b, 1,
+			1, 1, false, orderGray, false, false,
+			func(r image.Rectangle) image.Image { return image.NewGray(r) }}, true
+	case *image.Gray16:
+		return layout{pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), m.Stride, b, 2,
+			1, 2, true, orderGray, false, false,
+			func(r image.Rectangle) image.Image { return image.NewGray16(r) }}, true
+	case *image.Alpha:
+		return layout{pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), m.Stride, b, 1,
+			1, 1, false, orderGray, true, false,
+			func(r image.Rectangle) image.Image { return image.NewAlpha(r) }}, true
+	case *image.Alpha16:
+		return layout{pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), m.Stride, b, 2,
+			1, 2, true, orderGray, true, false,
+			func(r image.Rectangle) image.Image { return image.NewAlpha16(r) }}, true
+	case *image.RGBA:
+		return layout{pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), m.Stride, b, 4,
+			4, 1, false, orderRGBA, true, true,
+			func(r image.Rectangle) image.Image { return image.NewRGBA(r) }}, true
+	case *image.NRGBA:
+		return layout{pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), m.Stride, b, 4,
+			4, 1, false, orderRGBA, true, false,
+			func(r image.Rectangle) image.Image { return image.NewNRGBA(r) }}, true
+	case *image.RGBA64:
+		return layout{pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), m.Stride, b, 8,
+			4, 2, true, orderRGBA, true, true,
+			func(r image.Rectangle) image.Image { return image.NewRGBA64(r) }}, true
+	case *image.NRGBA64:
+		return layout{pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), m.Stride, b, 8,
+			4, 2, true, orderRGBA, true, false,
+			func(r image.Rectangle) image.Image { return image.NewNRGBA64(r) }}, true
+	case *image.CMYK:
+		return layout{Pix: pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), Stride: m.Stride, Rect: b, Size: 4,
+			New: func(r image.Rectangle) image.Image { return image.NewCMYK(r) }}, true
+	case *image.Paletted:
+		return layout{Pix: pix(m.Pix, m.PixOffset(b.Min.X, b.Min.Y)), Stride: m.Stride, Rect: b, Size: 1,
+			New: func(r image.Rectangle) image.Image { return image.NewPaletted(r, m.Palette) }}, true
+
+	case *webp.RGBImage:
+		return layout{pix(m.XPix, m.PixOffset(b.Min.X, b.Min.Y)), m.XStride, b, 3,
+			3, 1, false, orderRGB, false, false,
+			func(r image.Rectangle) image.Image { return webp.NewRGBImage(r) }}, true
+	case *webp.BGRImage:
+		return layout{pix(m.XPix, m.PixOffset(b.Min.X, b.Min.Y)), m.XStride, b, 3,
+			3, 1, false, []int{2, 1, 0}, false, false,
+			func(r image.Rectangle) image.Image { return webp.NewBGRImage(r) }}, true
+	case *webp.BGRAImage:
+		return layout{pix(m.XPix, m.PixOffset(b.Min.X, b.Min.Y)), m.XStride, b, 4,
+			4, 1, false, []int{2, 1, 0, 3}, true, m.XPremultiplied,
+			func(r image.Rectangle) image.Image {
+				p := webp.NewBGRAImage(r)
+				p.XPremultiplied = m.XPremultiplied
+				return p
+			}}, true
+	case *webp.ARGBImage:
+		return layout{pix(m.XPix, m.PixOffset(b.Min.X, b.Min.Y)), m.XStride, b, 4,
+			4, 1, false, []int{1, 2, 3, 0}, true, m.XPremultiplied,
+			func(r image.Rectangle) image.Image {
+				p := webp.NewARGBImage(r)
+				p.XPremultiplied = m.XPremultiplied
+				return p
+			}}, true
+	case *webp.RGB48Image:
+		// RGB48Image.PixOffset is not in bytes, use the MemP layout.
+		p, _ := webp.AsMemPImage(m)
+		return layout{pix(p.XPix, p.PixOffset(b.Min.X, b.Min.Y)), p.XStride, b, 6,
+			3, 2, nativeBigEndian, orderRGB, false, false,
+			func(r image.Rectangle) image.Image { return webp.NewRGB48Image(r) }}, true
+	case *webp.RGB565Image:
+		return layout{Pix: pix(m.XPix, m.PixOffset(b.Min.X, b.Min.Y)), Stride: m.XStride, Rect: b, Size: 2,
+			New: func(r image.Rectangle) image.Image { return webp.NewRGB565Image(r) }}, true
+	case *webp.RGBA4444Image:
+		return layout{Pix: pix(m.XPix, m.PixOffset(b.Min.X, b.Min.Y)), Stride: m.XStride, Rect: b, Size: 2,
+			New: func(r image.Rectangle) image.Image {
+				p := webp.NewRGBA4444Image(r)
+				p.XPremultiplied = m.XPremultiplied
+				return p
+			}}, true
+	}
+
+	p, ok := webp.AsMemPImage(m)
+	if !ok {
+		return layout{}, false
+	}
+	l = layout{
+		Pix:    pix(p.XPix, p.PixOffset(b.Min.X, b.Min.Y)),
+		Stride: p.XStride,
+		Rect:   b,
+		Size:   webp.SizeofPixel(p.XChannels, p.XDataType),
+		New: func(r image.Rectangle) image.Image {
+			return webp.NewMemPImage(r, p.XChannels, p.XDataType)
+		},
+	}
+	if p.XDataType == reflect.Uint8 || p.XDataType == reflect.Uint16 {
+		// as MemPColor: Gray, RG, RGB or alpha-premultiplied RGBA
+		l.Channels = p.XChannels
+		l.Depth = l.Size / l.Channels
+		l.BigEndian = nativeBigEndian
+		l.Order = orderRGBA[:l.Channels]
+		l.Alpha = l.Channels == 4
+		l.Premultiplied = l.Alpha
+	}
+	return l, true
+}
+
+// line returns the pixels of the line y (relative to Rect.Min.Y).
+func (l *layout) line(y int) []byte {
+	return l.Pix[y*l.Stride:][:l.Size*l.Rect.Dx()]
+}
+
+// layoutOrNRGBA returns the layout of m, or of m converted to *image.NRGBA.
+func layoutOrNRGBA(m image.Image) layout {
+	if l, ok := layoutOf(m); ok {
+		return l
+	}
+	l, _ := layoutOf(toNRGBA(m))
+	return l
+}
+
+func toNRGBA(m image.Image) *image.NRGBA {
+	b := m.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Rect, m, b.Min, draw.Src)
+	return nrgba
+}
+
+// remap returns a w x h image of the type of m, whose pixel (x, y) is the
+// pixel src(x, y) of m, relative to the origin of m.
+func remap(m image.Image, w, h int, src func(x, y int) (sx, sy int)) image.Image {
+	l := layoutOrNRGBA(m)
+	dst := l.New(image.Rect(0, 0, w, h))
+	if w <= 0 || h <= 0 {
+		return dst
+	}
+	dl, _ := layoutOf(dst)
+	parallel(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dl.line(y)
+			for x := 0; x < w; x++ {
+				sx, sy := src(x, y)
+				copy(d[x*l.Size:][:l.Size], l.Pix[sy*l.Stride+sx*l.Size:])
+			}
+		}
+	})
+	return dst
+}
+
+// Crop returns a copy of the part r of m.
+func Crop(m image.Image, r image.Rectangle) image.Image {
+	r = r.Intersect(m.Bounds())
+	if r.Empty() {
+		return layoutOrNRGBA(m).New(image.Rectangle{})
+	}
+	b := m.Bounds()
+	ox, oy := r.Min.X-b.Min.X, r.Min.Y-b.Min.Y
+	return remap(m, r.Dx(), r.Dy(), func(x, y int) (int, int) {
+		return x + ox, y + oy
+	})
+}
+
+// Rotate90 rotates m by 90 degrees clockwise.
+func Rotate90(m image.Image) image.Image {
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	return remap(m, h, w, func(x, y int) (int, int) {
+		return y, h - 1 - x
+	})
+}
+
+// Rotate180 rotates m by 180 degrees.
+func Rotate180(m image.Image) image.Image {
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	return remap(m, w, h, func(x, y int) (int, int) {
+		return w - 1 - x, h - 1 - y
+	})
+}
+
+// Rotate270 rotates m by 270 degrees clockwise (90 degrees counter-clockwise).
+func Rotate270(m image.Image) image.Image {
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	return remap(m, h, w, func(x, y int) (int, int) {
+		return w - 1 - y, x
+	})
+}
+
+// FlipH flips m horizontally (from left to right).
+func FlipH(m image.Image) image.Image {
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	return remap(m, w, h, func(x, y int) (int, int) {
+		return w - 1 - x, y
+	})
+}
+
+// FlipV flips m vertically (from top to bottom).
+func FlipV(m image.Image) image.Image {
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	return remap(m, w, h, func(x, y int) (int, int) {
+		return x, h - 1 - y
+	})
+}
+
+// Transpose flips m along its top-left to bottom-right diagonal.
+func Transpose(m image.Image) image.Image {
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	return remap(m, h, w, func(x, y int) (int, int) {
+		return y, x
+	})
+}
+
+// Transverse flips m along its top-right to bottom-left diagonal.
+func Transverse(m image.Image) image.Image {
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	return remap(m, h, w, func(x, y int) (int, int) {
+		return w - 1 - y, h - 1 - x
+	})
+}
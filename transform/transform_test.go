@@ -0,0 +1,220 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package transform
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/jageros/webp"
+)
+
+// tImageList returns random images of the supported types, as sub-images
+// with an odd origin.
+func tImageList() []image.Image {
+	r := rand.New(rand.NewSource(1))
+	b := image.Rect(0, 0, 13, 9)
+	sub := image.Rect(1, 2, 12, 9)
+
+	var list []image.Image
+	for _, m := range []image.Image{
+		image.NewGray(b),
+		image.NewGray16(b),
+		image.NewAlpha(b),
+		image.NewRGBA(b),
+		image.NewNRGBA(b),
+		image.NewRGBA64(b),
+		image.NewNRGBA64(b),
+		image.NewCMYK(b),
+		image.NewPaletted(b, color.Palette{color.Black, color.White, color.Opaque}),
+		webp.NewRGBImage(b),
+		webp.NewBGRImage(b),
+		webp.NewBGRAImage(b),
+		webp.NewARGBImage(b),
+		webp.NewRGB565Image(b),
+		webp.NewMemPImage(b, 2, reflect.Uint8),
+		webp.NewMemPImage(b, 4, reflect.Uint16),
+		webp.NewMemPImage(b, 1, reflect.Float32),
+	} {
+		l, _ := layoutOf(m)
+		r.Read(l.Pix)
+		if p, ok := m.(*image.Paletted); ok {
+			for i := range p.Pix {
+				p.Pix[i] %= uint8(len(p.Palette))
+			}
+		}
+		list = append(list, m.(interface {
+			SubImage(r image.Rectangle) image.Image
+		}).SubImage(sub))
+	}
+	list = append(list, image.NewYCbCr(b, image.YCbCrSubsampleRatio420).SubImage(sub))
+	return list
+}
+
+func tAssertRemap(t *testing.T, name string, m, got image.Image, src func(x, y int) (int, int)) {
+	t.Helper()
+	b := m.Bounds()
+	model := color.NRGBA64Model
+	if _, ok := layoutOf(m); !ok {
+		model = color.NRGBAModel // converted to *image.NRGBA
+	} else if reflect.TypeOf(got) != reflect.TypeOf(m) {
+		t.Fatalf("%s(%T): got type %T", name, m, got)
+	}
+	if got.Bounds().Min != (image.Point{}) {
+		t.Fatalf("%s(%T): got bounds %v", name, m, got.Bounds())
+	}
+	gb := got.Bounds()
+	for y := gb.Min.Y; y < gb.Max.Y; y++ {
+		for x := gb.Min.X; x < gb.Max.X; x++ {
+			sx, sy := src(x, y)
+			c0 := model.Convert(m.At(b.Min.X+sx, b.Min.Y+sy))
+			c1 := model.Convert(got.At(x, y))
+			if c0 != c1 {
+				t.Fatalf("%s(%T): (%d, %d): got %v, want %v", name, m, x, y, c1, c0)
+			}
+		}
+	}
+}
+
+func TestGeometry(t *testing.T) {
+	for _, m := range tImageList() {
+		w, h := m.Bounds().Dx(), m.Bounds().Dy()
+		tAssertRemap(t, "Rotate90", m, Rotate90(m), func(x, y int) (int, int) { return y, h - 1 - x })
+		tAssertRemap(t, "Rotate180", m, Rotate180(m), func(x, y int) (int, int) { return w - 1 - x, h - 1 - y })
+		tAssertRemap(t, "Rotate270", m, Rotate270(m), func(x, y int) (int, int) { return w - 1 - y, x })
+		tAssertRemap(t, "FlipH", m, FlipH(m), func(x, y int) (int, int) { return w - 1 - x, y })
+		tAssertRemap(t, "FlipV", m, FlipV(m), func(x, y int) (int, int) { return x, h - 1 - y })
+		tAssertRemap(t, "Transpose", m, Transpose(m), func(x, y int) (int, int) { return y, x })
+		tAssertRemap(t, "Transverse", m, Transverse(m), func(x, y int) (int, int) { return w - 1 - y, h - 1 - x })
+
+		r := image.Rect(2, 3, 7, 100).Add(m.Bounds().Min)
+		got := Crop(m, r)
+		if got.Bounds() != image.Rect(0, 0, 5, h-3) {
+			t.Fatalf("Crop(%T): got bounds %v", m, got.Bounds())
+		}
+		tAssertRemap(t, "Crop", m, got, func(x, y int) (int, int) { return x + 2, y + 3 })
+
+		if got := Rotate90(Rotate90(Rotate90(Rotate90(m)))); got.Bounds().Size() != m.Bounds().Size() {
+			t.Fatalf("Rotate90 x 4 (%T): got bounds %v", m, got.Bounds())
+		}
+	}
+}
+
+func TestResize_types(t *testing.T) {
+	for _, m := range tImageList() {
+		for _, f := range []Filter{Box, Bilinear, CatmullRom, Lanczos3, Mitchell} {
+			got := Resize(m, 5, 0, &Options{Filter: f, Premultiply: true})
+			want := image.Rect(0, 0, 5, 3)
+			if got.Bounds() != want {
+				t.Fatalf("%T, %v: got bounds %v, want %v", m, f, got.Bounds(), want)
+			}
+			if l, ok := layoutOf(m); ok && l.Channels != 0 && reflect.TypeOf(got) != reflect.TypeOf(m) {
+				t.Fatalf("%T, %v: got type %T", m, f, got)
+			}
+		}
+	}
+
+	got := Resize(webp.NewRGB48Image(image.Rect(0, 0, 10, 6)), 5, 0, nil)
+	if _, ok := got.(*webp.RGB48Image); !ok || got.Bounds() != image.Rect(0, 0, 5, 3) {
+		t.Fatalf("*webp.RGB48Image: got %T, bounds %v", got, got.Bounds())
+	}
+}
+
+func TestResize_identity(t *testing.T) {
+	for _, m := range tImageList() {
+		l, ok := layoutOf(m)
+		if !ok || l.Channels == 0 || l.Depth != 1 || l.Premultiplied {
+			continue
+		}
+		b := m.Bounds()
+		got := Resize(m, b.Dx(), b.Dy(), &Options{Filter: Box})
+		tAssertRemap(t, "Resize", m, got, func(x, y int) (int, int) { return x, y })
+	}
+}
+
+func TestResize_constant(t *testing.T) {
+	c := color.NRGBA{R: 200, G: 100, B: 30, A: 160}
+	m := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	draw.Draw(m, m.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+
+	for _, f := range []Filter{Box, Bilinear, CatmullRom, Lanczos3, Mitchell} {
+		for _, size := range []image.Point{{10, 7}, {37, 23}, {80, 51}} {
+			got := Resize(m, size.X, size.Y, &Options{Filter: f, Premultiply: true}).(*image.NRGBA)
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					if v := got.NRGBAAt(x, y); v != c {
+						t.Fatalf("%v, %v: (%d, %d): got %v, want %v", f, size, x, y, v, c)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestResize_linearLight(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 2, 1))
+	m.Pix[0], m.Pix[1] = 0, 255
+
+	got := Resize(m, 1, 1, &Options{Filter: Box}).(*image.Gray)
+	// the average of black and white is 0.5 in linear light, 188 in sRGB
+	if v := got.Pix[0]; v != 188 {
+		t.Fatalf("got %d, want 188", v)
+	}
+}
+
+func TestResize_premultiply(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	copy(m.Pix, []uint8{
+		0xff, 0, 0, 0, // transparent red
+		0, 0, 0xff, 0xff, // blue
+	})
+
+	for _, tt := range []struct {
+		Premultiply bool
+		Want        color.NRGBA
+	}{
+		{true, color.NRGBA{0, 0, 0xff, 0x80}},
+		{false, color.NRGBA{0xbc, 0, 0xbc, 0x80}},
+	} {
+		got := Resize(m, 1, 1, &Options{Filter: Box, Premultiply: tt.Premultiply}).(*image.NRGBA)
+		if v := got.NRGBAAt(0, 0); v != tt.Want {
+			t.Fatalf("Premultiply = %v: got %v, want %v", tt.Premultiply, v, tt.Want)
+		}
+	}
+}
+
+func TestResize_keepAspect(t *testing.T) {
+	m := webp.NewRGBImage(image.Rect(0, 0, 400, 300))
+	for _, tt := range []struct {
+		W, H int
+		Want image.Point
+	}{
+		{200, 0, image.Pt(200, 150)},
+		{0, 30, image.Pt(40, 30)},
+		{1, 0, image.Pt(1, 1)},
+		{0, 0, image.Pt(0, 0)},
+	} {
+		got := Resize(m, tt.W, tt.H, nil)
+		if got.Bounds().Size() != tt.Want {
+			t.Fatalf("%dx%d: got %v, want %v", tt.W, tt.H, got.Bounds().Size(), tt.Want)
+		}
+		if _, ok := got.(*webp.RGBImage); !ok {
+			t.Fatalf("%dx%d: got type %T", tt.W, tt.H, got)
+		}
+	}
+}
+
+func ExampleResize() {
+	m := webp.NewRGBImage(image.Rect(0, 0, 1024, 768))
+	thumbnail := Resize(m, 160, 0, &Options{Filter: Lanczos3})
+	fmt.Println(thumbnail.Bounds())
+	// Output:
+	// (0,0)-(160,120)
+}
@@ -5,8 +5,15 @@
 package webp
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"io"
 	"strings"
+	"sync"
 
 	"embed"
 )
@@ -22,6 +29,52 @@ func GetInfo(data []byte) (width, height int, hasAlpha bool, err error) {
 	return webpGetInfo(data)
 }
 
+// Format is the compression format of a WebP bitstream.
+type Format int
+
+const (
+	FormatMixed Format = iota // animation mixing lossy and lossless frames
+	FormatLossy
+	FormatLossless
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatLossy:
+		return "lossy"
+	case FormatLossless:
+		return "lossless"
+	}
+	return "mixed"
+}
+
+// ImageFeatures contains the bitstream features and the container flags
+// of a WebP image.
+type ImageFeatures struct {
+	Width        int
+	Height       int
+	Format       Format
+	HasAlpha     bool
+	HasAnimation bool
+
+	HasICCP bool
+	HasEXIF bool
+	HasXMP  bool
+
+	CanvasWidth     int
+	CanvasHeight    int
+	FrameCount      int
+	LoopCount       int         // 0 means infinite, only relevant for animations
+	BackgroundColor color.NRGBA // only relevant for animations
+}
+
+// Features returns the features of a WebP image without decoding it.
+// The data must hold the whole file, the frames and chunks are walked
+// to fill the container fields.
+func Features(data []byte) (features *ImageFeatures, err error) {
+	return webpGetFeatures(data)
+}
+
 func DecodeGray(data []byte) (m *image.Gray, err error) {
 	pix, w, h, err := webpDecodeGray(data)
 	if err != nil {
@@ -48,8 +101,9 @@ func DecodeRGB(data []byte) (m *RGBImage, err error) {
 	return
 }
 
+// DecodeRGBA decodes a WebP image to an alpha-premultiplied *image.RGBA.
 func DecodeRGBA(data []byte) (m *image.RGBA, err error) {
-	pix, w, h, err := webpDecodeRGBA(data)
+	pix, w, h, err := webpDecodePremulRGBA(data)
 	if err != nil {
 		return
 	}
@@ -61,6 +115,139 @@ func DecodeRGBA(data []byte) (m *image.RGBA, err error) {
 	return
 }
 
+// DecodeNRGBA decodes a WebP image to a non-premultiplied *image.NRGBA,
+// which is how WebP stores the alpha channel.
+func DecodeNRGBA(data []byte) (m *image.NRGBA, err error) {
+	pix, w, h, err := webpDecodeRGBA(data)
+	if err != nil {
+		return
+	}
+	m = &image.NRGBA{
+		Pix:    pix,
+		Stride: 4 * w,
+		Rect:   image.Rect(0, 0, w, h),
+	}
+	return
+}
+
+// DecodeYCbCr decodes the native YUV 4:2:0 planes of a WebP image, without
+// any conversion to RGB. It returns an *image.YCbCr, or an *image.NYCbCrA
+// if the image has an alpha plane. Lossless images are converted to YUV
+// by the decoder.
+//
+// WebP stores YUV with the BT.601 limited range, the samples are expanded
+// to the JFIF full range of image.YCbCr, the range EncodeYCbCr expects.
+func DecodeYCbCr(data []byte) (m image.Image, err error) {
+	w, h, hasAlpha, err := webpGetInfo(data)
+	if err != nil {
+		return
+	}
+	r := image.Rect(0, 0, w, h)
+	if hasAlpha {
+		p := image.NewNYCbCrA(r, image.YCbCrSubsampleRatio420)
+		err = webpDecodeYUVAInto(data, p.Y, p.YStride, p.Cb, p.Cr, p.CStride, p.A, p.AStride)
+		if err != nil {
+			return
+		}
+		expandYCbCr(&p.YCbCr)
+		m = p
+		return
+	}
+	p := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	err = webpDecodeYUVAInto(data, p.Y, p.YStride, p.Cb, p.Cr, p.CStride, nil, 0)
+	if err != nil {
+		return
+	}
+	expandYCbCr(p)
+	m = p
+	return
+}
+
+// DecodeColorspace decodes a WebP image to the colorspace given by mode.
+// The pixels are returned in the matching image type:
+//
+//	MODE_RGB                       *RGBImage
+//	MODE_RGBA                      *image.NRGBA
+//	MODE_rgbA                      *image.RGBA
+//	MODE_BGR                       *BGRImage
+//	MODE_BGRA, MODE_bgrA           *BGRAImage
+//	MODE_ARGB, MODE_Argb           *ARGBImage
+//	MODE_RGB_565                   *RGB565Image
+//	MODE_RGBA_4444, MODE_rgbA_4444 *RGBA4444Image
+//	MODE_YUV                       *image.YCbCr
+//	MODE_YUVA                      *image.NYCbCrA
+//
+// As the other modes, the YUV modes return the samples of libwebp as is,
+// in the BT.601 limited range: use DecodeYCbCr for the full range of Go.
+func DecodeColorspace(data []byte, mode WEBP_CSP_MODE) (m image.Image, err error) {
+	w, h, hasAlpha, err := webpGetInfo(data)
+	if err != nil {
+		return
+	}
+	r := image.Rect(0, 0, w, h)
+
+	switch mode {
+	case MODE_YUV:
+		p := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+		if err = webpDecodeYUVAInto(data, p.Y, p.YStride, p.Cb, p.Cr, p.CStride, nil, 0); err != nil {
+			return
+		}
+		return p, nil
+	case MODE_YUVA:
+		p := image.NewNYCbCrA(r, image.YCbCrSubsampleRatio420)
+		if !hasAlpha {
+			for i := range p.A {
+				p.A[i] = 0xff
+			}
+			err = webpDecodeYUVAInto(data, p.Y, p.YStride, p.Cb, p.Cr, p.CStride, nil, 0)
+		} else {
+			err = webpDecodeYUVAInto(data, p.Y, p.YStride, p.Cb, p.Cr, p.CStride, p.A, p.AStride)
+		}
+		if err != nil {
+			return
+		}
+		return p, nil
+	}
+
+	var pix []byte
+	var stride int
+	switch mode {
+	case MODE_RGB:
+		p := NewRGBImage(r)
+		m, pix, stride = p, p.XPix, p.XStride
+	case MODE_RGBA:
+		p := image.NewNRGBA(r)
+		m, pix, stride = p, p.Pix, p.Stride
+	case MODE_rgbA:
+		p := image.NewRGBA(r)
+		m, pix, stride = p, p.Pix, p.Stride
+	case MODE_BGR:
+		p := NewBGRImage(r)
+		m, pix, stride = p, p.XPix, p.XStride
+	case MODE_BGRA, MODE_bgrA:
+		p := NewBGRAImage(r)
+		p.XPremultiplied = WebPIsPremultipliedMode(mode)
+		m, pix, stride = p, p.XPix, p.XStride
+	case MODE_ARGB, MODE_Argb:
+		p := NewARGBImage(r)
+		p.XPremultiplied = WebPIsPremultipliedMode(mode)
+		m, pix, stride = p, p.XPix, p.XStride
+	case MODE_RGB_565:
+		p := NewRGB565Image(r)
+		m, pix, stride = p, p.XPix, p.XStride
+	case MODE_RGBA_4444, MODE_rgbA_4444:
+		p := NewRGBA4444Image(r)
+		p.XPremultiplied = WebPIsPremultipliedMode(mode)
+		m, pix, stride = p, p.XPix, p.XStride
+	default:
+		return nil, errors.New("webp: DecodeColorspace, unknown mode")
+	}
+	if err = webpDecodeInto(data, mode, pix, stride); err != nil {
+		return nil, err
+	}
+	return
+}
+
 // DecodeGrayToSize decodes a Gray image scaled to the given dimensions. For
 // large images, the DecodeXXXToSize methods are significantly faster and
 // require less memory compared to decoding a full-size image and then resizing it.
@@ -91,9 +278,10 @@ func DecodeRGBToSize(data []byte, width, height int) (m *RGBImage, err error) {
 	return
 }
 
-// DecodeRGBAToSize decodes a Gray image scaled to the given dimensions.
+// DecodeRGBAToSize decodes an alpha-premultiplied RGBA image scaled to the
+// given dimensions.
 func DecodeRGBAToSize(data []byte, width, height int) (m *image.RGBA, err error) {
-	pix, err := webpDecodeRGBAToSize(data, width, height)
+	pix, err := webpDecodePremulRGBAToSize(data, width, height)
 	if err != nil {
 		return
 	}
@@ -105,6 +293,67 @@ func DecodeRGBAToSize(data []byte, width, height int) (m *image.RGBA, err error)
 	return
 }
 
+// DecodeRGBAInto decodes an alpha-premultiplied RGBA image into pix,
+// without any allocation. The pix must hold at least width x height
+// pixels with the given stride (see GetInfo), it is checked by the decoder.
+func DecodeRGBAInto(data, pix []byte, stride int) (err error) {
+	return webpDecodePremulRGBAInto(data, pix, stride)
+}
+
+// DecodeNRGBAInto is DecodeRGBAInto with non-premultiplied alpha.
+func DecodeNRGBAInto(data, pix []byte, stride int) (err error) {
+	return webpDecodeRGBAInto(data, pix, stride)
+}
+
+// DecodeInto decodes a WebP image into dst, at dst.Bounds().Min. The dst
+// must be at least as large as the image.
+//
+// *image.RGBA, *image.NRGBA and *RGBImage are decoded in place, other
+// types are drawn from a pooled NRGBA buffer.
+func DecodeInto(data []byte, dst draw.Image) (err error) {
+	w, h, _, err := webpGetInfo(data)
+	if err != nil {
+		return
+	}
+	b := dst.Bounds()
+	if b.Dx() < w || b.Dy() < h {
+		return errors.New("webp: DecodeInto, dst is too small")
+	}
+
+	switch dst := dst.(type) {
+	case *image.RGBA:
+		return DecodeRGBAInto(data, dst.Pix[dst.PixOffset(b.Min.X, b.Min.Y):], dst.Stride)
+	case *image.NRGBA:
+		return DecodeNRGBAInto(data, dst.Pix[dst.PixOffset(b.Min.X, b.Min.Y):], dst.Stride)
+	case *RGBImage:
+		return webpDecodeRGBInto(data, dst.XPix[dst.PixOffset(b.Min.X, b.Min.Y):], dst.XStride)
+	}
+
+	p := getPixBuffer(4 * w * h)
+	defer putPixBuffer(p)
+	if err = webpDecodeRGBAInto(data, *p, 4*w); err != nil {
+		return
+	}
+	src := &image.NRGBA{Pix: *p, Stride: 4 * w, Rect: image.Rect(0, 0, w, h)}
+	draw.Draw(dst, image.Rectangle{Min: b.Min, Max: b.Min.Add(src.Rect.Max)}, src, image.Point{}, draw.Src)
+	return
+}
+
+var pixBufferPool sync.Pool
+
+func getPixBuffer(size int) *[]byte {
+	if p, ok := pixBufferPool.Get().(*[]byte); ok && cap(*p) >= size {
+		*p = (*p)[:size]
+		return p
+	}
+	p := make([]byte, size)
+	return &p
+}
+
+func putPixBuffer(p *[]byte) {
+	pixBufferPool.Put(p)
+}
+
 func EncodeGray(m image.Image, quality float32) (data []byte, err error) {
 	p := toGrayImage(m)
 	data, err = webpEncodeGray(p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride, quality)
@@ -120,12 +369,58 @@ func EncodeRGB(m image.Image, quality float32) (data []byte, err error) {
 	return
 }
 
+// EncodeRGBA encodes an image with an alpha channel. The alpha of an
+// *image.RGBA is premultiplied, it is converted to the straight alpha
+// of WebP before encoding, *image.NRGBA is encoded as is.
 func EncodeRGBA(m image.Image, quality float32) (data []byte, err error) {
-	p := toRGBAImage(m)
+	p := toNRGBAImage(m)
 	data, err = webpEncodeRGBA(p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride, quality)
 	return
 }
 
+// EncodeYCbCr encodes an *image.YCbCr or *image.NYCbCrA without converting
+// it to RGB. The 4:2:0 planes are imported as is, other subsample ratios
+// are resampled to 4:2:0 first. The samples are mapped from the JFIF full
+// range used by Go to the limited range used by WebP.
+func EncodeYCbCr(m image.Image, quality float32) (data []byte, err error) {
+	p, ok := newYUV420ImageFrom(m)
+	if !ok {
+		err = errors.New("webp: EncodeYCbCr, unsupported image type")
+		return
+	}
+	buf := new(bytes.Buffer)
+	err = webpEncodeYUVA(
+		buf, p.Y, p.U, p.V, p.A, p.Rect.Dx(), p.Rect.Dy(),
+		p.YStride, p.UVStride, p.AStride, &encoderOptions{Quality: quality},
+	)
+	if err != nil {
+		return
+	}
+	data = buf.Bytes()
+	return
+}
+
+// encodeImage encodes the image m, adjusted for the encoder, with the
+// parameters opt. The output is written to w by libwebp after the whole
+// frame is encoded, an error of w aborts the writing.
+func encodeImage(w io.Writer, m image.Image, opt *encoderOptions) (err error) {
+	switch m := m.(type) {
+	case *image.YCbCr, *image.NYCbCrA:
+		p, _ := newYUV420ImageFrom(m)
+		return webpEncodeYUVA(
+			w, p.Y, p.U, p.V, p.A, p.Rect.Dx(), p.Rect.Dy(),
+			p.YStride, p.UVStride, p.AStride, opt,
+		)
+	case *image.Gray:
+		return webpEncodePix(w, m.Pix, 1, m.Rect.Dx(), m.Rect.Dy(), m.Stride, opt)
+	case *RGBImage:
+		return webpEncodePix(w, m.XPix, 3, m.XRect.Dx(), m.XRect.Dy(), m.XStride, opt)
+	default:
+		p := toNRGBAImage(m)
+		return webpEncodePix(w, p.Pix, 4, p.Rect.Dx(), p.Rect.Dy(), p.Stride, opt)
+	}
+}
+
 func EncodeLosslessGray(m image.Image) (data []byte, err error) {
 	p := toGrayImage(m)
 	data, err = webpEncodeLosslessGray(p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride)
@@ -139,7 +434,7 @@ func EncodeLosslessRGB(m image.Image) (data []byte, err error) {
 }
 
 func EncodeLosslessRGBA(m image.Image) (data []byte, err error) {
-	p := toRGBAImage(m)
+	p := toNRGBAImage(m)
 	data, err = webpEncodeLosslessRGBA(0, p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride)
 	return
 }
@@ -147,7 +442,7 @@ func EncodeLosslessRGBA(m image.Image) (data []byte, err error) {
 // EncodeExactLosslessRGBA Encode lossless RGB mode with exact.
 // exact: preserve RGB values in transparent area.
 func EncodeExactLosslessRGBA(m image.Image) (data []byte, err error) {
-	p := toRGBAImage(m)
+	p := toNRGBAImage(m)
 	data, err = webpEncodeLosslessRGBA(1, p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride)
 	return
 }
@@ -162,6 +457,22 @@ func SetMetadata(data, metadata []byte, format string) (newData []byte, err erro
 	return webpSetMetadata(data, metadata, format)
 }
 
+// DelMetadata removes the EXIF/ICCP/XMP format metadata. The data is
+// returned as is if it has no such metadata.
+func DelMetadata(data []byte, format string) (newData []byte, err error) {
+	features, err := Features(data)
+	if err != nil {
+		return
+	}
+	switch format = strings.ToUpper(format); {
+	case format == "EXIF" && !features.HasEXIF,
+		format == "ICCP" && !features.HasICCP,
+		format == "XMP" && !features.HasXMP:
+		return data, nil
+	}
+	return webpDelMetadata(data, format)
+}
+
 // AnimInfo contains animation information
 type AnimInfo struct {
 	CanvasWidth  int
@@ -173,8 +484,8 @@ type AnimInfo struct {
 // Frame represents a single frame in an animation
 type Frame struct {
 	Image     *image.RGBA
-	Timestamp int
-	Duration  int
+	Timestamp int // start of the frame, in milliseconds
+	Duration  int // in milliseconds
 }
 
 // IsAnimated checks if the WebP data contains an animation
@@ -192,7 +503,9 @@ func DecodeAnimFirstFrame(data []byte) (*image.RGBA, error) {
 	return webpDecodeAnimFirstFrame(data)
 }
 
-// DecodeAnimFrames decodes all frames of an animated WebP
+// DecodeAnimFrames decodes all frames of an animated WebP. The frames are
+// composited on the canvas, with their offsets, blending and disposal, and
+// all the images have the size of the canvas.
 func DecodeAnimFrames(data []byte) ([]*Frame, error) {
 	return webpDecodeAnimFrames(data)
 }
@@ -208,3 +521,51 @@ func ConvertAnimToStatic(data []byte) ([]byte, error) {
 	// 编码为静态 WebP
 	return EncodeRGBA(firstFrame, 80.0)
 }
+
+// AnimFrame is a frame of an animation, as a still WebP image placed on
+// the canvas.
+type AnimFrame struct {
+	Data     []byte // still WebP image
+	X, Y     int    // offset on the canvas, even
+	Duration int    // in milliseconds
+
+	DisposeToBackground bool // clear the frame to the background color after its duration
+	NoBlend             bool // replace the canvas instead of alpha-blending
+}
+
+// AnimOptions are the global parameters of an animation.
+type AnimOptions struct {
+	CanvasWidth     int // 0 for the bounds of the frames
+	CanvasHeight    int
+	LoopCount       int // 0 means infinite
+	BackgroundColor color.NRGBA
+}
+
+// SetLoopCount sets the loop count of an animation, 0 means infinite.
+func SetLoopCount(data []byte, loopCount int) (newData []byte, err error) {
+	return webpSetLoopCount(data, loopCount)
+}
+
+// GetAnimFrame returns the frame of the index (from 0) of an animation, as
+// it is stored: the still image of the frame is not composited on the
+// canvas. The index 0 of a still image returns the image.
+func GetAnimFrame(data []byte, index int) (*AnimFrame, error) {
+	return webpGetFrame(data, index)
+}
+
+// MuxAnimation assembles the frames into an animation, without encoding
+// them again. The metadata of the frames are dropped.
+func MuxAnimation(frames []*AnimFrame, opt *AnimOptions) ([]byte, error) {
+	if opt == nil {
+		opt = new(AnimOptions)
+	}
+	for i, f := range frames {
+		if f.X < 0 || f.Y < 0 || f.X%2 != 0 || f.Y%2 != 0 {
+			return nil, fmt.Errorf("webp: MuxAnimation, frame %d: invalid offset %d,%d", i, f.X, f.Y)
+		}
+		if f.Duration < 0 || f.Duration >= 1<<24 {
+			return nil, fmt.Errorf("webp: MuxAnimation, frame %d: invalid duration %d", i, f.Duration)
+		}
+	}
+	return webpMuxAnimation(frames, opt)
+}
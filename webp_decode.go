@@ -14,6 +14,21 @@ const (
 
 const (
 	_C_WEBP_DECODER_ABI_VERSION = C.WEBP_DECODER_ABI_VERSION // for test
+
+	_C_MODE_RGB       = C.MODE_RGB // for test
+	_C_MODE_RGBA      = C.MODE_RGBA
+	_C_MODE_BGR       = C.MODE_BGR
+	_C_MODE_BGRA      = C.MODE_BGRA
+	_C_MODE_ARGB      = C.MODE_ARGB
+	_C_MODE_RGBA_4444 = C.MODE_RGBA_4444
+	_C_MODE_RGB_565   = C.MODE_RGB_565
+	_C_MODE_rgbA      = C.MODE_rgbA
+	_C_MODE_bgrA      = C.MODE_bgrA
+	_C_MODE_Argb      = C.MODE_Argb
+	_C_MODE_rgbA_4444 = C.MODE_rgbA_4444
+	_C_MODE_YUV       = C.MODE_YUV
+	_C_MODE_YUVA      = C.MODE_YUVA
+	_C_MODE_LAST      = C.MODE_LAST
 )
 
 // Return the decoder's version number, packed in hexadecimal using 8bits for
@@ -36,3 +51,50 @@ func WebPGetInfo(data []byte) (width, height int, ok bool) {
 	}
 	return int(cw), int(ch), true
 }
+
+// Colorspaces
+// Note: the naming describes the byte-ordering of packed samples in memory.
+// For instance, MODE_BGRA relates to samples ordered as B,G,R,A,B,G,R,A,...
+// Non-capital names (e.g.:MODE_Argb) relates to pre-multiplied RGB channels.
+// RGBA-4444 and RGB-565 colorspaces are represented by following byte-order:
+// RGBA-4444: [r3 r2 r1 r0 g3 g2 g1 g0], [b3 b2 b1 b0 a3 a2 a1 a0], ...
+// RGB-565: [r4 r3 r2 r1 r0 g5 g4 g3], [g2 g1 g0 b4 b3 b2 b1 b0], ...
+type WEBP_CSP_MODE int
+
+const (
+	MODE_RGB       WEBP_CSP_MODE = 0
+	MODE_RGBA      WEBP_CSP_MODE = 1
+	MODE_BGR       WEBP_CSP_MODE = 2
+	MODE_BGRA      WEBP_CSP_MODE = 3
+	MODE_ARGB      WEBP_CSP_MODE = 4
+	MODE_RGBA_4444 WEBP_CSP_MODE = 5
+	MODE_RGB_565   WEBP_CSP_MODE = 6
+
+	// RGB-premultiplied transparent modes (alpha value is preserved)
+	MODE_rgbA      WEBP_CSP_MODE = 7
+	MODE_bgrA      WEBP_CSP_MODE = 8
+	MODE_Argb      WEBP_CSP_MODE = 9
+	MODE_rgbA_4444 WEBP_CSP_MODE = 10
+
+	// YUV modes must come after RGB ones.
+	MODE_YUV  WEBP_CSP_MODE = 11 // yuv 4:2:0
+	MODE_YUVA WEBP_CSP_MODE = 12 // yuv 4:2:0
+	MODE_LAST WEBP_CSP_MODE = 13
+)
+
+// Some useful macros:
+
+func WebPIsPremultipliedMode(mode WEBP_CSP_MODE) bool {
+	return (mode == MODE_rgbA || mode == MODE_bgrA || mode == MODE_Argb ||
+		mode == MODE_rgbA_4444)
+}
+
+func WebPIsAlphaMode(mode WEBP_CSP_MODE) bool {
+	return (mode == MODE_RGBA || mode == MODE_BGRA || mode == MODE_ARGB ||
+		mode == MODE_RGBA_4444 || mode == MODE_YUVA ||
+		WebPIsPremultipliedMode(mode))
+}
+
+func WebPIsRGBMode(mode WEBP_CSP_MODE) bool {
+	return (mode < MODE_YUV)
+}
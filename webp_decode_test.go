@@ -22,3 +22,20 @@ func TestWebPGetInfo(t *testing.T) {
 	tAssertEQ(t, 301, h)
 	tAssert(t, ok)
 }
+
+func TestWEBP_CSP_MODE(t *testing.T) {
+	tAssertEQ(t, _C_MODE_RGB, MODE_RGB)
+	tAssertEQ(t, _C_MODE_RGBA, MODE_RGBA)
+	tAssertEQ(t, _C_MODE_BGR, MODE_BGR)
+	tAssertEQ(t, _C_MODE_BGRA, MODE_BGRA)
+	tAssertEQ(t, _C_MODE_ARGB, MODE_ARGB)
+	tAssertEQ(t, _C_MODE_RGBA_4444, MODE_RGBA_4444)
+	tAssertEQ(t, _C_MODE_RGB_565, MODE_RGB_565)
+	tAssertEQ(t, _C_MODE_rgbA, MODE_rgbA)
+	tAssertEQ(t, _C_MODE_bgrA, MODE_bgrA)
+	tAssertEQ(t, _C_MODE_Argb, MODE_Argb)
+	tAssertEQ(t, _C_MODE_rgbA_4444, MODE_rgbA_4444)
+	tAssertEQ(t, _C_MODE_YUV, MODE_YUV)
+	tAssertEQ(t, _C_MODE_YUVA, MODE_YUVA)
+	tAssertEQ(t, _C_MODE_LAST, MODE_LAST)
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+//#include <webp/encode.h>
+import "C"
+
+const (
+	WEBP_ENCODER_ABI_VERSION = 0x020f // MAJOR(8b) + MINOR(8b)
+
+	WEBP_MAX_DIMENSION = 16383 // maximum width/height allowed (inclusive), in pixels
+)
+
+const (
+	_C_WEBP_ENCODER_ABI_VERSION = C.WEBP_ENCODER_ABI_VERSION // for test
+	_C_WEBP_MAX_DIMENSION       = C.WEBP_MAX_DIMENSION
+
+	_C_WEBP_PRESET_DEFAULT = C.WEBP_PRESET_DEFAULT // for test
+	_C_WEBP_PRESET_PICTURE = C.WEBP_PRESET_PICTURE
+	_C_WEBP_PRESET_PHOTO   = C.WEBP_PRESET_PHOTO
+	_C_WEBP_PRESET_DRAWING = C.WEBP_PRESET_DRAWING
+	_C_WEBP_PRESET_ICON    = C.WEBP_PRESET_ICON
+	_C_WEBP_PRESET_TEXT    = C.WEBP_PRESET_TEXT
+)
+
+// Return the encoder's version number, packed in hexadecimal using 8bits for
+// each of major/minor/revision. E.g: v2.5.7 is 0x020507.
+func WebPGetEncoderVersion() uint {
+	return uint(C.WebPGetEncoderVersion())
+}
+
+// Enumerate some predefined settings for WebPConfig, depending on the type
+// of source picture. These presets are used when calling WebPConfigPreset().
+type WebPPreset int
+
+const (
+	WEBP_PRESET_DEFAULT WebPPreset = 0 // default preset.
+	WEBP_PRESET_PICTURE WebPPreset = 1 // digital picture, like portrait, inner shot
+	WEBP_PRESET_PHOTO   WebPPreset = 2 // outdoor photograph, with natural lighting
+	WEBP_PRESET_DRAWING WebPPreset = 3 // hand or line drawing, with high-contrast details
+	WEBP_PRESET_ICON    WebPPreset = 4 // small-sized colorful images
+	WEBP_PRESET_TEXT    WebPPreset = 5 // text-like
+)
+
+func (p WebPPreset) String() string {
+	switch p {
+	case WEBP_PRESET_DEFAULT:
+		return "default"
+	case WEBP_PRESET_PICTURE:
+		return "picture"
+	case WEBP_PRESET_PHOTO:
+		return "photo"
+	case WEBP_PRESET_DRAWING:
+		return "drawing"
+	case WEBP_PRESET_ICON:
+		return "icon"
+	case WEBP_PRESET_TEXT:
+		return "text"
+	}
+	return "unknown"
+}
@@ -0,0 +1,26 @@
+// Copyright 2016 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"testing"
+)
+
+func TestWEBP_ENCODER_ABI_VERSION(t *testing.T) {
+	tAssertEQ(t, _C_WEBP_ENCODER_ABI_VERSION, WEBP_ENCODER_ABI_VERSION)
+}
+
+func TestWEBP_MAX_DIMENSION(t *testing.T) {
+	tAssertEQ(t, _C_WEBP_MAX_DIMENSION, WEBP_MAX_DIMENSION)
+}
+
+func TestWebPPreset(t *testing.T) {
+	tAssertEQ(t, WebPPreset(_C_WEBP_PRESET_DEFAULT), WEBP_PRESET_DEFAULT)
+	tAssertEQ(t, WebPPreset(_C_WEBP_PRESET_PICTURE), WEBP_PRESET_PICTURE)
+	tAssertEQ(t, WebPPreset(_C_WEBP_PRESET_PHOTO), WEBP_PRESET_PHOTO)
+	tAssertEQ(t, WebPPreset(_C_WEBP_PRESET_DRAWING), WEBP_PRESET_DRAWING)
+	tAssertEQ(t, WebPPreset(_C_WEBP_PRESET_ICON), WEBP_PRESET_ICON)
+	tAssertEQ(t, WebPPreset(_C_WEBP_PRESET_TEXT), WEBP_PRESET_TEXT)
+}
@@ -6,8 +6,10 @@ package webp
 
 import (
 	"fmt"
+	"image"
 	"io/ioutil"
 	"log"
+	"sync"
 )
 
 func xLoadData(filename string) []byte {
@@ -53,3 +55,30 @@ func ExampleGetInfo_noAlpha() {
 	// height: 103
 	// hasAlpha: false
 }
+
+func ExampleDecodeRGBAInto() {
+	data := xLoadData("1_webp_a.webp")
+
+	// reuse the pixel buffers across the decoded images
+	pool := sync.Pool{New: func() interface{} { return new([]byte) }}
+
+	width, height, _, err := GetInfo(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := pool.Get().(*[]byte)
+	defer pool.Put(p)
+	if cap(*p) < 4*width*height {
+		*p = make([]byte, 4*width*height)
+	}
+	pix := (*p)[:4*width*height]
+
+	if err := DecodeRGBAInto(data, pix, 4*width); err != nil {
+		log.Fatal(err)
+	}
+	m := &image.RGBA{Pix: pix, Stride: 4 * width, Rect: image.Rect(0, 0, width, height)}
+	fmt.Printf("Bounds = %v\n", m.Bounds())
+
+	// Output:
+	// Bounds = (0,0)-(400,301)
+}
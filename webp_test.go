@@ -5,8 +5,17 @@
 package webp
 
 import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+
+	xwebp "golang.org/x/image/webp"
 )
 
 type tGetInfoTester struct {
@@ -47,3 +56,595 @@ var tGetInfoTesterList = []tGetInfoTester{
 		HasAlpha: true,
 	},
 }
+
+type tFeaturesTester struct {
+	Filename string
+	Width    int
+	Height   int
+	Format   Format
+	HasAlpha bool
+}
+
+func TestFeatures(t *testing.T) {
+	for i, v := range tFeaturesTesterList {
+		data, err := os.ReadFile(testdataDir + v.Filename)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		features, err := Features(data)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		tAssertEQ(t, v.Width, features.Width, v.Filename)
+		tAssertEQ(t, v.Height, features.Height, v.Filename)
+		tAssertEQ(t, v.Format, features.Format, v.Filename)
+		tAssertEQ(t, v.HasAlpha, features.HasAlpha, v.Filename)
+		tAssertEQ(t, false, features.HasAnimation, v.Filename)
+		tAssertEQ(t, v.Width, features.CanvasWidth, v.Filename)
+		tAssertEQ(t, v.Height, features.CanvasHeight, v.Filename)
+		tAssertEQ(t, 1, features.FrameCount, v.Filename)
+	}
+}
+
+func TestFeatures_metadata(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "video-001.webp")
+	tAssertNil(t, err)
+
+	data, err = SetMetadata(data, []byte("exif-data"), "EXIF")
+	tAssertNil(t, err)
+
+	features, err := Features(data)
+	tAssertNil(t, err)
+	tAssert(t, features.HasEXIF)
+	tAssert(t, !features.HasICCP)
+	tAssert(t, !features.HasXMP)
+
+	// the removal of a missing chunk keeps the data
+	stripped, err := DelMetadata(data, "xmp")
+	tAssertNil(t, err)
+	tAssert(t, bytes.Equal(data, stripped))
+	stripped, err = DelMetadata(data, "EXIF")
+	tAssertNil(t, err)
+	features, err = Features(stripped)
+	tAssertNil(t, err)
+	tAssert(t, !features.HasEXIF)
+}
+
+var tFeaturesTesterList = []tFeaturesTester{
+	tFeaturesTester{
+		Filename: "1_webp_ll.webp",
+		Width:    400,
+		Height:   301,
+		Format:   FormatLossless,
+		HasAlpha: true,
+	},
+	tFeaturesTester{
+		Filename: "video-001.webp",
+		Width:    150,
+		Height:   103,
+		Format:   FormatLossy,
+		HasAlpha: false,
+	},
+	tFeaturesTester{
+		Filename: "yellow_rose.lossy-with-alpha.webp",
+		Width:    400,
+		Height:   301,
+		Format:   FormatLossy,
+		HasAlpha: true,
+	},
+}
+
+func TestDecodeYCbCr(t *testing.T) {
+	for _, filename := range []string{
+		"video-001.lossy.webp",
+		"yellow_rose.lossy.webp",
+		"yellow_rose.lossy-with-alpha.webp",
+	} {
+		data, err := os.ReadFile(testdataDir + filename)
+		tAssertNil(t, err, filename)
+
+		m0, err := DecodeYCbCr(data)
+		tAssertNil(t, err, filename)
+		m1, err := xwebp.Decode(bytes.NewReader(data))
+		tAssertNil(t, err, filename)
+
+		// x/image/webp returns the limited range samples
+		switch m1 := m1.(type) {
+		case *image.YCbCr:
+			m0, ok := m0.(*image.YCbCr)
+			tAssert(t, ok, filename)
+			expandYCbCr(m1)
+			tAssertYCbCrEQ(t, m1, m0, filename)
+		case *image.NYCbCrA:
+			m0, ok := m0.(*image.NYCbCrA)
+			tAssert(t, ok, filename)
+			expandYCbCr(&m1.YCbCr)
+			tAssertYCbCrEQ(t, &m1.YCbCr, &m0.YCbCr, filename)
+			tAssert(t, bytes.Equal(m1.A, m0.A), filename)
+		default:
+			t.Fatalf("%s: unexpected image type %T", filename, m1)
+		}
+
+		// the full range samples have the colors of the RGB decoder
+		m2, err := DecodeNRGBA(data)
+		tAssertNil(t, err, filename)
+		if got, want := averageDelta(m0, m2), 2; got > want {
+			t.Fatalf("%s: average delta too high; got %d, want <= %d", filename, got, want)
+		}
+	}
+}
+
+func tAssertYCbCrEQ(t *testing.T, expected, got *image.YCbCr, filename string) {
+	t.Helper()
+	tAssertEQ(t, expected.Rect, got.Rect, filename)
+	tAssertEQ(t, expected.SubsampleRatio, got.SubsampleRatio, filename)
+	b := expected.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if expected.YCbCrAt(x, y) != got.YCbCrAt(x, y) {
+				t.Fatalf("%s: (%d, %d): expected = %v, got = %v",
+					filename, x, y, expected.YCbCrAt(x, y), got.YCbCrAt(x, y),
+				)
+			}
+		}
+	}
+}
+
+func TestDecodeInto(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "1_webp_ll.webp")
+	tAssertNil(t, err)
+
+	rgba, err := DecodeRGBA(data)
+	tAssertNil(t, err)
+	nrgba, err := DecodeNRGBA(data)
+	tAssertNil(t, err)
+	rgb, err := DecodeRGB(data)
+	tAssertNil(t, err)
+
+	// larger than the image, with an offset origin
+	r := image.Rect(10, 20, 10+400+3, 20+301+5)
+	for _, dst := range []draw.Image{
+		image.NewRGBA(r),
+		image.NewNRGBA(r),
+		image.NewRGBA64(r),
+		NewRGBImage(r),
+	} {
+		tAssertNil(t, DecodeInto(data, dst), fmt.Sprintf("%T", dst))
+
+		m := dst.(interface {
+			SubImage(r image.Rectangle) image.Image
+		}).SubImage(image.Rect(10, 20, 10+400, 20+301))
+		var want image.Image = nrgba
+		if _, ok := dst.(*image.RGBA); ok {
+			want = rgba
+		}
+		if _, ok := dst.(*RGBImage); ok {
+			want = rgb
+		}
+		tAssertEQ(t, 0, averageDelta(tTranslate(want, r.Min), m), fmt.Sprintf("%T", dst))
+	}
+
+	tAssert(t, DecodeInto(data, image.NewRGBA(image.Rect(0, 0, 399, 301))) != nil)
+	tAssert(t, DecodeRGBAInto(data, make([]byte, 4*400*300), 4*400) != nil)
+}
+
+func TestDecodeRGBAToSize_premultiplied(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA{200, 100, 50, 128}), image.Point{}, draw.Src)
+	buf := new(bytes.Buffer)
+	tAssertNil(t, Encode(buf, src, &Options{Lossless: true}))
+	data := buf.Bytes()
+
+	// within the rounding of the rescaler of libwebp
+	tAssertNear := func(want, got []byte) {
+		t.Helper()
+		for i := range want {
+			tAssert(t, delta(uint32(want[i]), uint32(got[i])) <= 1, want, got)
+		}
+	}
+
+	m, err := DecodeRGBAToSize(data, 8, 8)
+	tAssertNil(t, err)
+	tAssertNear([]byte{100, 50, 25, 128}, m.Pix[m.PixOffset(4, 4):][:4])
+
+	// the C API keeps the straight RGBA of WebPDecodeRGBA
+	pix := make([]byte, 4*8*8)
+	res := C_webpDecodeRGBAToSize((*C_uint8_t)(&data[0]), C_size_t(len(data)), 8, 8, 4*8, (*C_uint8_t)(&pix[0]))
+	tAssertEQ(t, C_int(0), res)
+	tAssertNear([]byte{200, 100, 50, 128}, pix[4*(4*8+4):][:4])
+}
+
+func TestDecodeRGBAInto_allocs(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "1_webp_ll.webp")
+	tAssertNil(t, err)
+
+	m := image.NewRGBA(image.Rect(0, 0, 400, 301))
+	allocs := testing.AllocsPerRun(10, func() {
+		if err := DecodeRGBAInto(data, m.Pix, m.Stride); err != nil {
+			t.Fatal(err)
+		}
+	})
+	tAssertEQ(t, 0, allocs)
+}
+
+// tTranslate returns m with its origin moved to p.
+func tTranslate(m image.Image, p image.Point) image.Image {
+	b := m.Bounds()
+	dst := image.NewNRGBA(b.Add(p))
+	draw.Draw(dst, dst.Rect, m, b.Min, draw.Src)
+	return dst
+}
+
+func TestDecodeColorspace(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "1_webp_ll.webp")
+	tAssertNil(t, err)
+
+	m0, err := DecodeNRGBA(data)
+	tAssertNil(t, err)
+
+	for _, v := range []struct {
+		Mode     WEBP_CSP_MODE
+		Type     string
+		MaxDelta int
+	}{
+		{MODE_RGBA, "*image.NRGBA", 0},
+		{MODE_rgbA, "*image.RGBA", 1},
+		{MODE_BGRA, "*webp.BGRAImage", 0},
+		{MODE_bgrA, "*webp.BGRAImage", 1},
+		{MODE_ARGB, "*webp.ARGBImage", 0},
+		{MODE_Argb, "*webp.ARGBImage", 1},
+		{MODE_RGBA_4444, "*webp.RGBA4444Image", 8},
+		{MODE_rgbA_4444, "*webp.RGBA4444Image", 8},
+		{MODE_YUVA, "*image.NYCbCrA", -1},
+		{MODE_YUV, "*image.YCbCr", -1},
+	} {
+		m, err := DecodeColorspace(data, v.Mode)
+		tAssertNil(t, err, v.Mode)
+		tAssertEQ(t, v.Type, fmt.Sprintf("%T", m), v.Mode)
+		if v.MaxDelta < 0 {
+			continue
+		}
+		if got := averageDelta(m0, m); got > v.MaxDelta {
+			t.Fatalf("%d: average delta too high; got %d, want <= %d", v.Mode, got, v.MaxDelta)
+		}
+	}
+
+	// the opaque modes drop the alpha channel
+	m1, err := DecodeRGB(data)
+	tAssertNil(t, err)
+	for _, v := range []struct {
+		Mode     WEBP_CSP_MODE
+		Type     string
+		MaxDelta int
+	}{
+		{MODE_RGB, "*webp.RGBImage", 0},
+		{MODE_BGR, "*webp.BGRImage", 0},
+		{MODE_RGB_565, "*webp.RGB565Image", 4},
+	} {
+		m, err := DecodeColorspace(data, v.Mode)
+		tAssertNil(t, err, v.Mode)
+		tAssertEQ(t, v.Type, fmt.Sprintf("%T", m), v.Mode)
+		if got := averageDelta(m1, m); got > v.MaxDelta {
+			t.Fatalf("%d: average delta too high; got %d, want <= %d", v.Mode, got, v.MaxDelta)
+		}
+	}
+
+	_, err = DecodeColorspace(data, MODE_LAST)
+	tAssert(t, err != nil)
+}
+
+func TestEncode_bgra(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "1_webp_ll.webp")
+	tAssertNil(t, err)
+
+	for _, mode := range []WEBP_CSP_MODE{MODE_BGR, MODE_BGRA, MODE_bgrA, MODE_ARGB} {
+		m0, err := DecodeColorspace(data, mode)
+		tAssertNil(t, err, mode)
+
+		buf := new(bytes.Buffer)
+		tAssertNil(t, Encode(buf, m0, &Options{Lossless: true, Exact: true}), mode)
+		m1, err := Decode(buf)
+		tAssertNil(t, err, mode)
+		tAssertEQ(t, 0, averageDelta(m0, m1), mode)
+	}
+}
+
+func TestDistortion(t *testing.T) {
+	for i := 1; i <= 5; i++ {
+		filename := fmt.Sprintf("%d_webp_ll.png", i)
+		m, err := loadImage(filename)
+		tAssertNil(t, err, filename)
+
+		for _, metric := range []Metric{PSNR, SSIM, LSIM} {
+			d, err := Distortion(m, m, metric)
+			tAssertNil(t, err, filename, metric)
+			tAssertEQ(t, [5]float32{99, 99, 99, 99, 99}, d, filename, metric)
+		}
+
+		var last [5]float32
+		for _, quality := range []float32{10, 90} {
+			buf := new(bytes.Buffer)
+			tAssertNil(t, Encode(buf, m, &Options{Quality: quality}), filename)
+			m1, err := Decode(buf)
+			tAssertNil(t, err, filename)
+
+			d, err := Distortion(m, m1, PSNR)
+			tAssertNil(t, err, filename, quality)
+			tAssert(t, d[4] > 10 && d[4] < 99, filename, quality, d)
+			tAssert(t, d[4] > last[4], filename, quality, d, last)
+			last = d
+		}
+		tAssert(t, last[4] > 30, filename, last)
+	}
+
+	m := image.NewGray(image.Rect(0, 0, 8, 8))
+	_, err := Distortion(m, image.NewGray(image.Rect(0, 0, 8, 9)), PSNR)
+	tAssert(t, err != nil, "different sizes")
+	_, err = Distortion(m, m, Metric(3))
+	tAssert(t, err != nil, "unknown metric")
+}
+
+func TestEncodeAuto(t *testing.T) {
+	m, err := loadImage("2_webp_ll.png")
+	tAssertNil(t, err)
+
+	score := func(quality float32, metric Metric) float32 {
+		data, err := EncodeRGBA(m, quality)
+		tAssertNil(t, err, quality)
+		m1, err := DecodeRGBA(data)
+		tAssertNil(t, err, quality)
+		d, err := Distortion(m, m1, metric)
+		tAssertNil(t, err, quality)
+		return d[4]
+	}
+
+	for _, target := range []AutoTarget{{PSNR, 35}, {SSIM, 18}, {LSIM, 30}} {
+		data, quality, err := EncodeAuto(m, target, QualityBounds{})
+		tAssertNil(t, err, target)
+		m1, err := DecodeRGBA(data)
+		tAssertNil(t, err, target)
+		d, err := Distortion(m, m1, target.Metric)
+		tAssertNil(t, err, target)
+		tAssert(t, d[4] >= target.Value, target, quality, d)
+		if quality > 0 {
+			tAssert(t, score(quality-1, target.Metric) < target.Value, target, quality)
+		}
+	}
+
+	_, quality, err := EncodeAuto(m, AutoTarget{PSNR, 99}, QualityBounds{Min: 10, Max: 20})
+	tAssertNil(t, err)
+	tAssertEQ(t, float32(20), quality)
+
+	_, _, err = EncodeAuto(m, AutoTarget{PSNR, 30}, QualityBounds{Min: 50, Max: 40})
+	tAssert(t, err != nil, "invalid bounds")
+}
+
+func TestDecodeRegion(t *testing.T) {
+	for _, filename := range []string{"tux.lossless.webp", "yellow_rose.lossy-with-alpha.webp"} {
+		data, err := os.ReadFile(testdataDir + filename)
+		tAssertNil(t, err, filename)
+		full, err := DecodeNRGBA(data)
+		tAssertNil(t, err, filename)
+		lossless := strings.Contains(filename, "lossless")
+
+		for _, r := range []image.Rectangle{
+			image.Rect(0, 0, 32, 32),
+			image.Rect(16, 8, 64, 40),
+			image.Rect(7, 13, 30, 46), // odd offsets
+			full.Bounds(),
+		} {
+			m, err := DecodeRegion(data, r, 1)
+			tAssertNil(t, err, filename, r)
+			tAssertEQ(t, image.Rect(0, 0, r.Dx(), r.Dy()), m.Bounds(), filename, r)
+			want := full.SubImage(r)
+			if lossless {
+				for y := 0; y < r.Dy(); y++ {
+					for x := 0; x < r.Dx(); x++ {
+						tAssertEQ(t, want.At(r.Min.X+x, r.Min.Y+y), m.At(x, y), filename, r, x, y)
+					}
+				}
+			} else {
+				// the fancy upsampling of the chroma differs at the borders
+				moved := &image.NRGBA{Pix: m.Pix, Stride: m.Stride, Rect: m.Rect.Add(r.Min)}
+				d := averageDelta(want, moved)
+				tAssert(t, d < 2, filename, r, d)
+			}
+
+			// scaled
+			m, err = DecodeRegion(data, r, 0.5)
+			tAssertNil(t, err, filename, r)
+			tAssertEQ(t, image.Rect(0, 0, (r.Dx()+1)/2, (r.Dy()+1)/2), m.Bounds(), filename, r)
+		}
+	}
+
+	data, err := os.ReadFile(testdataDir + "tux.lossless.webp")
+	tAssertNil(t, err)
+	_, err = DecodeRegion(data, image.Rect(0, 0, 1000, 10), 1)
+	tAssert(t, err != nil)
+	_, err = DecodeRegion(data, image.Rect(0, 0, 10, 10), 0)
+	tAssert(t, err != nil)
+
+	// not keeping the aspect ratio
+	p, err := NewRegionDecoder(data)
+	tAssertNil(t, err)
+	m, err := p.DecodeRegionToSize(image.Rect(10, 20, 110, 70), 30, 40)
+	tAssertNil(t, err)
+	tAssertEQ(t, image.Rect(0, 0, 30, 40), m.Bounds())
+	_, err = p.DecodeRegionToSize(image.Rect(10, 20, 110, 70), 0, 40)
+	tAssert(t, err != nil)
+	_, err = NewRegionDecoderWithOptions(data, &DecoderOptions{DitheringStrength: 101})
+	tAssert(t, err != nil)
+}
+
+func TestRegionDecoder_concurrent(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "yellow_rose.lossless.webp")
+	tAssertNil(t, err)
+	p, err := NewRegionDecoder(data)
+	tAssertNil(t, err)
+
+	// the tiles decoded by several goroutines, against the serial decodes
+	const tile = 64
+	var tiles []image.Rectangle
+	b := p.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y += tile {
+		for x := b.Min.X; x < b.Max.X; x += tile {
+			tiles = append(tiles, image.Rect(x, y, x+tile, y+tile).Intersect(b))
+		}
+	}
+	want := make([]*image.NRGBA, len(tiles))
+	for i, r := range tiles {
+		want[i], err = p.DecodeRegion(r, 0.5)
+		tAssertNil(t, err, r)
+	}
+
+	got := make([]*image.NRGBA, len(tiles))
+	errs := make([]error, len(tiles))
+	var wg sync.WaitGroup
+	for i, r := range tiles {
+		wg.Add(1)
+		go func(i int, r image.Rectangle) {
+			defer wg.Done()
+			got[i], errs[i] = p.DecodeRegion(r, 0.5)
+		}(i, r)
+	}
+	wg.Wait()
+	for i := range tiles {
+		tAssertNil(t, errs[i], tiles[i])
+		tAssert(t, bytes.Equal(got[i].Pix, want[i].Pix), tiles[i])
+	}
+}
+
+// tAnimFrame is a frame of tEncodeAnimation.
+type tAnimFrame struct {
+	Image    image.Image
+	X, Y     int // even
+	Duration int
+}
+
+// tEncodeAnimation assembles an animation of the lossless frames on a
+// width x height canvas, in the chunks of the container specification.
+func tEncodeAnimation(t *testing.T, width, height, loopCount int, frames []tAnimFrame) []byte {
+	t.Helper()
+	le24 := func(p []byte, v int) []byte { return append(p, byte(v), byte(v>>8), byte(v>>16)) }
+	chunk := func(p []byte, fourcc string, data []byte) []byte {
+		p = append(p, fourcc...)
+		p = append(p, byte(len(data)), byte(len(data)>>8), byte(len(data)>>16), byte(len(data)>>24))
+		p = append(p, data...)
+		if len(data)%2 == 1 {
+			p = append(p, 0)
+		}
+		return p
+	}
+
+	vp8x := le24(le24([]byte{0x02 | 0x10, 0, 0, 0}, width-1), height-1)
+	body := chunk([]byte("WEBP"), "VP8X", vp8x)
+	body = chunk(body, "ANIM", []byte{0, 0, 0, 0, byte(loopCount), byte(loopCount >> 8)})
+	for _, f := range frames {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, f.Image, &Options{Lossless: true}))
+		b := f.Image.Bounds()
+		anmf := le24(le24(le24(le24(le24(nil, f.X/2), f.Y/2), b.Dx()-1), b.Dy()-1), f.Duration)
+		anmf = append(anmf, 0)                   // alpha-blending, no disposal
+		anmf = append(anmf, buf.Bytes()[12:]...) // the VP8L chunk
+		body = chunk(body, "ANMF", anmf)
+	}
+	return chunk(nil, "RIFF", body)
+}
+
+func TestDecodeAnimFrames(t *testing.T) {
+	red := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	draw.Draw(red, red.Bounds(), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	blue := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(blue, blue.Bounds(), image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	data := tEncodeAnimation(t, 64, 48, 3, []tAnimFrame{
+		{Image: red, Duration: 100},
+		{Image: blue, X: 8, Y: 10, Duration: 250},
+		{Image: blue, X: 40, Y: 0, Duration: 50},
+	})
+
+	tAssert(t, IsAnimated(data))
+	info, err := GetAnimInfo(data)
+	tAssertNil(t, err)
+	tAssertEQ(t, AnimInfo{CanvasWidth: 64, CanvasHeight: 48, FrameCount: 3, LoopCount: 3}, *info)
+
+	// the frames are composited on the canvas, each image has its size
+	frames, err := DecodeAnimFrames(data)
+	tAssertNil(t, err)
+	tAssertEQ(t, 3, len(frames))
+	for i, f := range frames {
+		tAssertEQ(t, image.Rect(0, 0, 64, 48), f.Image.Bounds(), i)
+		tAssertEQ(t, color.RGBA{255, 0, 0, 255}, f.Image.At(0, 0), i)
+	}
+	tAssertEQ(t, color.RGBA{255, 0, 0, 255}, frames[0].Image.At(10, 12))
+	tAssertEQ(t, color.RGBA{0, 0, 255, 255}, frames[1].Image.At(10, 12))
+	tAssertEQ(t, color.RGBA{0, 0, 255, 255}, frames[1].Image.At(23, 25))
+	tAssertEQ(t, color.RGBA{255, 0, 0, 255}, frames[1].Image.At(24, 26))
+	tAssertEQ(t, color.RGBA{0, 0, 255, 255}, frames[2].Image.At(10, 12))
+	tAssertEQ(t, color.RGBA{0, 0, 255, 255}, frames[2].Image.At(40, 0))
+
+	// the timestamps are the starts of the frames, the sums of the durations
+	var timing [][2]int
+	for _, f := range frames {
+		timing = append(timing, [2]int{f.Timestamp, f.Duration})
+	}
+	tAssertEQ(t, [][2]int{{0, 100}, {100, 250}, {350, 50}}, timing)
+}
+
+func TestMuxAnimation(t *testing.T) {
+	var red, blue bytes.Buffer
+	m := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	draw.Draw(m, m.Bounds(), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	tAssertNil(t, Encode(&red, m, &Options{Lossless: true}))
+	m = image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(m, m.Bounds(), image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	tAssertNil(t, Encode(&blue, m, &Options{Lossless: true}))
+
+	data, err := MuxAnimation([]*AnimFrame{
+		{Data: red.Bytes(), Duration: 100},
+		{Data: blue.Bytes(), X: 8, Y: 10, Duration: 250, NoBlend: true},
+	}, &AnimOptions{LoopCount: 2, BackgroundColor: color.NRGBA{1, 2, 3, 4}})
+	tAssertNil(t, err)
+	features, err := Features(data)
+	tAssertNil(t, err)
+	tAssert(t, features.HasAnimation)
+	tAssertEQ(t, [4]int{64, 48, 2, 2}, [4]int{features.CanvasWidth, features.CanvasHeight, features.FrameCount, features.LoopCount})
+	tAssertEQ(t, color.NRGBA{1, 2, 3, 4}, features.BackgroundColor)
+
+	frames, err := DecodeAnimFrames(data)
+	tAssertNil(t, err)
+	tAssertEQ(t, 2, len(frames))
+	tAssertEQ(t, color.RGBA{0, 0, 255, 255}, frames[1].Image.At(10, 12))
+	tAssertEQ(t, 250, frames[1].Duration)
+
+	// the frames are returned as they are stored
+	frame, err := GetAnimFrame(data, 1)
+	tAssertNil(t, err)
+	width, height, _, err := GetInfo(frame.Data)
+	frame.Data = nil
+	tAssertEQ(t, AnimFrame{X: 8, Y: 10, Duration: 250, NoBlend: true}, *frame)
+	tAssertNil(t, err)
+	tAssertEQ(t, [2]int{16, 16}, [2]int{width, height})
+	_, err = GetAnimFrame(data, 2)
+	tAssert(t, err != nil)
+
+	data, err = SetLoopCount(data, 0)
+	tAssertNil(t, err)
+	info, err := GetAnimInfo(data)
+	tAssertNil(t, err)
+	tAssertEQ(t, 0, info.LoopCount)
+
+	// a still image has no loop count, and a single frame
+	_, err = SetLoopCount(red.Bytes(), 1)
+	tAssert(t, err != nil)
+	frame, err = GetAnimFrame(red.Bytes(), 0)
+	tAssertNil(t, err)
+	width, height, _, err = GetInfo(frame.Data)
+	tAssertNil(t, err)
+	tAssertEQ(t, [2]int{64, 48}, [2]int{width, height})
+
+	// the offsets are stored halved
+	_, err = MuxAnimation([]*AnimFrame{{Data: blue.Bytes(), X: 1}}, nil)
+	tAssert(t, err != nil)
+	_, err = MuxAnimation(nil, nil)
+	tAssert(t, err != nil)
+}
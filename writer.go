@@ -8,6 +8,8 @@
 package webp
 
 import (
+	"bytes"
+	"errors"
 	"image"
 	"image/color"
 	"io"
@@ -17,11 +19,81 @@ import (
 
 const DefaulQuality = 90
 
+// The levels of Options.LosslessLevel, the levels of
+// WebPConfigLosslessPreset.
+const (
+	FastestLosslessLevel = 0
+	DefaultLosslessLevel = 6 // the level of cwebp, and of a nil LosslessLevel
+	BestLosslessLevel    = 9
+)
+
 // Options are the encoding parameters.
 type Options struct {
 	Lossless bool
 	Quality  float32 // 0 ~ 100
 	Exact    bool    // Preserve RGB values in transparent area.
+	Dither   Dither  // Reduction of the 16-bit images to 8-bit.
+
+	// LosslessLevel is the compression level of the lossless encoder, the
+	// level 0 (fastest) ~ 9 (slowest, smallest) of WebPConfigLosslessPreset.
+	// nil is DefaultLosslessLevel. It is the same for the gray, RGB and
+	// RGBA images.
+	LosslessLevel *int
+
+	// UseDeltaPalette enables the delta palette of the lossless encoder,
+	// which is reserved by libwebp 1.4.0 and has no effect yet.
+	UseDeltaPalette bool
+
+	// ExactAlpha keeps the alpha values of the lossless encoder exact: the
+	// near-lossless preprocessing, which changes all the channels, is
+	// skipped for the pictures with transparency. The lossy encoder always
+	// compresses the alpha plane losslessly.
+	ExactAlpha bool
+
+	// Quantize reduces the colors of the image to a palette before the
+	// lossless encoding, which then uses its color-indexing transform. It
+	// needs Lossless, except with ModeAuto, where it applies only if the
+	// lossless format is encoded.
+	Quantize *QuantizeOptions
+
+	// Crop is the part of the image to encode, in the image coordinates.
+	// It is cropped by libwebp before the rescaling, the empty rectangle
+	// keeps the whole image.
+	Crop image.Rectangle
+
+	// Resize rescales the (cropped) picture in libwebp before encoding.
+	// No gamma correction is applied, use the transform package for a
+	// resampling in linear light.
+	Resize *ResizeOptions
+
+	// Preset tunes the lossy encoder for the content of the image.
+	Preset WebPPreset
+
+	// NearLossless is the strength of the near-lossless preprocessing of
+	// the lossless encoder, from 0 (off) to 100 (the strongest), which is
+	// 100 - the level of cwebp -near_lossless.
+	NearLossless int
+
+	// UseSharpYUV uses the slower and sharper RGB to YUV conversion of the
+	// lossy encoder, for crisper chroma edges. The YCbCr images are not
+	// converted.
+	UseSharpYUV bool
+
+	// Mode ModeAuto chooses Lossless, the near-lossless preprocessing (if
+	// NearLossless is 0) and Preset from the color count, the edges, the
+	// flat areas and the alpha of the image. With Trial, both the lossless
+	// and the lossy candidates are encoded and the smaller one whose PSNR
+	// reaches MinPSNR (40 dB if 0) is kept. Trial is ignored with Resize.
+	Mode    Mode
+	Trial   bool
+	MinPSNR float32
+}
+
+// ResizeOptions is the size of Options.Resize.
+type ResizeOptions struct {
+	Width  int  // 0 is computed from Height, keeping the aspect ratio
+	Height int  // 0 is computed from Width, keeping the aspect ratio
+	Fit    bool // keep the aspect ratio, fitting in Width x Height
 }
 
 type colorModeler interface {
@@ -39,62 +111,190 @@ func Save(name string, m image.Image, opt *Options) (err error) {
 }
 
 // Encode writes the image m to w in WEBP format.
+//
+// libwebp holds the whole compressed bitstream until the frame is encoded,
+// then it is written to w without the copy in a memory buffer and in Go:
+// nothing is written to w before the end of the encode. An error of w
+// aborts the writing and is returned, part of the output may have been
+// written. With ModeAuto and Trial, the candidates are encoded in memory
+// and the best one is written.
 func Encode(w io.Writer, m image.Image, opt *Options) (err error) {
 	return encode(w, m, opt)
 }
 
 func encode(w io.Writer, m image.Image, opt *Options) (err error) {
-	var output []byte
-	if opt != nil && opt.Lossless {
-		switch m := adjustImage(m).(type) {
-		case *image.Gray:
-			if output, err = EncodeLosslessGray(m); err != nil {
-				return
-			}
-		case *RGBImage:
-			if output, err = EncodeLosslessRGB(m); err != nil {
-				return
-			}
-		case *image.RGBA:
-			if opt.Exact {
-				output, err = EncodeExactLosslessRGBA(m)
-			} else {
-				output, err = EncodeLosslessRGBA(m)
-			}
-			if err != nil {
-				return
+	config, err := opt.encoderOptions(m.Bounds())
+	if err != nil {
+		return
+	}
+	if opt != nil && opt.Mode == ModeAuto {
+		return encodeAuto(w, m, opt, config)
+	}
+	return encodeWith(w, m, config, opt)
+}
+
+// encoderOptions returns the libwebp parameters of opt, for an image of
+// bounds b.
+func (opt *Options) encoderOptions(b image.Rectangle) (config *encoderOptions, err error) {
+	config = &encoderOptions{Quality: DefaulQuality}
+	if opt != nil {
+		config.Lossless = opt.Lossless
+		config.Quality = opt.Quality
+		config.Exact = opt.Exact
+		config.Preset = opt.Preset
+		config.NearLossless = opt.NearLossless
+		config.UseSharpYUV = opt.UseSharpYUV
+		config.UseDeltaPalette = opt.UseDeltaPalette
+		config.ExactAlpha = opt.ExactAlpha
+		if config.LosslessLevel, err = opt.losslessLevel(); err != nil {
+			return
+		}
+		if opt.NearLossless < 0 || opt.NearLossless > 100 {
+			return nil, errors.New("webp: Encode, invalid near-lossless strength")
+		}
+		if opt.Quantize != nil && !opt.Lossless && opt.Mode != ModeAuto {
+			return nil, errors.New("webp: Encode, Quantize needs Lossless")
+		}
+		if err = opt.setGeometry(config, b); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// encodeWith adjusts m for the format of config, and encodes it to w.
+func encodeWith(w io.Writer, m image.Image, config *encoderOptions, opt *Options) error {
+	var dither Dither
+	var quantize *QuantizeOptions
+	if opt != nil {
+		dither, quantize = opt.Dither, opt.Quantize
+	}
+	if config.Lossless {
+		m = adjustImage(m, dither)
+		if quantize != nil {
+			if p, ok := quantizeImage(m, quantize); ok {
+				m = p
 			}
-		default:
-			panic("image/webp: Encode, unreachable!")
 		}
 	} else {
-		quality := float32(DefaulQuality)
-		if opt != nil {
-			quality = opt.Quality
+		m = adjustLossyImage(m, dither)
+	}
+	return encodeImage(w, m, config)
+}
+
+// encodeAuto encodes m in the format chosen by the analysis of the image,
+// or in the smaller of the trial encodes.
+func encodeAuto(w io.Writer, m image.Image, opt *Options, config *encoderOptions) error {
+	ref := m
+	if !config.Crop.Empty() {
+		ref = toNRGBAImage(m).SubImage(config.Crop.Add(m.Bounds().Min))
+	}
+
+	choice := analyzeImage(ref).choose()
+	chosen := *config
+	chosen.Lossless = choice.Lossless
+	if chosen.NearLossless == 0 {
+		chosen.NearLossless = choice.NearLossless
+	}
+	chosen.Preset = choice.Preset
+	if !opt.Trial || opt.Resize != nil {
+		return encodeWith(w, m, &chosen, opt)
+	}
+
+	// the other format, with the same preset
+	other := chosen
+	other.Lossless = !chosen.Lossless
+	other.NearLossless = 0
+
+	minPSNR := opt.MinPSNR
+	if minPSNR == 0 {
+		minPSNR = 40
+	}
+
+	// the smaller passing candidate, or the more faithful one
+	var best []byte
+	var bestPSNR float32
+	for _, c := range []*encoderOptions{&chosen, &other} {
+		buf := new(bytes.Buffer)
+		if err := encodeWith(buf, m, c, opt); err != nil {
+			return err
 		}
+		data := buf.Bytes()
+		dist, err := DecodeRGBA(data)
+		if err != nil {
+			return err
+		}
+		psnr, err := Distortion(ref, dist, PSNR)
+		if err != nil {
+			return err
+		}
+		switch pass, bestPass := psnr[4] >= minPSNR, bestPSNR >= minPSNR; {
+		case best == nil,
+			pass && !bestPass,
+			pass && bestPass && len(data) < len(best),
+			!pass && !bestPass && psnr[4] > bestPSNR:
+			best, bestPSNR = data, psnr[4]
+		}
+	}
+	_, err := w.Write(best)
+	return err
+}
 
-		switch m := adjustImage(m).(type) {
-		case *image.Gray:
-			if output, err = EncodeGray(m, quality); err != nil {
-				return
-			}
-		case *RGBImage:
-			if output, err = EncodeRGB(m, quality); err != nil {
-				return
-			}
-		case *image.RGBA:
-			if output, err = EncodeRGBA(m, quality); err != nil {
-				return
+// losslessLevel returns the level of WebPConfigLosslessPreset.
+func (opt *Options) losslessLevel() (int, error) {
+	if opt.LosslessLevel == nil {
+		return DefaultLosslessLevel, nil
+	}
+	if level := *opt.LosslessLevel; level >= FastestLosslessLevel && level <= BestLosslessLevel {
+		return level, nil
+	}
+	return 0, errors.New("webp: Encode, invalid lossless level")
+}
+
+// setGeometry sets the crop rectangle, relative to the picture, and the
+// rescaled size of config, for an image of bounds b.
+func (opt *Options) setGeometry(config *encoderOptions, b image.Rectangle) error {
+	size := b.Size()
+	if !opt.Crop.Empty() {
+		if !opt.Crop.In(b) {
+			return errors.New("webp: Encode, crop rectangle is outside of the image")
+		}
+		config.Crop = opt.Crop.Sub(b.Min)
+		size = opt.Crop.Size()
+	}
+
+	if r := opt.Resize; r != nil {
+		if r.Width < 0 || r.Height < 0 || r.Width == 0 && r.Height == 0 {
+			return errors.New("webp: Encode, invalid resize size")
+		}
+		config.ResizeWidth, config.ResizeHeight = r.Width, r.Height
+		if r.Fit && r.Width > 0 && r.Height > 0 {
+			// libwebp computes the other side from the aspect ratio.
+			if r.Width*size.Y > r.Height*size.X {
+				config.ResizeWidth = 0
+			} else {
+				config.ResizeHeight = 0
 			}
-		default:
-			panic("image/webp: Encode, unreachable!")
 		}
 	}
-	_, err = w.Write(output)
-	return
+	return nil
 }
 
-func adjustImage(m image.Image) image.Image {
+func adjustImage(m image.Image, dither Dither) image.Image {
+	if m, ok := reduceDepth(m, dither); ok {
+		return m
+	}
+
+	// MemP, but not in RGB order
+	switch m := m.(type) {
+	case *BGRImage:
+		return NewRGBImageFrom(m)
+	case *BGRAImage:
+		return toNRGBAImage(m)
+	case *ARGBImage:
+		return toNRGBAImage(m)
+	}
+
 	if p, ok := AsMemPImage(m); ok {
 		switch {
 		case p.XChannels == 1 && p.XDataType == reflect.Uint8:
@@ -140,47 +340,90 @@ func adjustImage(m image.Image) image.Image {
 	case *image.RGBA64:
 		return toRGBAImage(m)
 	case *image.NRGBA:
-		return toRGBAImage(m)
+		return m
 	case *image.NRGBA64:
-		return toRGBAImage(m)
+		return toNRGBAImage(m)
 
 	default:
-		return toRGBAImage(m)
+		return toNRGBAImage(m)
+	}
+}
+
+// adjustLossyImage is adjustImage, but keeps the YCbCr images which the
+// lossy encoder imports without a RGB round-trip.
+func adjustLossyImage(m image.Image, dither Dither) image.Image {
+	switch m := m.(type) {
+	case *image.YCbCr:
+		return m
+	case *image.NYCbCrA:
+		return m
 	}
+	return adjustImage(m, dither)
 }
 
 func toGrayImage(m image.Image) *image.Gray {
 	if m, ok := m.(*image.Gray); ok {
 		return m
 	}
-	b := m.Bounds()
-	gray := image.NewGray(b)
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			c := color.GrayModel.Convert(m.At(x, y)).(color.Gray)
-			gray.SetGray(x, y, c)
-		}
-	}
-	return gray
+	return convertToGray(m)
 }
 
 func toRGBAImage(m image.Image) *image.RGBA {
 	if m, ok := m.(*image.RGBA); ok {
 		return m
 	}
-	b := m.Bounds()
-	rgba := image.NewRGBA(b)
-	dstColorRGBA64 := &color.RGBA64{}
-	dstColor := color.Color(dstColorRGBA64)
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			pr, pg, pb, pa := m.At(x, y).RGBA()
-			dstColorRGBA64.R = uint16(pr)
-			dstColorRGBA64.G = uint16(pg)
-			dstColorRGBA64.B = uint16(pb)
-			dstColorRGBA64.A = uint16(pa)
-			rgba.Set(x, y, dstColor)
-		}
-	}
-	return rgba
+	return convertToRGBA(m)
+}
+
+// toNRGBAImage converts m to the straight alpha expected by the encoder.
+func toNRGBAImage(m image.Image) *image.NRGBA {
+	switch m := m.(type) {
+	case *image.NRGBA:
+		return m
+	case *image.RGBA:
+		if m.Opaque() {
+			return &image.NRGBA{
+				Pix:    m.Pix,
+				Stride: m.Stride,
+				Rect:   m.Rect,
+			}
+		}
+		b := m.Bounds()
+		nrgba := image.NewNRGBA(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			src := m.Pix[m.PixOffset(b.Min.X, y):][:4*b.Dx()]
+			dst := nrgba.Pix[nrgba.PixOffset(b.Min.X, y):][:4*b.Dx()]
+			unpremultiplyRow(dst, src)
+		}
+		return nrgba
+	}
+	return convertToNRGBA(m)
+}
+
+// unpremultiplyRow converts the RGBA row src to the NRGBA row dst.
+func unpremultiplyRow(dst, src []byte) {
+	for i := 0; i < len(src); i += 4 {
+		a := uint32(src[i+3])
+		switch a {
+		case 0xff:
+			copy(dst[i:i+4], src[i:i+4])
+		case 0:
+			dst[i+0], dst[i+1], dst[i+2], dst[i+3] = 0, 0, 0, 0
+		default:
+			// rounded, so that the premultiplication of the decoder
+			// gives back the original values.
+			dst[i+0] = unpremultiply(src[i+0], a)
+			dst[i+1] = unpremultiply(src[i+1], a)
+			dst[i+2] = unpremultiply(src[i+2], a)
+			dst[i+3] = uint8(a)
+		}
+	}
+}
+
+func unpremultiply(c uint8, a uint32) uint8 {
+	v := (uint32(c)*0xff + a/2) / a
+	if v > 0xff {
+		return 0xff
+	}
+	return uint8(v)
 }
@@ -6,7 +6,17 @@ package webp
 
 import (
 	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
 	_ "image/png"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -99,8 +109,802 @@ func TestEncode(t *testing.T) {
 		if got > want {
 			t.Fatalf("%d: average delta too high; got %d, want <= %d", i, got, want)
 		}
+
+		// The RGB values in transparent area are only visible with straight alpha.
+		if v.Lossless {
+			got = averageStraightDelta(img0, img1)
+		}
+		if v.Lossless && v.Exact && got != 0 {
+			t.Fatalf("%d: transparent area changed; got %d, want 0", i, got)
+		}
 		if v.MinDelta > 0 && got < v.MinDelta {
 			t.Fatalf("%d: average delta too low; got %d; want >= %d", i, got, v.MinDelta)
 		}
 	}
 }
+
+// averageStraightDelta is averageDelta for the non-premultiplied values,
+// including the RGB values in transparent area.
+func averageStraightDelta(m0, m1 image.Image) int {
+	p0, p1 := toNRGBAImage(m0), toNRGBAImage(m1)
+	b := p0.Bounds()
+	var sum, n int64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c0 := p0.Pix[p0.PixOffset(x, y):][:4]
+			c1 := p1.Pix[p1.PixOffset(x, y):][:4]
+			for i := 0; i < 4; i++ {
+				sum += delta(uint32(c0[i]), uint32(c1[i]))
+				n++
+			}
+		}
+	}
+	return int(sum / n)
+}
+
+func TestEncode_premultipliedAlpha(t *testing.T) {
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		copy(nrgba.Pix[i:], []uint8{0xff, 0x80, 0x40, 0x80})
+	}
+	rgba := image.NewRGBA(nrgba.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), nrgba, image.Point{}, draw.Src)
+
+	data, err := EncodeLosslessRGBA(nrgba)
+	tAssertNil(t, err)
+	m1, err := DecodeNRGBA(data)
+	tAssertNil(t, err)
+	tAssertEQ(t, nrgba.NRGBAAt(0, 0), m1.NRGBAAt(0, 0))
+
+	for _, m := range []image.Image{nrgba, rgba} {
+		data, err := EncodeLosslessRGBA(m)
+		tAssertNil(t, err)
+		m2, err := DecodeRGBA(data)
+		tAssertNil(t, err)
+		tAssertEQ(t, rgba.RGBAAt(0, 0), m2.RGBAAt(0, 0), fmt.Sprintf("%T", m))
+	}
+}
+
+func TestEncodeYCbCr(t *testing.T) {
+	img0, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio444,
+	} {
+		m := tNewYCbCrFrom(img0, ratio)
+		data, err := EncodeYCbCr(m, 90)
+		if err != nil {
+			t.Fatalf("%v: %v", ratio, err)
+		}
+		img1, err := DecodeRGBA(data)
+		if err != nil {
+			t.Fatalf("%v: %v", ratio, err)
+		}
+		if got, want := averageDelta(m, img1), 5; got > want {
+			t.Fatalf("%v: average delta too high; got %d, want <= %d", ratio, got, want)
+		}
+	}
+}
+
+func TestEncodeYCbCr_alpha(t *testing.T) {
+	img0, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &image.NYCbCrA{YCbCr: *tNewYCbCrFrom(img0, image.YCbCrSubsampleRatio420)}
+	m.A = make([]uint8, len(m.Y))
+	m.AStride = m.YStride
+	for i := range m.A {
+		m.A[i] = uint8(i)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, m, &Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	img1, err := DecodeYCbCr(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, ok := img1.(*image.NYCbCrA)
+	if !ok {
+		t.Fatalf("unexpected image type %T", img1)
+	}
+	tAssert(t, bytes.Equal(m.A, m1.A), "alpha plane mismatch")
+}
+
+func TestEncodeYCbCr_generations(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "video-001.lossy.webp")
+	tAssertNil(t, err)
+	meanY := func(m image.Image) float64 {
+		var sum int
+		p := m.(*image.YCbCr)
+		for _, v := range p.Y {
+			sum += int(v)
+		}
+		return float64(sum) / float64(len(p.Y))
+	}
+
+	m, err := DecodeWithOptions(bytes.NewReader(data), &DecoderOptions{PreferYCbCr: true})
+	tAssertNil(t, err)
+	want := meanY(m)
+	for i := 0; i < 5; i++ {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, m, &Options{Quality: 90}), i)
+		m, err = DecodeWithOptions(&buf, &DecoderOptions{PreferYCbCr: true})
+		tAssertNil(t, err, i)
+		if got := meanY(m); math.Abs(got-want) > 0.5 {
+			t.Fatalf("generation %d: mean luma %.2f, want %.2f", i+1, got, want)
+		}
+	}
+}
+
+func tNewYCbCrFrom(m image.Image, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	b := m.Bounds()
+	p := image.NewYCbCr(b, ratio)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, b, _ := m.At(x, y).RGBA()
+			yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			p.Y[p.YOffset(x, y)] = yy
+			p.Cb[p.COffset(x, y)] = cb
+			p.Cr[p.COffset(x, y)] = cr
+		}
+	}
+	return p
+}
+
+type tConvertTester struct {
+	Name  string
+	Image image.Image
+}
+
+// tConvertTesterList returns images of the types with a row kernel, large
+// enough to be converted concurrently, and with an odd origin.
+func tConvertTesterList() []tConvertTester {
+	r := rand.New(rand.NewSource(1))
+	b := image.Rect(0, 0, 301, 283)
+	sub := image.Rect(3, 5, 300, 280)
+	fill := func(pix []byte) {
+		r.Read(pix)
+	}
+
+	rgba := image.NewRGBA(b)
+	fill(rgba.Pix)
+	rgba64 := image.NewRGBA64(b)
+	fill(rgba64.Pix)
+	nrgba := image.NewNRGBA(b)
+	fill(nrgba.Pix)
+	nrgba64 := image.NewNRGBA64(b)
+	fill(nrgba64.Pix)
+	gray := image.NewGray(b)
+	fill(gray.Pix)
+	gray16 := image.NewGray16(b)
+	fill(gray16.Pix)
+	ycbcr420 := image.NewYCbCr(b, image.YCbCrSubsampleRatio420)
+	fill(ycbcr420.Y)
+	fill(ycbcr420.Cb)
+	fill(ycbcr420.Cr)
+	ycbcr444 := image.NewYCbCr(b, image.YCbCrSubsampleRatio444)
+	fill(ycbcr444.Y)
+	fill(ycbcr444.Cb)
+	fill(ycbcr444.Cr)
+	nycbcra := image.NewNYCbCrA(b, image.YCbCrSubsampleRatio422)
+	fill(nycbcra.Y)
+	fill(nycbcra.Cb)
+	fill(nycbcra.Cr)
+	fill(nycbcra.A)
+	var palette color.Palette
+	for i := 0; i < 256; i++ {
+		palette = append(palette, color.NRGBA{uint8(i), uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256))})
+	}
+	paletted := image.NewPaletted(b, palette)
+	fill(paletted.Pix)
+	cmyk := image.NewCMYK(b)
+	fill(cmyk.Pix)
+	rgb := NewRGBImage(b)
+	fill(rgb.XPix)
+	bgr := NewBGRImage(b)
+	fill(bgr.XPix)
+	bgra := NewBGRAImage(b)
+	fill(bgra.XPix)
+	argb := NewARGBImage(b)
+	fill(argb.XPix)
+	argb.XPremultiplied = true
+	memp16g := NewMemPImage(b, 1, reflect.Uint16)
+	fill(memp16g.XPix)
+	memp16rgb := NewMemPImage(b, 3, reflect.Uint16)
+	fill(memp16rgb.XPix)
+	memp16rgba := NewMemPImage(b, 4, reflect.Uint16)
+	fill(memp16rgba.XPix)
+	memp2 := NewMemPImage(b, 2, reflect.Uint8)
+	fill(memp2.XPix)
+
+	return []tConvertTester{
+		{"RGBA", rgba.SubImage(sub)},
+		{"RGBA64", rgba64.SubImage(sub)},
+		{"NRGBA", nrgba.SubImage(sub)},
+		{"NRGBA64", nrgba64.SubImage(sub)},
+		{"Gray", gray.SubImage(sub)},
+		{"Gray16", gray16.SubImage(sub)},
+		{"YCbCr420", ycbcr420.SubImage(sub)},
+		{"YCbCr444", ycbcr444.SubImage(sub)},
+		{"NYCbCrA422", nycbcra.SubImage(sub)},
+		{"Paletted", paletted.SubImage(sub)},
+		{"CMYK", cmyk.SubImage(sub)},
+		{"RGBImage", rgb.SubImage(sub)},
+		{"BGRImage", bgr.SubImage(sub)},
+		{"BGRAImage", bgra.SubImage(sub)},
+		{"ARGBImage", argb.SubImage(sub)},
+		{"MemP16Gray", memp16g.SubImage(sub)},
+		{"MemP16RGB", memp16rgb.SubImage(sub)},
+		{"MemP16RGBA", memp16rgba.SubImage(sub)},
+		{"MemP2", memp2.SubImage(sub)},
+		{"Generic", struct{ image.Image }{nrgba.SubImage(sub)}},
+	}
+}
+
+func TestConvert(t *testing.T) {
+	for _, v := range tConvertTesterList() {
+		m := v.Image
+		b := m.Bounds()
+		gray := convertToGray(m)
+		rgba := convertToRGBA(m)
+		nrgba := convertToNRGBA(m)
+		rgb := convertToRGB(m)
+		tAssert(t, gray.Bounds() == b && rgba.Bounds() == b && nrgba.Bounds() == b && rgb.Bounds() == b, v.Name)
+
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := m.At(x, y)
+				pr, pg, pb, _ := c.RGBA()
+				if got, want := gray.GrayAt(x, y), color.GrayModel.Convert(c); got != want {
+					t.Fatalf("%s: Gray(%d, %d): got = %v, want = %v", v.Name, x, y, got, want)
+				}
+				if got, want := rgba.RGBAAt(x, y), color.RGBAModel.Convert(c); got != want {
+					t.Fatalf("%s: RGBA(%d, %d): got = %v, want = %v", v.Name, x, y, got, want)
+				}
+				if got, want := nrgba.NRGBAAt(x, y), color.NRGBAModel.Convert(c); got != want {
+					t.Fatalf("%s: NRGBA(%d, %d): got = %v, want = %v", v.Name, x, y, got, want)
+				}
+				if got, want := rgb.RGBAt(x, y), [3]uint8{uint8(pr >> 8), uint8(pg >> 8), uint8(pb >> 8)}; got != want {
+					t.Fatalf("%s: RGB(%d, %d): got = %v, want = %v", v.Name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+type tDitherTester struct {
+	Dither Dither
+	Mean   float64 // of a 16-bit flat area at 100.5 in 8-bit units
+	Values []uint8
+}
+
+var tDitherTesterList = []tDitherTester{
+	{DitherTruncate, 100, []uint8{100}},
+	{DitherRound, 101, []uint8{101}},
+	{DitherOrdered, 100.5, []uint8{100, 101}},
+	{DitherFloydSteinberg, 100.5, []uint8{100, 101}},
+	{DitherBlueNoise, 100.5, []uint8{100, 101}},
+}
+
+func TestEncode_dither(t *testing.T) {
+	const v = 100*257 + 129
+	b := image.Rect(0, 0, 64, 64)
+
+	gray16 := image.NewGray16(b)
+	draw.Draw(gray16, b, image.NewUniform(color.Gray16{Y: v}), image.Point{}, draw.Src)
+	nrgba64 := image.NewNRGBA64(b)
+	draw.Draw(nrgba64, b, image.NewUniform(color.NRGBA64{R: v, G: v, B: v, A: 0xffff}), image.Point{}, draw.Src)
+	rgb48 := NewRGB48Image(b)
+	memp := NewMemPImage(b, 4, reflect.Uint16)
+	for _, p := range [][]uint8{rgb48.XPix, memp.XPix} {
+		for i := 0; i < len(p); i += 2 {
+			if isLittleEndian {
+				p[i+0], p[i+1] = v&0xff, v>>8
+			} else {
+				p[i+0], p[i+1] = v>>8, v&0xff
+			}
+		}
+	}
+	for i := 6; i < len(memp.XPix); i += 8 {
+		memp.XPix[i+0], memp.XPix[i+1] = 0xff, 0xff
+	}
+
+	for _, m := range []image.Image{gray16, nrgba64, rgb48, memp} {
+		for _, tt := range tDitherTesterList {
+			buf := new(bytes.Buffer)
+			tAssertNil(t, Encode(buf, m, &Options{Lossless: true, Dither: tt.Dither}))
+			m1, err := Decode(buf)
+			tAssertNil(t, err)
+
+			var sum float64
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					r, g, b, _ := m1.At(x, y).RGBA()
+					tAssert(t, r == g && g == b, fmt.Sprintf("%T", m), tt.Dither)
+					var ok bool
+					for _, want := range tt.Values {
+						ok = ok || uint8(r>>8) == want
+					}
+					tAssert(t, ok, fmt.Sprintf("%T", m), tt.Dither, r>>8)
+					sum += float64(r >> 8)
+				}
+			}
+			mean := sum / float64(b.Dx()*b.Dy())
+			if mean < tt.Mean-0.02 || mean > tt.Mean+0.02 {
+				t.Fatalf("%T, %v: mean = %.3f, want %.3f", m, tt.Dither, mean, tt.Mean)
+			}
+		}
+	}
+}
+
+func TestEncode_crop(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := image.NewNRGBA(image.Rect(-3, 5, 40, 30))
+	r.Read(m.Pix)
+	crop := image.Rect(1, 8, 20, 27)
+
+	buf := new(bytes.Buffer)
+	tAssertNil(t, Encode(buf, m, &Options{Lossless: true, Exact: true, Crop: crop}))
+	m1, err := Decode(buf)
+	tAssertNil(t, err)
+	tAssert(t, m1.Bounds() == image.Rect(0, 0, 19, 19), m1.Bounds())
+	for y := 0; y < 19; y++ {
+		for x := 0; x < 19; x++ {
+			c0 := m.NRGBAAt(crop.Min.X+x, crop.Min.Y+y)
+			c1 := color.NRGBAModel.Convert(m1.At(x, y)).(color.NRGBA)
+			tAssert(t, c0 == c1, x, y, c0, c1)
+		}
+	}
+
+	err = Encode(new(bytes.Buffer), m, &Options{Crop: image.Rect(0, 0, 50, 10)})
+	tAssert(t, err != nil, "crop outside of the image")
+}
+
+func TestEncode_resize(t *testing.T) {
+	b := image.Rect(0, 0, 400, 300)
+	for _, m := range []image.Image{
+		image.NewGray(b),
+		NewRGBImage(b),
+		image.NewNRGBA(b),
+		image.NewYCbCr(b, image.YCbCrSubsampleRatio420),
+	} {
+		for _, tt := range []struct {
+			Resize ResizeOptions
+			Crop   image.Rectangle
+			Want   image.Point
+		}{
+			{ResizeOptions{Width: 100, Height: 100}, image.Rectangle{}, image.Pt(100, 100)},
+			{ResizeOptions{Width: 200}, image.Rectangle{}, image.Pt(200, 150)},
+			{ResizeOptions{Height: 30}, image.Rectangle{}, image.Pt(40, 30)},
+			{ResizeOptions{Width: 100, Height: 100, Fit: true}, image.Rectangle{}, image.Pt(100, 75)},
+			{ResizeOptions{Width: 100, Height: 20, Fit: true}, image.Rectangle{}, image.Pt(27, 20)},
+			{ResizeOptions{Width: 50}, image.Rect(1, 1, 101, 201), image.Pt(50, 100)},
+		} {
+			for _, lossless := range []bool{false, true} {
+				resize := tt.Resize
+				buf := new(bytes.Buffer)
+				tAssertNil(t, Encode(buf, m, &Options{Lossless: lossless, Quality: 75, Resize: &resize, Crop: tt.Crop}))
+				width, height, _, err := GetInfo(buf.Bytes())
+				tAssertNil(t, err)
+				tAssert(t, image.Pt(width, height) == tt.Want, fmt.Sprintf("%T", m), tt.Resize, tt.Crop, width, height)
+			}
+		}
+	}
+
+	err := Encode(new(bytes.Buffer), image.NewGray(b), &Options{Resize: &ResizeOptions{}})
+	tAssert(t, err != nil, "empty resize size")
+}
+
+func TestEncode_modeAuto(t *testing.T) {
+	// flat tiles of 1024 colors
+	tiles := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			tiles.SetRGBA(x, y, color.RGBA{uint8(x / 8 * 8), uint8(y / 8 * 8), uint8(x / 8 * y / 8), 0xff})
+		}
+	}
+	for _, tt := range []struct {
+		Filename string
+		Image    image.Image
+		Want     autoEncoding
+	}{
+		{"gopher-doc.1bpp.png", nil, autoEncoding{true, 0, WEBP_PRESET_TEXT}},
+		{"tux.png", nil, autoEncoding{true, 0, WEBP_PRESET_DRAWING}},
+		{"video-001.png", nil, autoEncoding{false, 0, WEBP_PRESET_PICTURE}},
+		{"yellow_rose.png", nil, autoEncoding{false, 0, WEBP_PRESET_PHOTO}},
+		{"tiles", tiles, autoEncoding{true, analyzeNearLossless, WEBP_PRESET_DRAWING}},
+	} {
+		m := tt.Image
+		if m == nil {
+			var err error
+			m, err = loadImage(tt.Filename)
+			tAssertNil(t, err, tt.Filename)
+		}
+		got := analyzeImage(m).choose()
+		tAssertEQ(t, tt.Want, got, tt.Filename)
+
+		buf := new(bytes.Buffer)
+		tAssertNil(t, Encode(buf, m, &Options{Quality: 75, Mode: ModeAuto}), tt.Filename)
+		features, err := Features(buf.Bytes())
+		tAssertNil(t, err, tt.Filename)
+		tAssertEQ(t, tt.Want.Lossless, features.Format == FormatLossless, tt.Filename)
+	}
+}
+
+func TestEncode_modeAutoTrial(t *testing.T) {
+	m, err := loadImage("video-001.png")
+	tAssertNil(t, err)
+
+	for _, tt := range []struct {
+		MinPSNR float32
+		Want    Format
+	}{
+		{20, FormatLossy},
+		{99, FormatLossless},
+	} {
+		buf := new(bytes.Buffer)
+		tAssertNil(t, Encode(buf, m, &Options{Quality: 75, Mode: ModeAuto, Trial: true, MinPSNR: tt.MinPSNR}))
+		features, err := Features(buf.Bytes())
+		tAssertNil(t, err)
+		tAssertEQ(t, tt.Want, features.Format, tt.MinPSNR)
+	}
+}
+
+func TestEncode_nearLossless(t *testing.T) {
+	m, err := loadImage("video-001.png")
+	tAssertNil(t, err)
+
+	var last int
+	for _, strength := range []int{0, 40, 100} {
+		buf := new(bytes.Buffer)
+		tAssertNil(t, Encode(buf, m, &Options{Lossless: true, NearLossless: strength}), strength)
+		features, err := Features(buf.Bytes())
+		tAssertNil(t, err, strength)
+		tAssertEQ(t, FormatLossless, features.Format, strength)
+
+		m1, err := Decode(bytes.NewReader(buf.Bytes()))
+		tAssertNil(t, err, strength)
+		d, err := Distortion(m, m1, PSNR)
+		tAssertNil(t, err, strength)
+		if strength == 0 {
+			tAssertEQ(t, float32(99), d[4])
+		} else {
+			tAssert(t, d[4] > 30 && d[4] < 99, strength, d)
+			tAssert(t, buf.Len() < last, strength, buf.Len(), last)
+		}
+		last = buf.Len()
+	}
+
+	err = Encode(new(bytes.Buffer), m, &Options{Lossless: true, NearLossless: 101})
+	tAssert(t, err != nil, "invalid near-lossless strength")
+}
+
+func TestEncode_sharpYUV(t *testing.T) {
+	// red and blue stripes, with edges inside of the subsampled chroma
+	m := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if x%10 < 5 {
+				m.SetNRGBA(x, y, color.NRGBA{0xff, 0, 0, 0xff})
+			} else {
+				m.SetNRGBA(x, y, color.NRGBA{0, 0, 0xff, 0xff})
+			}
+		}
+	}
+
+	var psnr [2]float32
+	for i, sharp := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		tAssertNil(t, Encode(buf, m, &Options{Quality: 90, UseSharpYUV: sharp}), sharp)
+		m1, err := Decode(buf)
+		tAssertNil(t, err, sharp)
+		d, err := Distortion(m, m1, PSNR)
+		tAssertNil(t, err, sharp)
+		psnr[i] = d[4]
+	}
+	tAssert(t, psnr[1] > psnr[0], psnr)
+}
+
+func TestEncode_losslessLevel(t *testing.T) {
+	m, err := loadImage("video-001.png")
+	tAssertNil(t, err)
+	gray := convertToGray(m)
+	rgb := NewRGBImageFrom(gray)
+	nrgba := convertToNRGBA(gray)
+
+	sizes := make(map[int]int)
+	for _, level := range []int{FastestLosslessLevel, DefaultLosslessLevel, 3, BestLosslessLevel} {
+		var outputs [][]byte
+		for _, m := range []image.Image{gray, rgb, nrgba} {
+			buf := new(bytes.Buffer)
+			tAssertNil(t, Encode(buf, m, &Options{Lossless: true, LosslessLevel: &level}), level)
+			m1, err := Decode(bytes.NewReader(buf.Bytes()))
+			tAssertNil(t, err, level)
+			tAssertEQ(t, 0, averageDelta(gray, m1), level, fmt.Sprintf("%T", m))
+			outputs = append(outputs, buf.Bytes())
+		}
+		// the same picture for the gray, RGB and RGBA paths
+		tAssert(t, bytes.Equal(outputs[0], outputs[1]) && bytes.Equal(outputs[0], outputs[2]), level)
+		sizes[level] = len(outputs[0])
+	}
+	tAssert(t, sizes[BestLosslessLevel] < sizes[FastestLosslessLevel], sizes)
+
+	// nil is the default level
+	buf := new(bytes.Buffer)
+	tAssertNil(t, Encode(buf, gray, &Options{Lossless: true}))
+	tAssertEQ(t, sizes[DefaultLosslessLevel], buf.Len())
+
+	for _, level := range []int{-1, 10} {
+		err = Encode(new(bytes.Buffer), m, &Options{Lossless: true, LosslessLevel: &level})
+		tAssert(t, err != nil, "invalid lossless level", level)
+	}
+}
+
+func TestEncode_exactAlpha(t *testing.T) {
+	m, err := loadImage("video-001.png")
+	tAssertNil(t, err)
+	nrgba := convertToNRGBA(m)
+	b := nrgba.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			nrgba.Pix[nrgba.PixOffset(x, y)+3] = uint8(x*255/b.Dx()) | 1
+		}
+	}
+
+	for _, tt := range []struct {
+		opt   Options
+		exact bool
+	}{
+		{Options{Lossless: true, NearLossless: 100, ExactAlpha: true}, true},
+		{Options{Lossless: true, NearLossless: 100}, false},
+		{Options{Quality: 50}, true}, // the alpha plane of lossy is lossless
+	} {
+		buf := new(bytes.Buffer)
+		tAssertNil(t, Encode(buf, nrgba, &tt.opt), tt.opt)
+		m1, err := DecodeNRGBA(buf.Bytes())
+		tAssertNil(t, err, tt.opt)
+		exact := true
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if nrgba.Pix[nrgba.PixOffset(x, y)+3] != m1.Pix[m1.PixOffset(x, y)+3] {
+					exact = false
+				}
+			}
+		}
+		tAssertEQ(t, tt.exact, exact, tt.opt)
+	}
+}
+
+func TestEncode_quantize(t *testing.T) {
+	m, err := loadImage("tux.png")
+	tAssertNil(t, err)
+
+	encode := func(q *QuantizeOptions) ([]byte, *image.NRGBA) {
+		buf := new(bytes.Buffer)
+		tAssertNil(t, Encode(buf, m, &Options{Lossless: true, Quantize: q}), q)
+		m1, err := DecodeNRGBA(buf.Bytes())
+		tAssertNil(t, err, q)
+		return buf.Bytes(), m1
+	}
+	countColors := func(m *image.NRGBA) int {
+		colors := make(map[[4]uint8]bool)
+		for i := 0; i < len(m.Pix); i += 4 {
+			if m.Pix[i+3] != 0 {
+				colors[[4]uint8(m.Pix[i:][:4])] = true
+			}
+		}
+		return len(colors)
+	}
+
+	lossless, _ := encode(nil)
+	for _, q := range []*QuantizeOptions{
+		{},
+		{MaxColors: 16},
+		{MaxColors: 64, Dither: 1},
+	} {
+		data, m1 := encode(q)
+		maxColors := q.MaxColors
+		if maxColors == 0 {
+			maxColors = 256
+		}
+		tAssert(t, countColors(m1) <= maxColors, q, countColors(m1))
+		tAssert(t, len(data) < len(lossless)*2/3, q, len(data), len(lossless))
+
+		d, err := Distortion(m, m1, PSNR)
+		tAssertNil(t, err, q)
+		tAssert(t, d[4] > 30, q, d)
+	}
+
+	// below the quality floor
+	data, _ := encode(&QuantizeOptions{MaxColors: 2, MinPSNR: 60})
+	tAssert(t, bytes.Equal(data, lossless))
+
+	// few colors
+	_, ok := quantizeImage(image.NewGray(image.Rect(0, 0, 8, 8)), &QuantizeOptions{})
+	tAssert(t, !ok)
+
+	// the lossy encoder has no palette
+	tAssert(t, Encode(new(bytes.Buffer), m, &Options{Quantize: &QuantizeOptions{}}) != nil)
+	tAssertNil(t, Encode(new(bytes.Buffer), m, &Options{Mode: ModeAuto, Quantize: &QuantizeOptions{}}))
+}
+
+// tLimitWriter fails after N bytes, and writes in chunks of at most Chunk
+// bytes.
+type tLimitWriter struct {
+	Buf   bytes.Buffer
+	N     int
+	Chunk int
+	Calls int
+}
+
+var errLimitWriter = fmt.Errorf("tLimitWriter: limit reached")
+
+func (w *tLimitWriter) Write(p []byte) (int, error) {
+	w.Calls++
+	if w.Chunk > 0 && len(p) > w.Chunk {
+		p = p[:w.Chunk]
+	}
+	if w.N >= 0 && w.Buf.Len()+len(p) > w.N {
+		return 0, errLimitWriter
+	}
+	return w.Buf.Write(p)
+}
+
+func TestEncode_writer(t *testing.T) {
+	m, err := loadImage("video-001.png")
+	tAssertNil(t, err)
+
+	for _, opt := range []*Options{
+		{Quality: 75},
+		{Lossless: true},
+	} {
+		want := new(bytes.Buffer)
+		tAssertNil(t, Encode(want, m, opt), opt)
+
+		// streamed in several writes
+		w := &tLimitWriter{N: -1}
+		tAssertNil(t, Encode(w, m, opt), opt)
+		tAssert(t, bytes.Equal(w.Buf.Bytes(), want.Bytes()), opt)
+		tAssert(t, w.Calls > 1, opt, w.Calls)
+
+		// a writer error aborts
+		w = &tLimitWriter{N: want.Len() / 2}
+		err := Encode(w, m, opt)
+		tAssert(t, err == errLimitWriter, opt, err)
+		tAssert(t, w.Buf.Len() <= want.Len()/2, opt)
+
+		// a short write is an error
+		w = &tLimitWriter{N: -1, Chunk: 8}
+		err = Encode(w, m, opt)
+		tAssert(t, err == io.ErrShortWrite, opt, err)
+	}
+}
+
+func TestStripEncoder(t *testing.T) {
+	for _, filename := range []string{"video-001.png", "tux.png"} {
+		m, err := loadImage(filename)
+		tAssertNil(t, err, filename)
+		p := toNRGBAImage(m)
+		b := p.Bounds()
+
+		for _, opt := range []*Options{
+			{Quality: 75},
+			{Lossless: true},
+			{Quality: 75, Crop: image.Rect(3, 5, 60, 40)},
+		} {
+			want := new(bytes.Buffer)
+			tAssertNil(t, Encode(want, p, opt), filename, opt)
+
+			for _, strip := range []int{1, 2, 7, b.Dy()} {
+				buf := new(bytes.Buffer)
+				enc, err := NewStripEncoder(buf, b.Dx(), b.Dy(), opt)
+				tAssertNil(t, err, filename, opt)
+				for y := 0; y < b.Dy(); y += strip {
+					r := image.Rect(0, y, b.Dx(), y+strip).Intersect(b)
+					pix := p.Pix[p.PixOffset(0, r.Min.Y):p.PixOffset(0, r.Max.Y)]
+					tAssertNil(t, enc.WriteRows(pix, p.Stride), filename, opt, strip, y)
+				}
+				tAssertEQ(t, enc.Rows(), b.Dy())
+				tAssertNil(t, enc.Close(), filename, opt, strip)
+				tAssert(t, bytes.Equal(buf.Bytes(), want.Bytes()), filename, opt, strip)
+			}
+		}
+	}
+}
+
+func TestStripEncoder_errors(t *testing.T) {
+	_, err := NewStripEncoder(io.Discard, WEBP_MAX_DIMENSION+1, 16, nil)
+	tAssert(t, err != nil)
+	_, err = NewStripEncoder(io.Discard, 16, 0, nil)
+	tAssert(t, err != nil)
+	_, err = NewStripEncoder(io.Discard, 16, 16, &Options{Mode: ModeAuto})
+	tAssert(t, err != nil)
+
+	enc, err := NewStripEncoder(io.Discard, 16, 4, nil)
+	tAssertNil(t, err)
+	pix := make([]byte, 16*4*3)
+	tAssert(t, enc.WriteRows(pix, 16*2) != nil) // short stride
+	tAssertNil(t, enc.WriteRows(pix, 16*4))
+	tAssert(t, enc.WriteRows(pix, 16*4) != nil) // too many rows
+	tAssertEQ(t, enc.Rows(), 3)
+	tAssert(t, enc.Close() != nil) // missing rows
+	tAssert(t, enc.Close() != nil)
+	tAssert(t, enc.WriteRows(pix, 16*4) != nil)
+}
+
+func TestEncoder(t *testing.T) {
+	m, err := loadImage("tux.png")
+	tAssertNil(t, err)
+	nrgba := toNRGBAImage(m)
+	rgba := toRGBAImage(m)
+	gray := toGrayImage(m)
+
+	for _, opt := range []*Options{
+		nil,
+		{Quality: 75},
+		{Lossless: true},
+		{Quality: 75, UseSharpYUV: true},
+		{Lossless: true, Crop: image.Rect(10, 20, 100, 120)},
+	} {
+		enc, err := NewEncoder(opt)
+		tAssertNil(t, err, opt)
+		for _, src := range []image.Image{nrgba, rgba, gray, nrgba.SubImage(image.Rect(5, 7, 150, 160))} {
+			want := new(bytes.Buffer)
+			tAssertNil(t, Encode(want, src, opt), opt)
+
+			// the pooled pictures are reused
+			for i := 0; i < 3; i++ {
+				got := new(bytes.Buffer)
+				tAssertNil(t, enc.Encode(got, src), opt)
+				tAssert(t, bytes.Equal(got.Bytes(), want.Bytes()), opt, fmt.Sprintf("%T", src), i)
+			}
+		}
+	}
+
+	_, err = NewEncoder(&Options{Quality: 101})
+	tAssert(t, err != nil)
+	level := 10
+	_, err = NewEncoder(&Options{LosslessLevel: &level})
+	tAssert(t, err != nil)
+	enc, err := NewEncoder(&Options{Crop: image.Rect(0, 0, 1000, 10)})
+	tAssertNil(t, err)
+	tAssert(t, enc.Encode(io.Discard, m) != nil)
+}
+
+func TestEncoder_concurrent(t *testing.T) {
+	m, err := loadImage("video-001.png")
+	tAssertNil(t, err)
+	want := new(bytes.Buffer)
+	tAssertNil(t, Encode(want, m, &Options{Quality: 75}))
+
+	enc, err := NewEncoder(&Options{Quality: 75})
+	tAssertNil(t, err)
+	var wg sync.WaitGroup
+	results := make([][]byte, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				buf := new(bytes.Buffer)
+				if err := enc.Encode(buf, m); err != nil {
+					return
+				}
+				results[i] = buf.Bytes()
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := range results {
+		tAssert(t, bytes.Equal(results[i], want.Bytes()), i)
+	}
+}
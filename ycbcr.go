@@ -0,0 +1,127 @@
+// Copyright 2014 <chaishushan{AT}gmail.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"image"
+	"math"
+)
+
+// Go's image.YCbCr uses the JFIF full range, libwebp uses the BT.601
+// limited range (Y: 16~235, Cb/Cr: 16~240). The samples are mapped in both
+// directions, so that a decode and encode round trip keeps the range.
+var (
+	yFullToLimited [256]uint8
+	cFullToLimited [256]uint8
+	yLimitedToFull [256]uint8
+	cLimitedToFull [256]uint8
+)
+
+func init() {
+	clamp := func(v float64) uint8 { return uint8(math.Round(math.Max(0, math.Min(255, v)))) }
+	for i := 0; i < 256; i++ {
+		yFullToLimited[i] = uint8(math.Round(16 + float64(i)*219/255))
+		cFullToLimited[i] = uint8(math.Round(128 + (float64(i)-128)*224/255))
+		yLimitedToFull[i] = clamp((float64(i) - 16) * 255 / 219)
+		cLimitedToFull[i] = clamp(128 + (float64(i)-128)*255/224)
+	}
+}
+
+// expandYCbCr maps the limited range planes of p, as decoded by libwebp,
+// to the full range in place.
+func expandYCbCr(p *image.YCbCr) {
+	for i, v := range p.Y {
+		p.Y[i] = yLimitedToFull[v]
+	}
+	for i, v := range p.Cb {
+		p.Cb[i] = cLimitedToFull[v]
+	}
+	for i, v := range p.Cr {
+		p.Cr[i] = cLimitedToFull[v]
+	}
+}
+
+// yuv420Image is a tightly packed YUV 4:2:0 image in the limited range,
+// ready to be imported by the encoder.
+type yuv420Image struct {
+	Y, U, V, A []uint8
+	YStride    int
+	UVStride   int
+	AStride    int
+	Rect       image.Rectangle
+}
+
+// newYUV420ImageFrom converts an *image.YCbCr or *image.NYCbCrA to
+// yuv420Image. Subsample ratios other than 4:2:0 are resampled by
+// averaging the chroma samples covering each 2x2 luma block.
+func newYUV420ImageFrom(m image.Image) (p *yuv420Image, ok bool) {
+	var src *image.YCbCr
+	var alpha *image.NYCbCrA
+	switch m := m.(type) {
+	case *image.YCbCr:
+		src = m
+	case *image.NYCbCrA:
+		src, alpha = &m.YCbCr, m
+	default:
+		return nil, false
+	}
+
+	b := src.Rect
+	w, h := b.Dx(), b.Dy()
+	uvw, uvh := (w+1)/2, (h+1)/2
+	p = &yuv420Image{
+		Y:        make([]uint8, w*h),
+		U:        make([]uint8, uvw*uvh),
+		V:        make([]uint8, uvw*uvh),
+		YStride:  w,
+		UVStride: uvw,
+		Rect:     image.Rect(0, 0, w, h),
+	}
+
+	for y := 0; y < h; y++ {
+		row := src.Y[src.YOffset(b.Min.X, b.Min.Y+y):][:w]
+		dst := p.Y[y*w:][:w]
+		for x, v := range row {
+			dst[x] = yFullToLimited[v]
+		}
+	}
+
+	if src.SubsampleRatio == image.YCbCrSubsampleRatio420 && b.Min.X%2 == 0 && b.Min.Y%2 == 0 {
+		for y := 0; y < uvh; y++ {
+			off := src.COffset(b.Min.X, b.Min.Y+2*y)
+			cb, cr := src.Cb[off:][:uvw], src.Cr[off:][:uvw]
+			du, dv := p.U[y*uvw:][:uvw], p.V[y*uvw:][:uvw]
+			for x := 0; x < uvw; x++ {
+				du[x] = cFullToLimited[cb[x]]
+				dv[x] = cFullToLimited[cr[x]]
+			}
+		}
+	} else {
+		for y := 0; y < uvh; y++ {
+			for x := 0; x < uvw; x++ {
+				var sumCb, sumCr, n int
+				for dy := 0; dy < 2 && 2*y+dy < h; dy++ {
+					for dx := 0; dx < 2 && 2*x+dx < w; dx++ {
+						off := src.COffset(b.Min.X+2*x+dx, b.Min.Y+2*y+dy)
+						sumCb += int(src.Cb[off])
+						sumCr += int(src.Cr[off])
+						n++
+					}
+				}
+				p.U[y*uvw+x] = cFullToLimited[(sumCb+n/2)/n]
+				p.V[y*uvw+x] = cFullToLimited[(sumCr+n/2)/n]
+			}
+		}
+	}
+
+	if alpha != nil {
+		p.A = make([]uint8, w*h)
+		p.AStride = w
+		for y := 0; y < h; y++ {
+			copy(p.A[y*w:][:w], alpha.A[alpha.AOffset(b.Min.X, b.Min.Y+y):][:w])
+		}
+	}
+	return p, true
+}